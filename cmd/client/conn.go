@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"console-chat/internal/protocol"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+)
+
+// serverTimeout is how long client waits for any frame from the server,
+// including pings, before deciding that the server is gone
+const serverTimeout = 90 * time.Second
+
+// ChatConn is a websocket connection to the chat server. It answers server
+// pings with pongs and serializes all writes, so pongs never interleave with
+// messages written by the user
+type ChatConn struct {
+	conn     net.Conn
+	mu       sync.Mutex
+	rd       *wsutil.Reader
+	response bytes.Buffer
+}
+
+func NewChatConn(conn net.Conn) *ChatConn {
+	c := &ChatConn{
+		conn: conn,
+	}
+	controlHandler := wsutil.ControlFrameHandler(&c.response, ws.StateClientSide)
+	c.rd = &wsutil.Reader{
+		Source:    conn,
+		State:     ws.StateClientSide,
+		CheckUTF8: true,
+		OnIntermediate: func(hdr ws.Header, r io.Reader) error {
+			return c.handleControl(controlHandler, hdr, r)
+		},
+	}
+	return c
+}
+
+// handleControl handles control frame and writes response to the server
+func (c *ChatConn) handleControl(handler wsutil.FrameHandlerFunc, hdr ws.Header, r io.Reader) error {
+	c.response.Reset()
+	err := handler(hdr, r)
+	if c.response.Len() != 0 {
+		c.mu.Lock()
+		_, writeErr := c.conn.Write(c.response.Bytes())
+		c.mu.Unlock()
+		if err == nil {
+			err = writeErr
+		}
+	}
+	return err
+}
+
+// WriteFrame encodes frame and sends it to the server
+func (c *ChatConn) WriteFrame(f protocol.Frame) error {
+	data, err := protocol.Encode(f)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return wsutil.WriteClientMessage(c.conn, ws.OpText, data)
+}
+
+// ReadFrame reads next data frame from the server and decodes it, control
+// frames are handled on the way
+func (c *ChatConn) ReadFrame() (protocol.Frame, error) {
+	for {
+		if err := c.conn.SetReadDeadline(time.Now().Add(serverTimeout)); err != nil {
+			return protocol.Frame{}, err
+		}
+		hdr, err := c.rd.NextFrame()
+		if err != nil {
+			return protocol.Frame{}, err
+		}
+		if hdr.OpCode.IsControl() {
+			if err := c.rd.OnIntermediate(hdr, c.rd); err != nil {
+				return protocol.Frame{}, err
+			}
+			continue
+		}
+
+		data, err := io.ReadAll(c.rd)
+		if err != nil {
+			return protocol.Frame{}, err
+		}
+		return protocol.Decode(data)
+	}
+}
+
+// DescribeClose returns human-readable reason of the close frame sent by the
+// server, ok is false if err isn't caused by the close frame
+func DescribeClose(err error) (string, bool) {
+	closed, ok := err.(wsutil.ClosedError)
+	if !ok {
+		return "", false
+	}
+	switch closed.Code {
+	case protocol.CloseGoingAway:
+		return "server is shutting down", true
+	case protocol.CloseTokenExpired:
+		return "token has expired, sign in again", true
+	case protocol.CloseBadToken:
+		return "token is invalid, sign in again", true
+	case protocol.CloseTokenRevoked:
+		return "you have logged out, sign in again", true
+	}
+	if closed.Reason == "" {
+		return fmt.Sprintf("connection closed with code %d", closed.Code), true
+	}
+	return fmt.Sprintf("%s (code %d)", closed.Reason, closed.Code), true
+}
+
+func (c *ChatConn) Close() error {
+	return c.conn.Close()
+}
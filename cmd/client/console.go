@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/term"
+)
+
+// prompt ends the status at the start of the input line
+const prompt = "> "
+
+// Console reads lines typed by the user and prints everything else above the
+// input line. The input line starts with a status, like who is typing now.
+// If stdin isn't a terminal, lines are read as is without status and
+// keypresses aren't reported
+type Console struct {
+	terminal *term.Terminal // nil if stdin isn't a terminal
+	reader   *bufio.Reader
+	restore  func()
+
+	mu     sync.Mutex // serializes output of the console without terminal
+	status string
+}
+
+// NewConsole switches terminal to raw mode, onKey is called with the input
+// line whenever the user types a printable character
+func NewConsole(onKey func(line string)) (*Console, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return &Console{
+			reader:  bufio.NewReader(os.Stdin),
+			restore: func() {},
+		}, nil
+	}
+
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return nil, err
+	}
+	terminal := term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}, prompt)
+	terminal.AutoCompleteCallback = func(line string, pos int, key rune) (string, int, bool) {
+		if key >= ' ' && key != 0x7f {
+			onKey(line[:pos] + string(key) + line[pos:])
+		}
+		return "", 0, false
+	}
+	return &Console{
+		terminal: terminal,
+		restore: func() {
+			_ = term.Restore(fd, state)
+		},
+	}, nil
+}
+
+// ReadLine reads the next line typed by the user, io.EOF is returned on
+// Ctrl+C or Ctrl+D
+func (c *Console) ReadLine() (string, error) {
+	if c.terminal != nil {
+		return c.terminal.ReadLine()
+	}
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(line, "\n"), nil
+}
+
+// Write prints text above the input line
+func (c *Console) Write(p []byte) (int, error) {
+	if c.terminal != nil {
+		return c.terminal.Write(p)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return os.Stdout.Write(p)
+}
+
+// SetStatus shows status at the start of the input line, empty status hides it
+func (c *Console) SetStatus(status string) {
+	c.mu.Lock()
+	changed := c.status != status
+	c.status = status
+	c.mu.Unlock()
+	if !changed || c.terminal == nil {
+		return
+	}
+
+	if status != "" {
+		status = "(" + status + ") "
+	}
+	c.terminal.SetPrompt(status + prompt)
+	_, _ = c.terminal.Write(nil) // repaints the input line with new prompt
+}
+
+// Close returns terminal to the state it had before the console
+func (c *Console) Close() {
+	c.restore()
+}
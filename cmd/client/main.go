@@ -2,32 +2,29 @@ package main
 
 import (
 	"bufio"
-	"context"
+	"bytes"
+	"console-chat/internal/protocol"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"net/url"
 	"os"
 	"strings"
 	"time"
 
-	"github.com/gobwas/ws"
-	"github.com/gobwas/ws/wsutil"
 	"golang.org/x/term"
 )
 
 const httpUrl = "http://localhost:8080/console-chat/users"
+const sessionsUrl = "http://localhost:8080/console-chat/sessions"
 const wsUrl = "ws://localhost:8080/console-chat/chat"
 
 type registerResponse struct {
-	Data struct {
-		Nickname       string `json:"nickname"`
-		HashedPassword string `json:"hashed_password"`
-	} `json:"data"`
-	Error string `json:"error"`
+	Data  protocol.User `json:"data"`
+	Error string        `json:"error"`
 }
 
 // RegisterNewUser gets new user nickname & password from stdin and makes http request to register new user
@@ -103,23 +100,9 @@ func RegisterNewUser() {
 	}
 }
 
-func IsValidUrl(urlStr string) bool {
-	parsedURL, err := url.Parse(urlStr)
-	if err != nil {
-		return false
-	}
-
-	return parsedURL.Scheme != "" && parsedURL.Host != ""
-}
-
-type signInResponse struct {
-	Data struct {
-		TokenString string `json:"token_string"`
-	} `json:"data"`
-	Error string `json:"error"`
-}
-
-func SignIn() string {
+// SignIn asks user for nickname and password until they are right and starts
+// new session
+func SignIn() *Session {
 	for {
 		// getting user nickname
 		fmt.Print("Enter your nickname: ")
@@ -138,19 +121,16 @@ func SignIn() string {
 		}
 		fmt.Println()
 
-		// check if the message to http server is valid
-		jsonStr := fmt.Sprintf(`{
-			"password": "%s"
-		}`, string(password))
-		getUrl := httpUrl + "/" + nickname
-		if !(json.Valid([]byte(jsonStr)) && IsValidUrl(getUrl)) {
-			fmt.Println("Don't use symbols like \", ` or '. Please try again.")
-			continue
-		}
-
 		// making request to the http server and getting the response
+		reqBody, err := json.Marshal(map[string]string{
+			"nickname": nickname,
+			"password": string(password),
+		})
+		if err != nil {
+			log.Fatal("request body marshalling error:", err.Error())
+		}
 		client := &http.Client{}
-		req, err := http.NewRequest(http.MethodGet, getUrl, strings.NewReader(jsonStr))
+		req, err := http.NewRequest(http.MethodPost, sessionsUrl, bytes.NewReader(reqBody))
 		if err != nil {
 			log.Fatal("request error:", err.Error())
 		}
@@ -168,7 +148,7 @@ func SignIn() string {
 		if err != nil {
 			log.Fatal("response body read error:", err.Error())
 		}
-		var signResp signInResponse
+		var signResp tokenResponse
 		if err := json.Unmarshal(body, &signResp); err != nil {
 			log.Fatal("response body unmarshalling error:", err.Error())
 		}
@@ -181,65 +161,281 @@ func SignIn() string {
 		case "wrong password of required user":
 			fmt.Println("Wrong password of user with nickname", nickname)
 			continue
+		case "too many failed sign in attempts, try again later":
+			fmt.Println("Too many failed attempts, try again in", res.Header.Get("Retry-After"), "seconds")
+			continue
 		case "":
 			fmt.Println("Successfully signed in")
-			return signResp.Data.TokenString
+			return NewSession(signResp.Data)
+		default:
+			fmt.Println("Can't sign in:", signResp.Error)
+			if retryAfter := res.Header.Get("Retry-After"); retryAfter != "" {
+				fmt.Println("Try again in", retryAfter, "seconds")
+			}
+			continue
+		}
+	}
+}
+
+// PrintFrame prints frame received from the server, typing frames are
+// shown in status instead
+func PrintFrame(w io.Writer, f protocol.Frame) {
+	timestamp := f.Timestamp.Local().Format("15:04")
+	switch f.Type {
+	case protocol.TypeMessage:
+		if f.To != "" {
+			fmt.Fprintf(w, "%s [dm] %s -> %s: %s\n", timestamp, f.Sender, f.To, f.Body)
+		} else {
+			fmt.Fprintf(w, "%s [%s] %s: %s\n", timestamp, f.Room, f.Sender, f.Body)
+		}
+	case protocol.TypeSystem:
+		if f.Room != "" {
+			fmt.Fprintf(w, "%s [%s] * %s\n", timestamp, f.Room, f.Body)
+		} else {
+			fmt.Fprintf(w, "%s * %s\n", timestamp, f.Body)
+		}
+	case protocol.TypeError:
+		if f.Error != nil {
+			fmt.Fprintln(w, "error:", f.Error.Message)
+		}
+	}
+}
+
+// ParseInput converts line typed by user to the frame: "/dm <nickname> <text>"
+// is a direct message, other lines starting with / are commands and the rest
+// are messages to the current room
+func ParseInput(text string) (protocol.Frame, bool) {
+	if strings.HasPrefix(text, "/dm ") || text == "/dm" {
+		fields := strings.SplitN(strings.TrimPrefix(text, "/dm"), " ", 3)
+		if len(fields) != 3 || fields[1] == "" || fields[2] == "" {
+			return protocol.Frame{}, false
 		}
+		return protocol.Frame{
+			Type: protocol.TypeMessage,
+			To:   fields[1],
+			Body: fields[2],
+		}, true
+	}
+
+	f := protocol.Frame{
+		Type: protocol.TypeMessage,
+		Body: text,
 	}
+	if strings.HasPrefix(text, "/") {
+		f.Type = protocol.TypeCommand
+	}
+	return f, true
+}
+
+// Authorize sends auth frame with the token and waits for server's ack,
+// returns nickname of the user. Resume is sent by the client which
+// reconnects after losing the connection
+func Authorize(conn *ChatConn, token string, resume map[string]int64) (string, error) {
+	auth := protocol.Frame{
+		Type:     protocol.TypeAuth,
+		Body:     token,
+		Versions: protocol.SupportedVersions,
+	}
+	if resume != nil {
+		auth.Event = protocol.AuthResume
+		auth.Resume = resume
+	}
+	if err := conn.WriteFrame(auth); err != nil {
+		return "", err
+	}
+
+	f, err := conn.ReadFrame()
+	if err != nil {
+		return "", err
+	}
+	switch f.Type {
+	case protocol.TypeAck:
+		return f.Sender, nil
+	case protocol.TypeError:
+		if f.Error != nil {
+			return "", f.Error
+		}
+	}
+	return "", protocol.ErrInvalidFrame
+}
+
+// readFrames reads frames from the server and passes them to handle until
+// the connection is lost, returns the reason
+func readFrames(conn *ChatConn, handle func(f protocol.Frame)) error {
+	for {
+		f, err := conn.ReadFrame()
+		if err == protocol.ErrInvalidFrame {
+			continue
+		} else if err != nil {
+			return err
+		}
+		handle(f)
+	}
+}
+
+// Logout signs out of the saved session
+func Logout() {
+	session, err := LoadSession()
+	if err == nil {
+		// access token of the saved session may be already expired
+		err = session.Refresh()
+	}
+	if err == nil {
+		err = session.Logout()
+	} else if err == errSessionExpired || errors.Is(err, os.ErrNotExist) {
+		err = DeleteSession()
+	}
+	if err != nil {
+		log.Fatal("can't log out: ", err.Error())
+	}
+	fmt.Println("Successfully logged out")
 }
 
 func main() {
 	reg := flag.Bool("reg", false, "Flag to register new user")
 	sign := flag.Bool("sign", false, "Flag to sign in and join the chat")
+	logout := flag.Bool("logout", false, "Flag to log out of the saved session")
 	flag.Parse()
 
-	if *reg == *sign {
-		fmt.Print("\nThis is console-chat client. Run this program with \"-reg\" flag to register new user or \"-sign\" flag to sign in and join the chat\n\n")
+	if *logout {
+		Logout()
+	} else if *reg == *sign {
+		fmt.Print("\nThis is console-chat client. Run this program with \"-reg\" flag to register new user, \"-sign\" flag to sign in and join the chat or \"-logout\" flag to log out\n\n")
 	} else if *reg { // registration of the new user
 		RegisterNewUser()
 	} else { // signing in and connecting to the chat
-		token := SignIn()
+		// signing in silently with the saved session or with password
+		session, err := LoadSession()
+		if err == nil {
+			err = session.Refresh()
+		}
+		if err == nil {
+			fmt.Println("Successfully signed in with saved session")
+		} else {
+			session = SignIn()
+			if err := session.Save(); err != nil {
+				log.Println("can't save session:", err.Error())
+			}
+		}
+		go session.KeepFresh()
 
-		// connecting to websocket server
-		conn, _, _, err := ws.DefaultDialer.Dial(context.Background(), wsUrl)
+		// connecting to websocket server and sending token to authorize
+		conn, nickname, err := Connect(session, nil)
 		if err != nil {
-			log.Fatalf("can't dial connection: %s", err.Error())
+			log.Fatal("can't connect to chat: ", DescribeError(err))
 		}
+		link := NewLink(conn)
 
-		// sending token to authorize
-		if err := wsutil.WriteClientMessage(conn, ws.OpText, []byte(token)); err != nil {
-			log.Fatal("can't wtite client token:", err.Error())
-		} else {
-			fmt.Println("Successfully connected to chat. Start writing messages!")
+		// console shows who is typing and tells others when the user types
+		notifier := NewTypingNotifier(link.WriteFrame)
+		console, err := NewConsole(notifier.Keypress)
+		if err != nil {
+			log.Fatal("can't open console: ", err.Error())
+		}
+		exit := func(v ...any) {
+			console.Close()
+			log.Println(v...)
+			os.Exit(0)
+		}
+		fail := func(v ...any) {
+			console.Close()
+			log.Fatal(v...)
+		}
+		fmt.Fprintln(console, "Successfully connected to chat. Start writing messages or type /help to see commands!")
+		typing := NewTypingStatus(nickname)
+		outbox := NewOutbox()
+		cursor := NewCursor()
+		updateStatus := func() {
+			var parts []string
+			if !link.Connected() {
+				parts = append(parts, "reconnecting")
+			}
+			for _, part := range []string{outbox.String(), typing.String()} {
+				if part != "" {
+					parts = append(parts, part)
+				}
+			}
+			console.SetStatus(strings.Join(parts, "; "))
 		}
+		go func() {
+			for now := range time.Tick(time.Second) {
+				for _, msg := range outbox.Late(now) {
+					fmt.Fprintln(console, "message isn't confirmed by the server yet:", msg.Body)
+				}
+				if typing.Expire(now) {
+					updateStatus()
+				}
+			}
+		}()
 
-		// reading messages from the server
+		// reading frames from the server, lost connection is opened again
+		// and messages which weren't acked are sent again
 		go func() {
+			backoff := &Backoff{}
 			for {
-				data, _, err := wsutil.ReadServerData(conn)
-				if err != nil && err != io.EOF {
-					log.Fatal("can't read server data:", err.Error())
-				} else if err == io.EOF {
-					log.Println("server stopped")
-					os.Exit(0)
+				err := readFrames(conn, func(f protocol.Frame) {
+					cursor.Seen(f)
+					// ack of the message removes it from status, error also
+					// tells which message has failed
+					if msg, ok := outbox.Resolve(f); ok {
+						updateStatus()
+						if f.Type == protocol.TypeError {
+							PrintFrame(console, f)
+							fmt.Fprintln(console, "message wasn't sent:", msg.Body)
+						}
+						return
+					}
+					if typing.Update(f, time.Now()) {
+						updateStatus()
+					}
+					PrintFrame(console, f)
+				})
+				link.Set(nil)
+				_ = conn.Close()
+				if !Reconnectable(err) {
+					exit("disconnected from the chat:", DescribeError(err))
 				}
+				updateStatus()
+				fmt.Fprintln(console, "connection lost:", DescribeError(err)+", reconnecting")
 
-				fmt.Println(string(data))
+				conn, err = Reconnect(session, cursor.Resume(), backoff, err)
+				if err != nil {
+					exit("can't reconnect to the chat:", DescribeError(err))
+				}
+				backoff.Reset()
+				link.Set(conn)
+				updateStatus()
+				fmt.Fprintln(console, "reconnected to the chat")
+				for _, f := range outbox.Pending() {
+					_ = link.WriteFrame(f)
+				}
 			}
 		}()
 
-		reader := bufio.NewReader(os.Stdin)
-
-		// sending messages from the user
+		// sending messages and commands from the user
 		for {
-			text, err := reader.ReadString('\n')
-			if err != nil {
-				log.Fatalf("can't read string from stdin: %s", err.Error())
+			text, err := console.ReadLine()
+			if err == io.EOF {
+				notifier.Stop()
+				exit("left the chat")
+			} else if err != nil {
+				fail("can't read string from stdin:", err.Error())
 			}
-			text = text[:len(text)-1]
 
-			if err := wsutil.WriteClientMessage(conn, ws.OpText, []byte(text)); err != nil {
-				log.Fatal("can't wtite client message:", err.Error())
+			notifier.Sent(text)
+			f, ok := ParseInput(text)
+			if !ok {
+				fmt.Fprintln(console, "usage: /dm <nickname> <message>")
+				continue
+			}
+			// message written while the client is disconnected stays pending
+			// and is sent after reconnect
+			if f.Type == protocol.TypeMessage {
+				f = outbox.Add(f, time.Now())
+				updateStatus()
+			}
+			if err := link.WriteFrame(f); err != nil && f.Type != protocol.TypeMessage {
+				fmt.Fprintln(console, "command wasn't sent:", err.Error())
 			}
 
 			time.Sleep(100 * time.Millisecond) // delay between sending messages
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"console-chat/internal/protocol"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ackTimeout is how long message may wait for server's ack before the user
+// is told that it isn't confirmed yet
+const ackTimeout = 10 * time.Second
+
+// pendingMessage is a message sent to the server which wasn't acked yet
+type pendingMessage struct {
+	frame  protocol.Frame
+	sentAt time.Time
+	late   bool // user was told that the message isn't confirmed
+}
+
+// Outbox assigns IDs to messages of the user and keeps them until server
+// acks or rejects them. Server handles message with the same ID only once,
+// so pending messages may be sent again
+type Outbox struct {
+	mu      sync.Mutex
+	pending map[string]*pendingMessage
+}
+
+func NewOutbox() *Outbox {
+	return &Outbox{
+		pending: make(map[string]*pendingMessage),
+	}
+}
+
+// newMessageID generates random ID of the message
+func newMessageID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Add assigns ID to the message frame and keeps it as pending
+func (o *Outbox) Add(f protocol.Frame, now time.Time) protocol.Frame {
+	f.ID = newMessageID()
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.pending[f.ID] = &pendingMessage{
+		frame:  f,
+		sentAt: now,
+	}
+	return f
+}
+
+// Resolve removes pending message which ack or error frame replies to,
+// returns the message and false if frame doesn't reply to pending message
+func (o *Outbox) Resolve(f protocol.Frame) (protocol.Frame, bool) {
+	if (f.Type != protocol.TypeAck && f.Type != protocol.TypeError) || f.ReplyTo == "" {
+		return protocol.Frame{}, false
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	msg, ok := o.pending[f.ReplyTo]
+	if !ok {
+		return protocol.Frame{}, false
+	}
+	delete(o.pending, f.ReplyTo)
+	return msg.frame, true
+}
+
+// Late returns messages which weren't acked within ackTimeout, every message
+// is returned only once
+func (o *Outbox) Late(now time.Time) []protocol.Frame {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var late []protocol.Frame
+	for _, msg := range o.pending {
+		if !msg.late && now.Sub(msg.sentAt) >= ackTimeout {
+			msg.late = true
+			late = append(late, msg.frame)
+		}
+	}
+	return late
+}
+
+// Pending returns all pending messages in order they were sent, they are sent
+// again after reconnect
+func (o *Outbox) Pending() []protocol.Frame {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	pending := make([]*pendingMessage, 0, len(o.pending))
+	for _, msg := range o.pending {
+		pending = append(pending, msg)
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].sentAt.Before(pending[j].sentAt)
+	})
+	frames := make([]protocol.Frame, 0, len(pending))
+	for _, msg := range pending {
+		frames = append(frames, msg.frame)
+	}
+	return frames
+}
+
+// String describes pending messages, like "2 sending"
+func (o *Outbox) String() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if len(o.pending) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d sending", len(o.pending))
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"console-chat/internal/protocol"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+)
+
+// dialTimeout is how long client waits for the server to accept connection
+const dialTimeout = 10 * time.Second
+
+// Pauses between attempts to reconnect after the connection was lost
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 30 * time.Second
+)
+
+var errDisconnected = errors.New("not connected to the chat")
+
+// Connect dials the chat server and authorizes with the token of the
+// session, returns connection and nickname of the user. Client which
+// reconnects after losing the connection sends the last sequence numbers of
+// rooms it has seen in resume, it is nil for the first connection
+func Connect(session *Session, resume map[string]int64) (*ChatConn, string, error) {
+	dialer := ws.Dialer{Timeout: dialTimeout}
+	rawConn, _, _, err := dialer.Dial(context.Background(), wsUrl)
+	if err != nil {
+		return nil, "", err
+	}
+	conn := NewChatConn(rawConn)
+	nickname, err := Authorize(conn, session.Token(), resume)
+	if err != nil {
+		_ = conn.Close()
+		return nil, "", err
+	}
+	return conn, nickname, nil
+}
+
+// Reconnect opens connection again after it was lost with error lost, it
+// retries with pauses growing by backoff until the server accepts the
+// connection or rejects the user
+func Reconnect(session *Session, resume map[string]int64, backoff *Backoff, lost error) (*ChatConn, error) {
+	err := lost
+	for {
+		time.Sleep(backoff.Next())
+		if tokenExpired(err) {
+			if refreshErr := session.Refresh(); refreshErr == errSessionExpired {
+				return nil, refreshErr
+			} else if refreshErr != nil {
+				continue
+			}
+		}
+
+		var conn *ChatConn
+		conn, _, err = Connect(session, resume)
+		if err == nil {
+			return conn, nil
+		} else if !Reconnectable(err) {
+			return nil, err
+		}
+	}
+}
+
+// Reconnectable checks if the chat may be joined again after connection was
+// lost or rejected with err: it may after network failures and restarts of
+// the server but not after the token was revoked
+func Reconnectable(err error) bool {
+	var frameErr *protocol.Error
+	if closed, ok := err.(wsutil.ClosedError); ok {
+		switch closed.Code {
+		case protocol.CloseBadToken, protocol.CloseTokenRevoked, protocol.CloseUnsupportedVersion:
+			return false
+		}
+	} else if errors.As(err, &frameErr) {
+		switch frameErr.Code {
+		case protocol.ErrCodeUnauthorized, protocol.ErrCodeUnsupportedVersion, protocol.ErrCodeProtocol:
+			return false
+		}
+	}
+	return err != errSessionExpired
+}
+
+// tokenExpired checks if the server has rejected the connection because
+// access token has expired
+func tokenExpired(err error) bool {
+	var frameErr *protocol.Error
+	if closed, ok := err.(wsutil.ClosedError); ok {
+		return closed.Code == protocol.CloseTokenExpired
+	} else if errors.As(err, &frameErr) {
+		return frameErr.Code == protocol.ErrCodeTokenExpired
+	}
+	return false
+}
+
+// DescribeError returns human-readable reason of the lost connection
+func DescribeError(err error) string {
+	if reason, ok := DescribeClose(err); ok {
+		return reason
+	} else if err == io.EOF {
+		return "server stopped"
+	}
+	return err.Error()
+}
+
+// Backoff computes pauses between attempts to reconnect: the pause doubles
+// with every attempt up to maxReconnectDelay and is randomized, so that
+// clients which lost connection at once don't reconnect at once
+type Backoff struct {
+	delay time.Duration
+}
+
+// Next returns the pause before the next attempt
+func (b *Backoff) Next() time.Duration {
+	if b.delay == 0 {
+		b.delay = minReconnectDelay
+	} else if b.delay *= 2; b.delay > maxReconnectDelay {
+		b.delay = maxReconnectDelay
+	}
+	return b.delay/2 + time.Duration(rand.Int63n(int64(b.delay/2)+1))
+}
+
+// Reset starts pauses from the beginning after successful attempt
+func (b *Backoff) Reset() {
+	b.delay = 0
+}
+
+// Cursor remembers the last sequence number of every room the client has
+// seen, they are sent to the server on reconnect to get missed messages
+type Cursor struct {
+	mu   sync.Mutex
+	last map[string]int64 // room -> the last seen sequence number
+}
+
+func NewCursor() *Cursor {
+	return &Cursor{
+		last: make(map[string]int64),
+	}
+}
+
+// Resume returns the last seen sequence numbers of rooms to send them on
+// reconnect
+func (c *Cursor) Resume() map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	resume := make(map[string]int64, len(c.last))
+	for room, seq := range c.last {
+		resume[room] = seq
+	}
+	return resume
+}
+
+// Seen records sequence number of the room message or of the ack of sent one
+func (c *Cursor) Seen(f protocol.Frame) {
+	if (f.Type != protocol.TypeMessage && f.Type != protocol.TypeAck) || f.Room == "" || f.Seq == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if f.Seq > c.last[f.Room] {
+		c.last[f.Room] = f.Seq
+	}
+}
+
+// Link is the current connection to the chat, it is replaced when the client
+// reconnects. Frames can't be written while the client is disconnected
+type Link struct {
+	mu   sync.Mutex
+	conn *ChatConn
+}
+
+func NewLink(conn *ChatConn) *Link {
+	return &Link{
+		conn: conn,
+	}
+}
+
+// Set replaces connection, nil means that the client is disconnected
+func (l *Link) Set(conn *ChatConn) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.conn = conn
+}
+
+// Connected checks if the client has connection to the chat
+func (l *Link) Connected() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.conn != nil
+}
+
+// WriteFrame sends frame to the server, errDisconnected is returned while
+// the client is disconnected
+func (l *Link) WriteFrame(f protocol.Frame) error {
+	l.mu.Lock()
+	conn := l.conn
+	l.mu.Unlock()
+	if conn == nil {
+		return errDisconnected
+	}
+	return conn.WriteFrame(f)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const refreshUrl = "http://localhost:8080/console-chat/tokens/refresh"
+const logoutUrl = "http://localhost:8080/console-chat/logout"
+
+// minRefreshDelay limits how often tokens are refreshed if access token is
+// very short-lived
+const minRefreshDelay = 10 * time.Second
+
+var errSessionExpired = errors.New("session has expired, please sign in again")
+
+type tokenData struct {
+	TokenString           string    `json:"token_string"`
+	ExpiresAt             time.Time `json:"expires_at"`
+	RefreshToken          string    `json:"refresh_token"`
+	RefreshTokenExpiresAt time.Time `json:"refresh_token_expires_at"`
+}
+
+type tokenResponse struct {
+	Data  tokenData `json:"data"`
+	Error string    `json:"error"`
+}
+
+// Session keeps tokens of the signed in user. Access token is short-lived,
+// so session exchanges refresh token for new tokens before access token
+// expires and saves refresh token to disk to sign in without password next
+// time
+type Session struct {
+	mu     sync.Mutex
+	tokens tokenData
+
+	// refreshing is held during the whole exchange of refresh token, so it
+	// is never sent twice, which the server would take for a theft
+	refreshing sync.Mutex
+}
+
+func NewSession(tokens tokenData) *Session {
+	return &Session{
+		tokens: tokens,
+	}
+}
+
+// sessionFile is where refresh token is saved between runs of the client
+func sessionFile() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "console-chat", "session.json"), nil
+}
+
+// LoadSession reads session saved by the previous run of the client
+func LoadSession() (*Session, error) {
+	path, err := sessionFile()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tokens tokenData
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, err
+	}
+	if !time.Now().Before(tokens.RefreshTokenExpiresAt) {
+		return nil, errSessionExpired
+	}
+	return NewSession(tokens), nil
+}
+
+// Save writes session to the file readable only by the user
+func (s *Session) Save() error {
+	path, err := sessionFile()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	data, _ := json.Marshal(s.tokens)
+	s.mu.Unlock()
+	return os.WriteFile(path, data, 0600)
+}
+
+// Token returns current access token
+func (s *Session) Token() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tokens.TokenString
+}
+
+// Refresh exchanges refresh token for new access and refresh tokens and
+// saves them. Caller which waited for another refresh to finish gets its
+// tokens instead of exchanging them again
+func (s *Session) Refresh() error {
+	s.mu.Lock()
+	seen := s.tokens.RefreshToken
+	s.mu.Unlock()
+
+	s.refreshing.Lock()
+	defer s.refreshing.Unlock()
+
+	s.mu.Lock()
+	if s.tokens.RefreshToken != seen {
+		s.mu.Unlock()
+		return nil
+	}
+	reqBody, _ := json.Marshal(map[string]string{
+		"refresh_token": s.tokens.RefreshToken,
+	})
+	s.mu.Unlock()
+
+	res, err := http.Post(refreshUrl, "application/json", bytes.NewReader(reqBody))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+	var refreshResp tokenResponse
+	if err := json.NewDecoder(res.Body).Decode(&refreshResp); err != nil {
+		return err
+	}
+	if res.StatusCode == http.StatusUnauthorized {
+		return errSessionExpired
+	} else if refreshResp.Error != "" {
+		return errors.New(refreshResp.Error)
+	}
+
+	s.mu.Lock()
+	s.tokens = refreshResp.Data
+	s.mu.Unlock()
+	return s.Save()
+}
+
+// Logout revokes tokens of the session on the server, which also closes chat
+// sessions opened with them, and deletes saved session
+func (s *Session) Logout() error {
+	req, err := http.NewRequest(http.MethodPost, logoutUrl, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Authorization", "Bearer "+s.Token())
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+	var logoutResp tokenResponse
+	if err := json.NewDecoder(res.Body).Decode(&logoutResp); err != nil {
+		return err
+	}
+	if logoutResp.Error != "" {
+		return errors.New(logoutResp.Error)
+	}
+	return DeleteSession()
+}
+
+// DeleteSession deletes session saved by the previous run of the client
+func DeleteSession() error {
+	path, err := sessionFile()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// KeepFresh refreshes tokens in the background when 80% of access token
+// lifetime has passed
+func (s *Session) KeepFresh() {
+	for {
+		s.mu.Lock()
+		delay := time.Until(s.tokens.ExpiresAt) * 4 / 5
+		s.mu.Unlock()
+		if delay < minRefreshDelay {
+			delay = minRefreshDelay
+		}
+		time.Sleep(delay)
+
+		if err := s.Refresh(); err == errSessionExpired {
+			fmt.Println(err.Error())
+			return
+		} else if err != nil {
+			fmt.Println("can't refresh session, retrying:", err.Error())
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"console-chat/internal/protocol"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// typingTarget is a room or a direct chat with the user the message is typed
+// to, empty target is the current room
+type typingTarget struct {
+	room string
+	to   string
+}
+
+// TypingStatus keeps which other users are typing now, indicators expire
+// after protocol.TypingTimeout unless typing start is repeated
+type TypingStatus struct {
+	mu     sync.Mutex
+	self   string
+	typing map[string]map[typingTarget]time.Time // nickname -> target -> expiration
+}
+
+func NewTypingStatus(self string) *TypingStatus {
+	return &TypingStatus{
+		self:   self,
+		typing: make(map[string]map[typingTarget]time.Time),
+	}
+}
+
+// Update applies typing frame or hides typing of the message sender, returns
+// true if status has changed
+func (ts *TypingStatus) Update(f protocol.Frame, now time.Time) bool {
+	if f.Sender == ts.self || (f.Type != protocol.TypeTyping && f.Type != protocol.TypeMessage) {
+		return false
+	}
+	target := typingTarget{room: f.Room}
+	if f.To != "" {
+		target = typingTarget{to: f.To}
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	targets := ts.typing[f.Sender]
+	_, wasTyping := targets[target]
+	if f.Type == protocol.TypeTyping && f.Event == protocol.TypingStart {
+		if targets == nil {
+			targets = make(map[typingTarget]time.Time)
+			ts.typing[f.Sender] = targets
+		}
+		targets[target] = now.Add(protocol.TypingTimeout)
+		return !wasTyping
+	}
+	delete(targets, target)
+	if len(targets) == 0 {
+		delete(ts.typing, f.Sender)
+	}
+	return wasTyping
+}
+
+// Expire hides indicators which weren't repeated in time, returns true if
+// status has changed
+func (ts *TypingStatus) Expire(now time.Time) bool {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	changed := false
+	for nickname, targets := range ts.typing {
+		for target, expiresAt := range targets {
+			if !now.Before(expiresAt) {
+				delete(targets, target)
+				changed = true
+			}
+		}
+		if len(targets) == 0 {
+			delete(ts.typing, nickname)
+		}
+	}
+	return changed
+}
+
+// String describes who is typing, like "user01, user02 typing in general;
+// user03 typing to you"
+func (ts *TypingStatus) String() string {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	users := make(map[string][]string) // place -> nicknames
+	for nickname, targets := range ts.typing {
+		for target := range targets {
+			place := "in " + target.room
+			if target.to != "" {
+				place = "to you"
+			}
+			users[place] = append(users[place], nickname)
+		}
+	}
+
+	places := make([]string, 0, len(users))
+	for place := range users {
+		places = append(places, place)
+	}
+	sort.Strings(places)
+	parts := make([]string, 0, len(places))
+	for _, place := range places {
+		sort.Strings(users[place])
+		parts = append(parts, strings.Join(users[place], ", ")+" typing "+place)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// TypingNotifier tells the server when the user starts and stops typing.
+// Start is repeated while the user keeps typing and stop is sent when the
+// line is sent or the user pauses
+type TypingNotifier struct {
+	mu        sync.Mutex
+	send      func(f protocol.Frame) error
+	typing    bool
+	target    typingTarget
+	lastStart time.Time
+	idle      *time.Timer
+}
+
+func NewTypingNotifier(send func(f protocol.Frame) error) *TypingNotifier {
+	return &TypingNotifier{
+		send: send,
+	}
+}
+
+// lineTarget returns where the typed line goes, ok is false for commands
+func lineTarget(line string) (typingTarget, bool) {
+	if to, found := strings.CutPrefix(line, "/dm "); found {
+		fields := strings.SplitN(to, " ", 2)
+		return typingTarget{to: fields[0]}, len(fields) == 2 && fields[0] != ""
+	}
+	return typingTarget{}, line != "" && !strings.HasPrefix(line, "/")
+}
+
+// Keypress is called with the input line after every typed character
+func (tn *TypingNotifier) Keypress(line string) {
+	tn.mu.Lock()
+	defer tn.mu.Unlock()
+
+	target, ok := lineTarget(line)
+	if !ok {
+		tn.stop()
+		return
+	}
+	if tn.typing && target != tn.target {
+		tn.stop()
+	}
+
+	// pause in typing stops it, so the timer is restarted on every key
+	if tn.idle != nil {
+		tn.idle.Stop()
+	}
+	tn.idle = time.AfterFunc(protocol.TypingTimeout/2, tn.Stop)
+
+	now := time.Now()
+	if tn.typing && now.Sub(tn.lastStart) < protocol.TypingTimeout/2 {
+		return
+	}
+	tn.typing, tn.target, tn.lastStart = true, target, now
+	_ = tn.send(protocol.Frame{
+		Type:  protocol.TypeTyping,
+		Event: protocol.TypingStart,
+		To:    target.to,
+	})
+}
+
+// Stop tells the server that the user isn't typing anymore
+func (tn *TypingNotifier) Stop() {
+	tn.mu.Lock()
+	defer tn.mu.Unlock()
+
+	tn.stop()
+}
+
+// Sent is called when the line is sent. Server stops typing of the message
+// sender by itself, so stop is sent only if the line isn't a message
+func (tn *TypingNotifier) Sent(line string) {
+	tn.mu.Lock()
+	defer tn.mu.Unlock()
+
+	if target, ok := lineTarget(line); ok && target == tn.target {
+		if tn.idle != nil {
+			tn.idle.Stop()
+		}
+		tn.typing = false
+		return
+	}
+	tn.stop()
+}
+
+// stop sends typing stop if the user is typing, should be called under tn.mu
+func (tn *TypingNotifier) stop() {
+	if tn.idle != nil {
+		tn.idle.Stop()
+	}
+	if !tn.typing {
+		return
+	}
+	tn.typing = false
+	_ = tn.send(protocol.Frame{
+		Type:  protocol.TypeTyping,
+		Event: protocol.TypingStop,
+		To:    tn.target.to,
+	})
+}
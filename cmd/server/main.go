@@ -1,9 +1,16 @@
 package main
 
 import (
+	"bytes"
 	"console-chat/internal/app"
+	"console-chat/internal/app/hasher"
+	"console-chat/internal/app/moderation"
+	"console-chat/internal/ports/broker"
 	"console-chat/internal/ports/ginserver"
+	"console-chat/internal/ports/token"
 	"console-chat/internal/ports/wsserver"
+	messagerepo "console-chat/internal/repo/message_repo"
+	tokenrepo "console-chat/internal/repo/token_repo"
 	userrepo "console-chat/internal/repo/user_repo"
 	"context"
 	"fmt"
@@ -11,27 +18,33 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
-	"github.com/Pallinder/go-randomdata"
 	"github.com/go-redis/redis/v8"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/spf13/viper"
 )
 
 func InitConfig() error {
 	viper.SetConfigFile("configs/config.yml")
+	// every option may be overridden by environment variable, e.g.
+	// CONSOLE_CHAT_SERVER_TOKEN_ACTIVE_KEY for server.token.active_key
+	viper.SetEnvPrefix("console_chat")
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	viper.AutomaticEnv()
 	return viper.ReadInConfig()
 }
 
-// UserRepoConfig initializes connection to users database
-func UserRepoConfig(ctx context.Context, dbURL string) *pgx.Conn {
+// RepoConfig initializes connection to the database of the repo with given name
+func RepoConfig(ctx context.Context, repoName, dbURL string) *pgx.Conn {
 	// connecting to a database in the loop with delay 1 sec for correct starting in docker container
 	for {
 		conn, err := pgx.Connect(ctx, dbURL)
 		if err != nil { // database haven't initialized in docker container yet
-			log.Printf("user_repo connection error: %s\n", err.Error())
+			log.Printf("%s connection error: %s\n", repoName, err.Error())
 			time.Sleep(time.Second)
 		} else { // database already initialized
 			return conn
@@ -39,6 +52,144 @@ func UserRepoConfig(ctx context.Context, dbURL string) *pgx.Conn {
 	}
 }
 
+// PoolConfig initializes pool of connections to the database of the repo with
+// given name
+func PoolConfig(ctx context.Context, repoName, dbURL string) *pgxpool.Pool {
+	// connecting to a database in the loop with delay 1 sec for correct starting in docker container
+	for {
+		pool, err := pgxpool.New(ctx, dbURL)
+		if err == nil {
+			// pool connects lazily, so database is checked with ping
+			if err = pool.Ping(ctx); err != nil {
+				pool.Close()
+			}
+		}
+		if err != nil { // database haven't initialized in docker container yet
+			log.Printf("%s connection error: %s\n", repoName, err.Error())
+			time.Sleep(time.Second)
+		} else { // database already initialized
+			return pool
+		}
+	}
+}
+
+// PasswordHasherConfig creates password hasher with algorithm and parameters from config
+func PasswordHasherConfig() (*hasher.Hasher, error) {
+	argon2idParams := hasher.DefaultArgon2idParams
+	if memory := viper.GetUint32("app.hasher.argon2id.memory"); memory != 0 {
+		argon2idParams.Memory = memory
+	}
+	if iterations := viper.GetUint32("app.hasher.argon2id.iterations"); iterations != 0 {
+		argon2idParams.Iterations = iterations
+	}
+	if parallelism := viper.GetUint("app.hasher.argon2id.parallelism"); parallelism != 0 {
+		argon2idParams.Parallelism = uint8(parallelism)
+	}
+	return hasher.NewByName(
+		viper.GetString("app.hasher.algorithm"),
+		viper.GetInt("app.hasher.bcrypt_cost"),
+		argon2idParams)
+}
+
+// ModerationConfig creates moderation pipeline which applies action from
+// config to words from the word list file or built-in word list
+func ModerationConfig() (moderation.Pipeline, error) {
+	action, err := moderation.ParseAction(viper.GetString("app.moderation.action"))
+	if err != nil {
+		return nil, err
+	}
+	filter, err := moderation.LoadWordFilter(viper.GetString("app.moderation.word_list"))
+	if err != nil {
+		return nil, err
+	}
+	return moderation.Pipeline{{Filter: filter, Action: action}}, nil
+}
+
+// ChatBrokerConfig creates broker connecting instances of the chat server,
+// "memory" keeps the chat inside one instance and "redis" shares it through
+// the redis
+func ChatBrokerConfig(redisClient *redis.Client) (broker.Broker, error) {
+	switch name := viper.GetString("server.wsserver.broker"); name {
+	case "memory", "":
+		return broker.NewMemory(), nil
+	case "redis":
+		return broker.NewRedis(redisClient), nil
+	default:
+		return nil, fmt.Errorf("unknown chat broker %q", name)
+	}
+}
+
+// minTokenSecretLength is the minimal length of HS256 secret, RFC 7518
+// requires the key to be at least as long as the hash
+const minTokenSecretLength = 32
+
+// tokenKeyConfig is a token signing key in config, its secret (or PEM-encoded
+// key for RS256 and EdDSA) is taken from the environment variable, the file
+// or the config itself in this order
+type tokenKeyConfig struct {
+	ID        string `mapstructure:"id"`
+	Algorithm string `mapstructure:"algorithm"`
+	Public    bool   `mapstructure:"public"`
+	Env       string `mapstructure:"env"`
+	File      string `mapstructure:"file"`
+	Secret    string `mapstructure:"secret"`
+}
+
+// tokenKey loads the key described by config
+func (keyConfig tokenKeyConfig) tokenKey() (token.Key, error) {
+	secret := []byte(keyConfig.Secret)
+	if value := os.Getenv(keyConfig.Env); keyConfig.Env != "" && value != "" {
+		secret = []byte(value)
+	} else if keyConfig.File != "" {
+		data, err := os.ReadFile(keyConfig.File)
+		if err != nil {
+			return token.Key{}, err
+		}
+		secret = bytes.TrimSpace(data)
+	}
+
+	switch {
+	case keyConfig.Algorithm == token.HS256 || keyConfig.Algorithm == "":
+		if len(secret) < minTokenSecretLength {
+			return token.Key{}, fmt.Errorf("secret should be at least %d bytes long", minTokenSecretLength)
+		}
+		return token.NewHMACKey(keyConfig.ID, secret), nil
+	case keyConfig.Public:
+		return token.ParsePublicKey(keyConfig.ID, keyConfig.Algorithm, secret)
+	default:
+		return token.ParsePrivateKey(keyConfig.ID, keyConfig.Algorithm, secret)
+	}
+}
+
+// TokenKeyringConfig loads token signing keys from config
+func TokenKeyringConfig() (*token.Keyring, error) {
+	var keysConfig []tokenKeyConfig
+	if err := viper.UnmarshalKey("server.token.keys", &keysConfig); err != nil {
+		return nil, err
+	}
+
+	keys := make([]token.Key, 0, len(keysConfig))
+	for _, keyConfig := range keysConfig {
+		key, err := keyConfig.tokenKey()
+		if err != nil {
+			return nil, fmt.Errorf("can't load token key %q: %w", keyConfig.ID, err)
+		}
+		keys = append(keys, key)
+	}
+	return token.NewKeyring(viper.GetString("server.token.active_key"), keys...)
+}
+
+// TokenVerifierConfig creates keyring for websocket server which verifies
+// tokens with public keys downloaded from jwks_url and refetched every
+// jwks_refresh_interval or with the public keys of the local keyring
+func TokenVerifierConfig(ctx context.Context, keys *token.Keyring) (*token.Keyring, error) {
+	jwksURL := viper.GetString("server.wsserver.jwks_url")
+	if jwksURL == "" {
+		return keys.Verifier(), nil
+	}
+	return token.NewJWKSVerifier(ctx, jwksURL, viper.GetDuration("server.wsserver.jwks_refresh_interval"))
+}
+
 func main() {
 	if err := InitConfig(); err != nil {
 		log.Fatal("config init error:", err.Error())
@@ -54,13 +205,25 @@ func main() {
 		viper.GetString("userrepo.postgres.sslmode"))
 
 	ctx := context.Background()
-	userRepoConn := UserRepoConfig(ctx, userRepoURL)
+	userRepoConn := RepoConfig(ctx, "user_repo", userRepoURL)
 	defer func() {
 		if err := userRepoConn.Close(ctx); err != nil {
 			log.Fatal("can't close database connection:", err.Error())
 		}
 	}()
 
+	// configuring messageRepo, messages are stored in the same database as users
+	messageRepoPool := PoolConfig(ctx, "message_repo", userRepoURL)
+	defer messageRepoPool.Close()
+
+	// configuring tokenRepo in the same database
+	tokenRepoConn := RepoConfig(ctx, "token_repo", userRepoURL)
+	defer func() {
+		if err := tokenRepoConn.Close(ctx); err != nil {
+			log.Fatal("can't close database connection:", err.Error())
+		}
+	}()
+
 	// configuring userRepo cache
 	redisHost := viper.GetString("userrepo.redis.host")
 	redisPort := viper.GetString("userrepo.redis.port")
@@ -81,11 +244,74 @@ func main() {
 	// configuring the server
 	host := viper.GetString("server.ginserver.host")
 	port := viper.GetInt("server.ginserver.port")
-	tokenKey := []byte(randomdata.Paragraph())
+	tokenKeys, err := TokenKeyringConfig()
+	if err != nil {
+		log.Fatal("token keys config error:", err.Error())
+	}
+	tokenVerifier, err := TokenVerifierConfig(ctx, tokenKeys)
+	if err != nil {
+		log.Fatal("token verifier config error:", err.Error())
+	}
 
-	ws := wsserver.New(tokenKey)
-	app := app.New(userrepo.New(userRepoConn, redisCache))
-	server := ginserver.NewHTTPServer(host, port, ws, app, tokenKey)
+	passwordHasher, err := PasswordHasherConfig()
+	if err != nil {
+		log.Fatal("password hasher config error:", err.Error())
+	}
+
+	moderationPipeline, err := ModerationConfig()
+	if err != nil {
+		log.Fatal("moderation config error:", err.Error())
+	}
+
+	app := app.New(
+		userrepo.New(userRepoConn, redisCache),
+		messagerepo.New(messageRepoPool),
+		tokenrepo.New(tokenRepoConn, redisCache),
+		passwordHasher,
+		app.Config{
+			RefreshTTL: viper.GetDuration("server.token.refresh_ttl"),
+			SignIn: app.SignInLimits{
+				FreeAttempts:   viper.GetInt("app.sign_in.free_attempts"),
+				IPFreeAttempts: viper.GetInt("app.sign_in.ip_free_attempts"),
+				BaseLockout:    viper.GetDuration("app.sign_in.base_lockout"),
+				MaxLockout:     viper.GetDuration("app.sign_in.max_lockout"),
+				AttemptsWindow: viper.GetDuration("app.sign_in.attempts_window"),
+			},
+			Moderation: moderationPipeline,
+		})
+	chatBroker, err := ChatBrokerConfig(redisCache)
+	if err != nil {
+		log.Fatal("chat broker config error:", err.Error())
+	}
+	ws := wsserver.New(tokenVerifier, app, wsserver.Config{
+		HistorySize:    viper.GetInt("server.wsserver.history_size"),
+		ReplayLimit:    viper.GetInt("server.wsserver.replay_limit"),
+		SendQueueSize:  viper.GetInt("server.wsserver.send_queue_size"),
+		WriteTimeout:   viper.GetDuration("server.wsserver.write_timeout"),
+		OverflowPolicy: wsserver.OverflowPolicy(viper.GetString("server.wsserver.overflow_policy")),
+		PingInterval:   viper.GetDuration("server.wsserver.ping_interval"),
+		PongWait:       viper.GetDuration("server.wsserver.pong_wait"),
+		AuthTimeout:    viper.GetDuration("server.wsserver.auth_timeout"),
+
+		MaxFrameSize:     viper.GetInt64("server.wsserver.max_frame_size"),
+		MaxMessageLength: viper.GetInt("server.wsserver.max_message_length"),
+		TypingInterval:   viper.GetDuration("server.wsserver.typing_interval"),
+
+		Broker: chatBroker,
+
+		MessageRate:      viper.GetFloat64("server.wsserver.flood.message_rate"),
+		MessageBurst:     viper.GetInt("server.wsserver.flood.message_burst"),
+		RoomMessageRate:  viper.GetFloat64("server.wsserver.flood.room_message_rate"),
+		RoomMessageBurst: viper.GetInt("server.wsserver.flood.room_message_burst"),
+		MuteAfter:        viper.GetInt("server.wsserver.flood.mute_after"),
+		MuteDuration:     viper.GetDuration("server.wsserver.flood.mute_duration"),
+	})
+	server := ginserver.NewHTTPServer(host, port, ws, app, tokenKeys, ginserver.Config{
+		AccessTTL:      viper.GetDuration("server.token.access_ttl"),
+		LegacySignIn:   viper.GetBool("server.ginserver.legacy_sign_in"),
+		AdminToken:     viper.GetString("server.ginserver.admin_token"),
+		TrustedProxies: viper.GetStringSlice("server.ginserver.trusted_proxies"),
+	})
 
 	// preparing graceful shutdown
 	osSignals := make(chan os.Signal, 1)
@@ -108,5 +334,10 @@ func main() {
 	if err := server.Shutdown(ctx); err != nil {
 		log.Fatal("Server graceful shutdown failed:", err.Error())
 	}
+	// websocket connections are hijacked from http server so chat sessions
+	// are closed separately
+	if err := ws.Shutdown(ctx); err != nil {
+		log.Println("can't close all chat sessions:", err.Error())
+	}
 	log.Println("Server was gracefully stopped")
 }
@@ -1,15 +1,24 @@
 package app
 
 import (
+	"console-chat/internal/app/moderation"
 	"console-chat/internal/app/valid"
 	"console-chat/internal/model"
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
+	"log"
+	"strings"
+	"time"
 )
 
 type app struct {
 	UserRepo
+	messageRepo MessageRepo
+	tokenRepo   TokenRepo
+	hasher      PasswordHasher
+	refreshTTL  time.Duration
+
+	signInLimits SignInLimits
+	moderation   moderation.Pipeline
 }
 
 func (a *app) RegisterUser(ctx context.Context, nickname, password string) (model.User, error) {
@@ -18,38 +27,141 @@ func (a *app) RegisterUser(ctx context.Context, nickname, password string) (mode
 	if !valid.IsValidNickname(nickname) {
 		return model.User{}, model.UserInvalidNickname
 	}
+	if a.moderation.Moderate(nickname).Found() {
+		return model.User{}, model.UserInvalidNickname
+	}
 	if !valid.IsValidPassword(password) {
 		return model.User{}, model.UserInvalidPassword
 	}
 
 	var usr model.User
 	usr.Nickname = nickname
+	usr.CreatedAt = time.Now().UTC()
 
-	// creating hash sum of the password
-	hash := sha256.New()
-	hash.Write([]byte(password))
-	hashSum := hash.Sum(nil)
-	usr.HashedPassword = hex.EncodeToString(hashSum)
+	// creating salted hash of the password
+	hashedPassword, err := a.hasher.Hash(password)
+	if err != nil {
+		return model.User{}, model.PasswordHashError
+	}
+	usr.HashedPassword = hashedPassword
 
 	return a.AddUser(ctx, usr)
 }
 
-func (a *app) SignInUser(ctx context.Context, nickname, password string) (model.User, error) {
+func (a *app) SignInUser(ctx context.Context, nickname, password, ip string) (model.User, error) {
 	var usr model.User
 	var err error
 
+	// password isn't even checked while sign in is locked
+	if lock, err := a.GetSignInLock(ctx, signInKeys(nickname, ip)...); err != nil {
+		return model.User{}, err
+	} else if lock > 0 {
+		return model.User{}, &model.SignInLockedError{RetryAfter: lock}
+	}
+
 	// getting user with given nickname from repo
-	if usr, err = a.GetUser(ctx, nickname); err != nil {
+	if usr, err = a.GetUser(ctx, nickname); err == model.UserNotFound {
+		a.failSignIn(ctx, nickname, ip)
+		return model.User{}, err
+	} else if err != nil {
 		return model.User{}, err
 	}
 
 	// checking password
-	hash := sha256.New()
-	hash.Write([]byte(password))
-	hashSum := hash.Sum(nil)
-	if usr.HashedPassword != hex.EncodeToString(hashSum) {
+	if ok, err := a.hasher.Verify(password, usr.HashedPassword); err != nil {
+		return model.User{}, model.PasswordHashError
+	} else if !ok {
+		a.failSignIn(ctx, nickname, ip)
 		return model.User{}, model.UserWrongPassword
-	} else {
-		return usr, nil
 	}
+
+	// counter of the IP isn't reset, otherwise one known password would
+	// allow guessing others from the same IP
+	if err := a.ResetFailedSignIns(ctx, nicknameKey(nickname)); err != nil {
+		log.Println("can't reset failed sign in attempts of", nickname, err.Error())
+	}
+
+	// upgrading hash made by outdated algorithm while plain password is known
+	if a.hasher.NeedsRehash(usr.HashedPassword) {
+		if hashedPassword, err := a.hasher.Hash(password); err != nil {
+			log.Println("can't rehash password of", nickname, err.Error())
+		} else {
+			upgraded := usr
+			upgraded.HashedPassword = hashedPassword
+			if upgraded, err = a.UpdateUser(ctx, upgraded); err != nil {
+				log.Println("can't update password hash of", nickname, err.Error())
+			} else {
+				usr = upgraded
+			}
+		}
+	}
+
+	return usr, nil
+}
+
+func (a *app) ModerateMessage(_ context.Context, sender, text string) (string, error) {
+	res := a.moderation.Moderate(text)
+	if res.Rejected {
+		return "", model.MessageRejected
+	}
+	if len(res.Flagged) != 0 {
+		log.Println("message of", sender, "is flagged for", strings.Join(res.Flagged, ", "))
+	}
+	return res.Text, nil
+}
+
+func (a *app) SaveMessage(ctx context.Context, msg model.Message) (model.Message, error) {
+	msg.ID, msg.Seq = 0, 0
+	msg.CreatedAt = time.Now().UTC()
+	return a.messageRepo.AddMessage(ctx, msg)
+}
+
+func (a *app) GetHistory(ctx context.Context, room string, beforeID int64, limit int) ([]model.Message, error) {
+	if limit <= 0 {
+		return []model.Message{}, nil
+	}
+	msgs, err := a.messageRepo.GetMessages(ctx, room, beforeID, limit)
+	if err != nil {
+		return nil, err
+	}
+	reverseMessages(msgs)
+	return msgs, nil
+}
+
+func (a *app) GetMissed(ctx context.Context, room string, afterSeq int64, limit int) ([]model.Message, error) {
+	if limit <= 0 {
+		return []model.Message{}, nil
+	}
+	msgs, err := a.messageRepo.GetMessagesAfter(ctx, room, afterSeq, limit)
+	if err != nil {
+		return nil, err
+	}
+	reverseMessages(msgs)
+	return msgs, nil
+}
+
+// reverseMessages puts messages returned by repo newest first into
+// chronological order which client expects
+func reverseMessages(msgs []model.Message) {
+	for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+		msgs[i], msgs[j] = msgs[j], msgs[i]
+	}
+}
+
+func (a *app) QueueMessage(ctx context.Context, recipient string, msg model.Message) (model.Delivery, error) {
+	return a.messageRepo.AddDelivery(ctx, model.Delivery{
+		Recipient: recipient,
+		Message:   msg,
+	})
+}
+
+func (a *app) GetUndelivered(ctx context.Context, recipient string) ([]model.Delivery, error) {
+	return a.messageRepo.GetDeliveries(ctx, recipient)
+}
+
+func (a *app) MarkDelivered(ctx context.Context, recipient string, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return a.messageRepo.SetDelivered(ctx, recipient, ids)
 }
@@ -1,16 +1,72 @@
 package app
 
 import (
+	"console-chat/internal/app/moderation"
 	"console-chat/internal/model"
 	"context"
+	"time"
 )
 
 type App interface {
 	// Register user checks nickname and password validity and adds new user to the repo
 	RegisterUser(ctx context.Context, nickname, password string) (model.User, error)
 
-	// SignInUser finds user in user repo by nickname and checks if password is right
-	SignInUser(ctx context.Context, nickname, password string) (model.User, error)
+	// SignInUser finds user in user repo by nickname and checks if password
+	// is right. Failed attempts are counted per nickname and per client IP,
+	// too many of them lock sign in for a while with *model.SignInLockedError
+	SignInUser(ctx context.Context, nickname, password, ip string) (model.User, error)
+
+	// UnlockSignIn resets failed sign in attempts and lockout of the nickname
+	// and of the IP, empty ones are skipped
+	UnlockSignIn(ctx context.Context, nickname, ip string) error
+
+	// GetUser finds user in user repo by nickname
+	GetUser(ctx context.Context, nickname string) (model.User, error)
+
+	// ModerateMessage passes text of the message from the sender through the
+	// moderation pipeline and returns it with unwanted words masked, or
+	// model.MessageRejected
+	ModerateMessage(ctx context.Context, sender, text string) (string, error)
+
+	// SaveMessage adds message to the history of its room, assigning it ID and server timestamp
+	SaveMessage(ctx context.Context, msg model.Message) (model.Message, error)
+
+	// GetHistory returns up to limit messages of the room with ID less than
+	// beforeID (or the latest ones if beforeID is 0) in chronological order
+	GetHistory(ctx context.Context, room string, beforeID int64, limit int) ([]model.Message, error)
+
+	// GetMissed returns up to limit latest messages of the room with sequence
+	// number greater than afterSeq in chronological order
+	GetMissed(ctx context.Context, room string, afterSeq int64, limit int) ([]model.Message, error)
+
+	// QueueMessage saves message for the recipient who is offline to deliver it later
+	QueueMessage(ctx context.Context, recipient string, msg model.Message) (model.Delivery, error)
+
+	// GetUndelivered returns messages queued for the recipient in order they were queued
+	GetUndelivered(ctx context.Context, recipient string) ([]model.Delivery, error)
+
+	// MarkDelivered marks queued messages as delivered so they are never sent again
+	MarkDelivered(ctx context.Context, recipient string, ids []int64) error
+
+	// IssueRefreshToken creates refresh token of the user which starts new token family
+	IssueRefreshToken(ctx context.Context, nickname string) (model.RefreshToken, error)
+
+	// RotateRefreshToken exchanges refresh token for a new one of the same
+	// family. Reuse of already exchanged token revokes the whole family and
+	// returns RefreshTokenReused with family and nickname of the token, so
+	// access tokens and chat sessions of the family can be revoked too
+	RotateRefreshToken(ctx context.Context, token string) (model.RefreshToken, error)
+
+	// RevokeSession revokes all refresh tokens issued since the sign in which
+	// started the family
+	RevokeSession(ctx context.Context, family string) error
+
+	// RevokeToken adds ID of access token (jti) or of token family to the
+	// revocation list until expiresAt
+	RevokeToken(ctx context.Context, id string, expiresAt time.Time) error
+
+	// IsTokenRevoked checks if any of IDs is in the revocation list
+	IsTokenRevoked(ctx context.Context, ids ...string) (bool, error)
 }
 
 type UserRepo interface {
@@ -19,10 +75,105 @@ type UserRepo interface {
 
 	// GetUser finds user in the repo by nickname
 	GetUser(ctx context.Context, nickname string) (model.User, error)
+
+	// UpdateUser replaces stored data of the user with the same nickname
+	UpdateUser(ctx context.Context, u model.User) (model.User, error)
+
+	// AddFailedSignIn counts failed sign in attempt with the key and returns
+	// number of attempts within window since the first of them
+	AddFailedSignIn(ctx context.Context, key string, window time.Duration) (int64, error)
+
+	// LockSignIn locks sign in with the key for ttl
+	LockSignIn(ctx context.Context, key string, ttl time.Duration) error
+
+	// GetSignInLock returns the longest remaining lockout of the keys, zero if
+	// none of them is locked
+	GetSignInLock(ctx context.Context, keys ...string) (time.Duration, error)
+
+	// ResetFailedSignIns deletes failed attempts and lockouts of the keys
+	ResetFailedSignIns(ctx context.Context, keys ...string) error
 }
 
-func New(repo UserRepo) App {
+type MessageRepo interface {
+	// AddMessage adds new message to the repo, assigning it ID and the next
+	// sequence number of its room
+	AddMessage(ctx context.Context, msg model.Message) (model.Message, error)
+
+	// GetMessages finds up to limit latest messages of the room with ID less
+	// than beforeID, newest first
+	GetMessages(ctx context.Context, room string, beforeID int64, limit int) ([]model.Message, error)
+
+	// GetMessagesAfter finds up to limit latest messages of the room with
+	// sequence number greater than afterSeq, newest first
+	GetMessagesAfter(ctx context.Context, room string, afterSeq int64, limit int) ([]model.Message, error)
+
+	// AddDelivery adds message to the queue of the recipient
+	AddDelivery(ctx context.Context, d model.Delivery) (model.Delivery, error)
+
+	// GetDeliveries finds undelivered messages of the recipient, oldest first
+	GetDeliveries(ctx context.Context, recipient string) ([]model.Delivery, error)
+
+	// SetDelivered marks messages of the recipient as delivered
+	SetDelivered(ctx context.Context, recipient string, ids []int64) error
+}
+
+type TokenRepo interface {
+	// AddRefreshToken adds new refresh token to the repo
+	AddRefreshToken(ctx context.Context, t model.RefreshToken) (model.RefreshToken, error)
+
+	// GetRefreshToken finds refresh token in the repo by its hash
+	GetRefreshToken(ctx context.Context, hash string) (model.RefreshToken, error)
+
+	// UseRefreshToken marks refresh token as exchanged, returns
+	// model.RefreshTokenReused if it was already exchanged or revoked
+	UseRefreshToken(ctx context.Context, hash string) error
+
+	// RevokeTokenFamily revokes all refresh tokens of the family
+	RevokeTokenFamily(ctx context.Context, family string) error
+
+	// AddRevokedToken adds token ID to the revocation list for ttl
+	AddRevokedToken(ctx context.Context, id string, ttl time.Duration) error
+
+	// IsTokenRevoked checks if any of IDs is in the revocation list
+	IsTokenRevoked(ctx context.Context, ids ...string) (bool, error)
+}
+
+type PasswordHasher interface {
+	// Hash returns salted hash of the password encoded in PHC string format
+	Hash(password string) (string, error)
+
+	// Verify checks in constant time if password matches encoded hash
+	Verify(password, encoded string) (bool, error)
+
+	// NeedsRehash checks if encoded hash was made by outdated algorithm or
+	// with outdated parameters and should be replaced
+	NeedsRehash(encoded string) bool
+}
+
+// Config is configuration of the app, zero fields are replaced by defaults
+type Config struct {
+	RefreshTTL time.Duration // lifetime of refresh tokens
+	SignIn     SignInLimits
+
+	// Moderation is applied to nicknames and messages, built-in word list
+	// is masked if it is nil
+	Moderation moderation.Pipeline
+}
+
+func New(userRepo UserRepo, messageRepo MessageRepo, tokenRepo TokenRepo, hasher PasswordHasher, cfg Config) App {
+	if cfg.RefreshTTL <= 0 {
+		cfg.RefreshTTL = defaultRefreshTTL
+	}
+	if cfg.Moderation == nil {
+		cfg.Moderation = moderation.Pipeline{{Filter: moderation.DefaultWordFilter(), Action: moderation.Mask}}
+	}
 	return &app{
-		UserRepo: repo,
+		UserRepo:     userRepo,
+		messageRepo:  messageRepo,
+		tokenRepo:    tokenRepo,
+		hasher:       hasher,
+		refreshTTL:   cfg.RefreshTTL,
+		signInLimits: cfg.SignIn.withDefaults(),
+		moderation:   cfg.Moderation,
 	}
 }
@@ -0,0 +1,220 @@
+package app
+
+import (
+	"console-chat/internal/app/hasher"
+	"console-chat/internal/app/moderation"
+	"console-chat/internal/model"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// memUserRepo is an in-memory UserRepo for testing
+type memUserRepo struct {
+	users    map[string]model.User
+	failures map[string]int64
+	locks    map[string]time.Time
+}
+
+func newMemUserRepo(users ...model.User) *memUserRepo {
+	r := &memUserRepo{
+		users:    make(map[string]model.User),
+		failures: make(map[string]int64),
+		locks:    make(map[string]time.Time),
+	}
+	for _, u := range users {
+		r.users[u.Nickname] = u
+	}
+	return r
+}
+
+func (r *memUserRepo) AddUser(_ context.Context, u model.User) (model.User, error) {
+	if _, ok := r.users[u.Nickname]; ok {
+		return model.User{}, model.UserAlreadyExists
+	}
+	r.users[u.Nickname] = u
+	return u, nil
+}
+
+func (r *memUserRepo) GetUser(_ context.Context, nickname string) (model.User, error) {
+	if u, ok := r.users[nickname]; ok {
+		return u, nil
+	}
+	return model.User{}, model.UserNotFound
+}
+
+func (r *memUserRepo) UpdateUser(_ context.Context, u model.User) (model.User, error) {
+	if _, ok := r.users[u.Nickname]; !ok {
+		return model.User{}, model.UserNotFound
+	}
+	r.users[u.Nickname] = u
+	return u, nil
+}
+
+func (r *memUserRepo) AddFailedSignIn(_ context.Context, key string, _ time.Duration) (int64, error) {
+	r.failures[key]++
+	return r.failures[key], nil
+}
+
+func (r *memUserRepo) LockSignIn(_ context.Context, key string, ttl time.Duration) error {
+	r.locks[key] = time.Now().Add(ttl)
+	return nil
+}
+
+func (r *memUserRepo) GetSignInLock(_ context.Context, keys ...string) (time.Duration, error) {
+	var lock time.Duration
+	for _, key := range keys {
+		if ttl := time.Until(r.locks[key]); ttl > lock {
+			lock = ttl
+		}
+	}
+	return lock, nil
+}
+
+func (r *memUserRepo) ResetFailedSignIns(_ context.Context, keys ...string) error {
+	for _, key := range keys {
+		delete(r.failures, key)
+		delete(r.locks, key)
+	}
+	return nil
+}
+
+func newTestHasher() PasswordHasher {
+	return hasher.New(hasher.NewArgon2id(hasher.Argon2idParams{
+		Memory:      1024,
+		Iterations:  1,
+		Parallelism: 1,
+		SaltLength:  16,
+		KeyLength:   32,
+	}), hasher.LegacySHA256{})
+}
+
+func TestRegisterAndSignIn(t *testing.T) {
+	repo := newMemUserRepo()
+	a := New(repo, nil, nil, newTestHasher(), Config{})
+	ctx := context.Background()
+
+	usr, err := a.RegisterUser(ctx, "papey08", "qwerty_123")
+	assert.NoError(t, err)
+	assert.NotContains(t, usr.HashedPassword, "qwerty_123")
+
+	_, err = a.SignInUser(ctx, "papey08", "qwerty_123", "")
+	assert.NoError(t, err)
+
+	_, err = a.SignInUser(ctx, "papey08", "qwerty_124", "")
+	assert.Equal(t, model.UserWrongPassword, err)
+}
+
+func TestRegisterObsceneNickname(t *testing.T) {
+	a := New(newMemUserRepo(), nil, nil, newTestHasher(), Config{})
+	ctx := context.Background()
+
+	for _, nickname := range []string{"fuck", "fuck_you", "FuCk_you", "f_u_c_k", "fuuuck"} {
+		_, err := a.RegisterUser(ctx, nickname, "qwerty_123")
+		assert.Equal(t, model.UserInvalidNickname, err, nickname)
+	}
+
+	// flagged nickname is rejected too
+	a = New(newMemUserRepo(), nil, nil, newTestHasher(), Config{
+		Moderation: moderation.Pipeline{{Filter: moderation.NewWordFilter("papey"), Action: moderation.Flag}},
+	})
+	_, err := a.RegisterUser(ctx, "papey08", "qwerty_123")
+	assert.Equal(t, model.UserInvalidNickname, err)
+}
+
+func TestModerateMessage(t *testing.T) {
+	a := New(newMemUserRepo(), nil, nil, newTestHasher(), Config{
+		Moderation: moderation.Pipeline{
+			{Filter: moderation.NewWordFilter("fuck"), Action: moderation.Mask},
+			{Filter: moderation.NewWordFilter("kill"), Action: moderation.Reject},
+		},
+	})
+	ctx := context.Background()
+
+	text, err := a.ModerateMessage(ctx, "papey08", "Hello, world!")
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello, world!", text)
+
+	text, err = a.ModerateMessage(ctx, "papey08", "oh f.u.c.k")
+	assert.NoError(t, err)
+	assert.Equal(t, "oh *******", text)
+
+	_, err = a.ModerateMessage(ctx, "papey08", "I'll k1ll you")
+	assert.Equal(t, model.MessageRejected, err)
+}
+
+func TestSignInRehashesLegacyPassword(t *testing.T) {
+	legacyHash, _ := hasher.LegacySHA256{}.Hash("qwerty_123")
+	repo := newMemUserRepo(model.User{Nickname: "papey08", HashedPassword: legacyHash})
+	a := New(repo, nil, nil, newTestHasher(), Config{})
+	ctx := context.Background()
+
+	// wrong password doesn't touch stored hash
+	_, err := a.SignInUser(ctx, "papey08", "qwerty_124", "")
+	assert.Equal(t, model.UserWrongPassword, err)
+	assert.Equal(t, legacyHash, repo.users["papey08"].HashedPassword)
+
+	// right password upgrades stored hash
+	_, err = a.SignInUser(ctx, "papey08", "qwerty_123", "")
+	assert.NoError(t, err)
+	assert.NotEqual(t, legacyHash, repo.users["papey08"].HashedPassword)
+	assert.Contains(t, repo.users["papey08"].HashedPassword, "$argon2id$")
+
+	// upgraded hash still matches the password
+	_, err = a.SignInUser(ctx, "papey08", "qwerty_123", "")
+	assert.NoError(t, err)
+}
+
+func TestSignInLockout(t *testing.T) {
+	repo := newMemUserRepo()
+	a := New(repo, nil, nil, newTestHasher(), Config{
+		SignIn: SignInLimits{
+			FreeAttempts:   2,
+			IPFreeAttempts: 3,
+			BaseLockout:    time.Minute,
+			MaxLockout:     3 * time.Minute,
+		},
+	})
+	ctx := context.Background()
+	_, err := a.RegisterUser(ctx, "papey08", "qwerty_123")
+	assert.NoError(t, err)
+
+	// free attempts don't lock the nickname
+	for i := 0; i < 2; i++ {
+		_, err = a.SignInUser(ctx, "papey08", "qwerty_124", "10.0.0.1")
+		assert.Equal(t, model.UserWrongPassword, err)
+	}
+	_, err = a.SignInUser(ctx, "papey08", "qwerty_124", "10.0.0.2")
+	assert.Equal(t, model.UserWrongPassword, err)
+
+	// even right password is rejected while nickname is locked
+	_, err = a.SignInUser(ctx, "papey08", "qwerty_123", "10.0.0.3")
+	var locked *model.SignInLockedError
+	assert.True(t, errors.As(err, &locked))
+	assert.True(t, errors.Is(err, model.UserSignInLocked))
+	assert.InDelta(t, time.Minute, locked.RetryAfter, float64(time.Second))
+
+	// lockout doubles with every failure but not longer than max
+	assert.Equal(t, time.Minute, a.(*app).signInLimits.lockout(3, 2))
+	assert.Equal(t, 2*time.Minute, a.(*app).signInLimits.lockout(4, 2))
+	assert.Equal(t, 3*time.Minute, a.(*app).signInLimits.lockout(5, 2))
+	assert.Equal(t, 3*time.Minute, a.(*app).signInLimits.lockout(100, 2))
+
+	// unknown nicknames are counted per IP too
+	for i := 0; i < 2; i++ {
+		_, err = a.SignInUser(ctx, "nobody", "qwerty_123", "10.0.0.1")
+		assert.Equal(t, model.UserNotFound, err)
+	}
+	_, err = a.SignInUser(ctx, "somebody", "qwerty_123", "10.0.0.1")
+	assert.True(t, errors.Is(err, model.UserSignInLocked))
+
+	// admin unlocks the nickname, success resets its counter
+	assert.NoError(t, a.UnlockSignIn(ctx, "papey08", ""))
+	_, err = a.SignInUser(ctx, "papey08", "qwerty_123", "10.0.0.3")
+	assert.NoError(t, err)
+	assert.Zero(t, repo.failures["nickname:papey08"])
+	assert.Equal(t, int64(4), repo.failures["ip:10.0.0.1"])
+}
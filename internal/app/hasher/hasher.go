@@ -0,0 +1,74 @@
+package hasher
+
+import "errors"
+
+var ErrMalformedHash = errors.New("password hash has invalid format")
+var ErrIncompatibleVersion = errors.New("password hash has incompatible version")
+var ErrUnknownAlgorithm = errors.New("password hash was made by unknown algorithm")
+
+// Algorithm is a single password hashing algorithm
+type Algorithm interface {
+	// Hash returns encoded salted hash of the password
+	Hash(password string) (string, error)
+
+	// Verify checks in constant time if password matches encoded hash
+	Verify(password, encoded string) (bool, error)
+
+	// Identify checks if encoded hash was made by this algorithm
+	Identify(encoded string) bool
+
+	// NeedsRehash checks if encoded hash was made with outdated parameters
+	NeedsRehash(encoded string) bool
+}
+
+// Hasher hashes new passwords with the primary algorithm and verifies hashes
+// made by any of known algorithms
+type Hasher struct {
+	primary Algorithm
+	known   []Algorithm
+}
+
+// New creates Hasher which uses primary algorithm for new hashes and is able
+// to verify hashes made by primary or any of legacy algorithms
+func New(primary Algorithm, legacy ...Algorithm) *Hasher {
+	return &Hasher{
+		primary: primary,
+		known:   append([]Algorithm{primary}, legacy...),
+	}
+}
+
+// NewByName creates Hasher with primary algorithm chosen by name ("argon2id"
+// or "bcrypt"), all other algorithms including legacy sha256 are used only for
+// verification
+func NewByName(name string, bcryptCost int, argon2idParams Argon2idParams) (*Hasher, error) {
+	a := NewArgon2id(argon2idParams)
+	b := NewBcrypt(bcryptCost)
+	switch name {
+	case "argon2id", "":
+		return New(a, b, LegacySHA256{}), nil
+	case "bcrypt":
+		return New(b, a, LegacySHA256{}), nil
+	default:
+		return nil, ErrUnknownAlgorithm
+	}
+}
+
+func (h *Hasher) Hash(password string) (string, error) {
+	return h.primary.Hash(password)
+}
+
+func (h *Hasher) Verify(password, encoded string) (bool, error) {
+	for _, alg := range h.known {
+		if alg.Identify(encoded) {
+			return alg.Verify(password, encoded)
+		}
+	}
+	return false, ErrUnknownAlgorithm
+}
+
+func (h *Hasher) NeedsRehash(encoded string) bool {
+	if !h.primary.Identify(encoded) {
+		return true
+	}
+	return h.primary.NeedsRehash(encoded)
+}
@@ -0,0 +1,153 @@
+package hasher
+
+import (
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+)
+
+// testArgon2idParams are cheap parameters to keep tests fast
+var testArgon2idParams = Argon2idParams{
+	Memory:      1024,
+	Iterations:  1,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+type verifyTest struct {
+	description    string
+	password       string
+	encoded        string
+	expectedResult bool
+	expectedErr    error
+}
+
+func TestHasherVerify(t *testing.T) {
+	h := New(NewArgon2id(testArgon2idParams), NewBcrypt(4), LegacySHA256{})
+
+	argon2idHash, err := NewArgon2id(testArgon2idParams).Hash("qwerty_123")
+	assert.Equal(t, err, nil)
+	bcryptHash, err := NewBcrypt(4).Hash("qwerty_123")
+	assert.Equal(t, err, nil)
+
+	tests := []verifyTest{
+		{
+			description:    "right password, argon2id",
+			password:       "qwerty_123",
+			encoded:        argon2idHash,
+			expectedResult: true,
+		},
+		{
+			description:    "wrong password, argon2id",
+			password:       "qwerty_124",
+			encoded:        argon2idHash,
+			expectedResult: false,
+		},
+		{
+			description:    "right password, bcrypt",
+			password:       "qwerty_123",
+			encoded:        bcryptHash,
+			expectedResult: true,
+		},
+		{
+			description:    "wrong password, bcrypt",
+			password:       "qwerty_124",
+			encoded:        bcryptHash,
+			expectedResult: false,
+		},
+		{
+			description:    "password doesn't match legacy sha256",
+			password:       "qwerty_123",
+			encoded:        "0f3d2a1b0b5b6d4c19b3ee8a64b84e5e5a5e9a04d4a6c31b14df0e8d2a3ee1a7",
+			expectedResult: false,
+		},
+		{
+			description:    "malformed argon2id hash",
+			password:       "qwerty_123",
+			encoded:        "$argon2id$v=19$m=1024$abc",
+			expectedResult: false,
+			expectedErr:    ErrMalformedHash,
+		},
+		{
+			description:    "unknown algorithm",
+			password:       "qwerty_123",
+			encoded:        "$md5$abc",
+			expectedResult: false,
+			expectedErr:    ErrUnknownAlgorithm,
+		},
+	}
+
+	for _, test := range tests {
+		ok, err := h.Verify(test.password, test.encoded)
+		assert.Equal(t, test.expectedResult, ok)
+		assert.Equal(t, test.expectedErr, err)
+	}
+}
+
+func TestHashIsSalted(t *testing.T) {
+	h := New(NewArgon2id(testArgon2idParams))
+
+	first, err := h.Hash("qwerty_123")
+	assert.Equal(t, err, nil)
+	second, err := h.Hash("qwerty_123")
+	assert.Equal(t, err, nil)
+
+	assert.NotEqual(t, first, second)
+}
+
+func TestLegacySHA256(t *testing.T) {
+	h := New(NewArgon2id(testArgon2idParams), LegacySHA256{})
+
+	legacyHash, _ := LegacySHA256{}.Hash("qwerty_123")
+	ok, err := h.Verify("qwerty_123", legacyHash)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, h.NeedsRehash(legacyHash))
+}
+
+type needsRehashTest struct {
+	description    string
+	hasher         *Hasher
+	encoded        string
+	expectedResult bool
+}
+
+func TestNeedsRehash(t *testing.T) {
+	argon2idHash, _ := NewArgon2id(testArgon2idParams).Hash("qwerty_123")
+	bcryptHash, _ := NewBcrypt(4).Hash("qwerty_123")
+
+	strongerParams := testArgon2idParams
+	strongerParams.Iterations = 2
+
+	tests := []needsRehashTest{
+		{
+			description:    "same algorithm and parameters",
+			hasher:         New(NewArgon2id(testArgon2idParams), NewBcrypt(4)),
+			encoded:        argon2idHash,
+			expectedResult: false,
+		},
+		{
+			description:    "outdated argon2id parameters",
+			hasher:         New(NewArgon2id(strongerParams)),
+			encoded:        argon2idHash,
+			expectedResult: true,
+		},
+		{
+			description:    "outdated bcrypt cost",
+			hasher:         New(NewBcrypt(5)),
+			encoded:        bcryptHash,
+			expectedResult: true,
+		},
+		{
+			description:    "another primary algorithm",
+			hasher:         New(NewArgon2id(testArgon2idParams), NewBcrypt(4)),
+			encoded:        bcryptHash,
+			expectedResult: true,
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expectedResult, test.hasher.NeedsRehash(test.encoded))
+	}
+}
@@ -0,0 +1,35 @@
+package hasher
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+)
+
+// LegacySHA256 verifies unsalted hex-encoded sha256 hashes which were stored
+// before salted KDFs were introduced. It should never be used as a primary
+// algorithm, every hash it verifies needs rehash
+type LegacySHA256 struct{}
+
+func (LegacySHA256) Hash(password string) (string, error) {
+	hashSum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(hashSum[:]), nil
+}
+
+func (LegacySHA256) Verify(password, encoded string) (bool, error) {
+	hashSum := sha256.Sum256([]byte(password))
+	return subtle.ConstantTimeCompare([]byte(hex.EncodeToString(hashSum[:])), []byte(strings.ToLower(encoded))) == 1, nil
+}
+
+func (LegacySHA256) Identify(encoded string) bool {
+	if len(encoded) != hex.EncodedLen(sha256.Size) {
+		return false
+	}
+	_, err := hex.DecodeString(encoded)
+	return err == nil
+}
+
+func (LegacySHA256) NeedsRehash(string) bool {
+	return true
+}
@@ -0,0 +1,128 @@
+package moderation
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+var ErrUnknownAction = errors.New("unknown moderation action")
+
+// Action is what pipeline does with the text matched by the filter
+type Action string
+
+const (
+	Mask   Action = "mask"   // matched parts are replaced with asterisks
+	Reject Action = "reject" // the whole text is rejected
+	Flag   Action = "flag"   // the text is passed as is but reported
+)
+
+// ParseAction converts name of the action from config to Action
+func ParseAction(name string) (Action, error) {
+	switch action := Action(name); action {
+	case Mask, Reject, Flag:
+		return action, nil
+	}
+	return "", ErrUnknownAction
+}
+
+// Match is a part of the text found by the filter, Start and End are byte
+// offsets in the text
+type Match struct {
+	Start int
+	End   int
+	Word  string
+}
+
+// Filter finds unwanted parts of the text
+type Filter interface {
+	Find(text string) []Match
+}
+
+// Rule applies action to the parts of the text found by the filter
+type Rule struct {
+	Filter Filter
+	Action Action
+}
+
+// Result is the text after moderation and words found in it
+type Result struct {
+	Text     string
+	Rejected bool
+	Masked   []string
+	Flagged  []string
+}
+
+// Clean reports if nothing was found in the text except flagged words
+func (r Result) Clean() bool {
+	return !r.Rejected && len(r.Masked) == 0
+}
+
+// Found reports if any rule has found something in the text
+func (r Result) Found() bool {
+	return !r.Clean() || len(r.Flagged) != 0
+}
+
+// Pipeline applies its rules in order, the text masked by one rule is passed
+// to the next one and the first rejecting rule stops the pipeline
+type Pipeline []Rule
+
+// Moderate passes the text through all rules of the pipeline
+func (p Pipeline) Moderate(text string) Result {
+	res := Result{Text: text}
+	for _, rule := range p {
+		matches := rule.Filter.Find(res.Text)
+		if len(matches) == 0 {
+			continue
+		}
+		switch rule.Action {
+		case Reject:
+			res.Rejected = true
+			return res
+		case Mask:
+			res.Text = mask(res.Text, matches)
+			res.Masked = appendWords(res.Masked, matches)
+		default:
+			res.Flagged = appendWords(res.Flagged, matches)
+		}
+	}
+	return res
+}
+
+// mask replaces all non-space characters of matches with asterisks
+func mask(text string, matches []Match) string {
+	var b strings.Builder
+	b.Grow(len(text))
+	for i, r := range text {
+		masked := false
+		for _, m := range matches {
+			if i >= m.Start && i < m.End {
+				masked = !unicode.IsSpace(r)
+				break
+			}
+		}
+		if masked {
+			b.WriteByte('*')
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// appendWords adds words of matches to the list skipping duplicates
+func appendWords(words []string, matches []Match) []string {
+	for _, m := range matches {
+		found := false
+		for _, w := range words {
+			if w == m.Word {
+				found = true
+				break
+			}
+		}
+		if !found {
+			words = append(words, m.Word)
+		}
+	}
+	return words
+}
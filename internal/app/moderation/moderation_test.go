@@ -0,0 +1,104 @@
+package moderation
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWordFilter(t *testing.T) {
+	filter := NewWordFilter("fuck", "badword", "ass", "!class", "!assistant")
+	tests := []struct {
+		description string
+		text        string
+		expected    []string
+	}{
+		{"clean text", "Hello, world!", nil},
+		{"plain word", "what the fuck", []string{"fuck"}},
+		{"upper case", "FUCK", []string{"fuck"}},
+		{"inside other word", "fuck_you", []string{"fuck"}},
+		{"letter substitution", "b4dw0rd", []string{"badword"}},
+		{"symbol substitution", "b@dw0rd", []string{"badword"}},
+		{"Cyrillic letters", "fuсk", []string{"fuck"}},
+		{"separators", "f.u.c.k", []string{"fuck"}},
+		{"spaced letters", "f u c k you", []string{"fuck"}},
+		{"repeated letters", "fuuuuck", []string{"fuck"}},
+		{"words aren't joined", "bad word", nil},
+		{"two words", "fuck badword", []string{"fuck", "badword"}},
+		{"missing double letter", "fuk", nil},
+		{"allowed word", "class assistant", nil},
+		{"listed word next to allowed one", "ass class", []string{"ass"}},
+	}
+	for _, test := range tests {
+		var words []string
+		for _, m := range filter.Find(test.text) {
+			words = append(words, m.Word)
+		}
+		assert.Equal(t, test.expected, words, test.description)
+	}
+}
+
+func TestDefaultWordFilter(t *testing.T) {
+	filter := DefaultWordFilter()
+
+	// place names and ordinary words which contain listed words with single
+	// letters instead of double ones
+	for _, text := range []string{"I moved to Nigeria last year", "Niger river", "Nigerian_fan", "snigger"} {
+		assert.Empty(t, filter.Find(text), text)
+	}
+	for _, text := range []string{"nigger", "N1GGGER"} {
+		assert.NotEmpty(t, filter.Find(text), text)
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	pipeline := Pipeline{
+		{Filter: NewWordFilter("spam"), Action: Flag},
+		{Filter: NewWordFilter("fuck"), Action: Mask},
+		{Filter: NewWordFilter("kill"), Action: Reject},
+	}
+
+	res := pipeline.Moderate("f u c k this s.p.a.m")
+	assert.Equal(t, "* * * * this s.p.a.m", res.Text)
+	assert.Equal(t, []string{"fuck"}, res.Masked)
+	assert.Equal(t, []string{"spam"}, res.Flagged)
+	assert.False(t, res.Rejected)
+	assert.False(t, res.Clean())
+
+	res = pipeline.Moderate("Fuuuck, привет")
+	assert.Equal(t, "******, привет", res.Text)
+
+	res = pipeline.Moderate("spam")
+	assert.True(t, res.Clean())
+
+	res = pipeline.Moderate("k1ll them")
+	assert.True(t, res.Rejected)
+}
+
+func TestLoadWordFilter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "words.txt")
+	assert.NoError(t, os.WriteFile(path, []byte("# comment\n\nbadword\n  other  \n"), 0o600))
+
+	filter, err := LoadWordFilter(path)
+	assert.NoError(t, err)
+	assert.Len(t, filter.Find("badword and other"), 2)
+
+	_, err = LoadWordFilter(filepath.Join(t.TempDir(), "missing.txt"))
+	assert.Error(t, err)
+
+	filter, err = LoadWordFilter("")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, filter.Find(strings.ToUpper("fuck")))
+}
+
+func TestParseAction(t *testing.T) {
+	action, err := ParseAction("reject")
+	assert.NoError(t, err)
+	assert.Equal(t, Reject, action)
+
+	_, err = ParseAction("ban")
+	assert.Equal(t, ErrUnknownAction, err)
+}
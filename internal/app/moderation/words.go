@@ -0,0 +1,227 @@
+package moderation
+
+import (
+	"bufio"
+	_ "embed"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+//go:embed words.txt
+var defaultWords string
+
+// substitutions are digits, symbols and Cyrillic letters which are used
+// instead of similar Latin letters
+var substitutions = map[rune]rune{
+	'0': 'o', '1': 'i', '!': 'i', '|': 'i', '3': 'e', '4': 'a', '@': 'a',
+	'5': 's', '$': 's', '7': 't', '+': 't', '8': 'b', '9': 'g',
+	'а': 'a', 'в': 'b', 'е': 'e', 'к': 'k', 'м': 'm', 'н': 'h', 'о': 'o',
+	'р': 'p', 'с': 'c', 'т': 't', 'у': 'y', 'х': 'x',
+}
+
+// fold converts character to its canonical form, returns false for
+// separators which are skipped
+func fold(r rune) (rune, bool) {
+	r = unicode.ToLower(r)
+	if s, ok := substitutions[r]; ok {
+		r = s
+	}
+	return r, unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// folded is a canonical character repeated n times in a row and its
+// position in the original text
+type folded struct {
+	r          rune
+	n          int
+	start, end int
+}
+
+// allowPrefix marks words of the list which are allowed even if they contain
+// other words of the list
+const allowPrefix = "!"
+
+// WordFilter finds words of the list in the text ignoring case, letter
+// substitutions, repeated letters and separators between letters. Words
+// found inside allowed words aren't reported
+type WordFilter struct {
+	words   [][]folded
+	names   []string
+	allowed [][]folded
+}
+
+// NewWordFilter creates WordFilter looking for the words, words starting
+// with ! are allowed ones
+func NewWordFilter(words ...string) *WordFilter {
+	f := &WordFilter{}
+	for _, word := range words {
+		if allowed := strings.TrimPrefix(word, allowPrefix); allowed != word {
+			if canonical := foldText(allowed, 0); len(canonical) != 0 {
+				f.allowed = append(f.allowed, canonical)
+			}
+			continue
+		}
+		if canonical := foldText(word, 0); len(canonical) != 0 {
+			f.words = append(f.words, canonical)
+			f.names = append(f.names, word)
+		}
+	}
+	return f
+}
+
+// DefaultWordFilter creates WordFilter with built-in word list
+func DefaultWordFilter() *WordFilter {
+	words, _ := ReadWordList(strings.NewReader(defaultWords))
+	return NewWordFilter(words...)
+}
+
+// LoadWordFilter creates WordFilter with word list from the file, built-in
+// word list is used if path is empty
+func LoadWordFilter(path string) (*WordFilter, error) {
+	if path == "" {
+		return DefaultWordFilter(), nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	words, err := ReadWordList(file)
+	if err != nil {
+		return nil, err
+	}
+	return NewWordFilter(words...), nil
+}
+
+// ReadWordList reads one word per line skipping empty lines and comments
+// starting with #
+func ReadWordList(r io.Reader) ([]string, error) {
+	var words []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+	return words, scanner.Err()
+}
+
+// Find returns all occurrences of the words in the text. Words are matched
+// inside other words unless they are parts of allowed words, and letters
+// separated by spaces ("f u c k") are joined together
+func (f *WordFilter) Find(text string) []Match {
+	var matches []Match
+	for _, group := range groupTokens(text) {
+		chars := foldText(text[group[0]:group[1]], group[0])
+		allowed := findAll(chars, f.allowed)
+		for w, word := range f.words {
+			for _, found := range findAll(chars, [][]folded{word}) {
+				if !inside(found, allowed) {
+					matches = append(matches, Match{
+						Start: found[0],
+						End:   found[1],
+						Word:  f.names[w],
+					})
+				}
+			}
+		}
+	}
+	return matches
+}
+
+// findAll returns byte ranges of all occurrences of the words in chars
+func findAll(chars []folded, words [][]folded) [][2]int {
+	var found [][2]int
+	for _, word := range words {
+		for i := 0; i+len(word) <= len(chars); i++ {
+			if hasPrefix(chars[i:], word) {
+				found = append(found, [2]int{chars[i].start, chars[i+len(word)-1].end})
+			}
+		}
+	}
+	return found
+}
+
+// inside reports if the range is a part of any of the ranges
+func inside(r [2]int, ranges [][2]int) bool {
+	for _, other := range ranges {
+		if r[0] >= other[0] && r[1] <= other[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// foldText converts text to canonical characters skipping separators and
+// collapsing repeated characters into one counting them, offset is added to
+// positions
+func foldText(text string, offset int) []folded {
+	var chars []folded
+	for i, r := range text {
+		c, ok := fold(r)
+		if !ok {
+			continue
+		}
+		end := offset + i + utf8.RuneLen(r)
+		if n := len(chars); n != 0 && chars[n-1].r == c {
+			chars[n-1].n++
+			chars[n-1].end = end
+			continue
+		}
+		chars = append(chars, folded{r: c, n: 1, start: offset + i, end: end})
+	}
+	return chars
+}
+
+// groupTokens splits the text by spaces and returns byte ranges of tokens,
+// runs of single character tokens are joined into one range
+func groupTokens(text string) [][2]int {
+	var tokens [][2]int
+	start := -1
+	for i, r := range text {
+		if unicode.IsSpace(r) {
+			if start >= 0 {
+				tokens = append(tokens, [2]int{start, i})
+				start = -1
+			}
+		} else if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, [2]int{start, len(text)})
+	}
+
+	var groups [][2]int
+	for i := 0; i < len(tokens); i++ {
+		group := tokens[i]
+		for isSingle(text, tokens[i]) && i+1 < len(tokens) && isSingle(text, tokens[i+1]) {
+			i++
+			group[1] = tokens[i][1]
+		}
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// isSingle reports if the token is one character
+func isSingle(text string, token [2]int) bool {
+	return utf8.RuneCountInString(text[token[0]:token[1]]) == 1
+}
+
+// hasPrefix reports if chars start with the word, repeated letter of the
+// word matches the same letter repeated at least as many times, so "niger"
+// doesn't match "nigger" but "fuuuck" matches "fuck"
+func hasPrefix(chars []folded, word []folded) bool {
+	for i, c := range word {
+		if chars[i].r != c.r || chars[i].n < c.n {
+			return false
+		}
+	}
+	return true
+}
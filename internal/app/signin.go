@@ -0,0 +1,114 @@
+package app
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Defaults of SignInLimits
+const (
+	defaultFreeAttempts   = 5
+	defaultIPFreeAttempts = 20
+	defaultBaseLockout    = time.Second
+	defaultMaxLockout     = 15 * time.Minute
+	defaultAttemptsWindow = time.Hour
+)
+
+// SignInLimits protect passwords from guessing. Failed attempts are counted
+// per nickname and per client IP within AttemptsWindow. When free attempts
+// are used up, each next failure locks sign in for BaseLockout doubled with
+// every failure, but not longer than MaxLockout
+type SignInLimits struct {
+	FreeAttempts   int // per nickname
+	IPFreeAttempts int // per IP, many users may share it behind NAT
+	BaseLockout    time.Duration
+	MaxLockout     time.Duration
+	AttemptsWindow time.Duration
+}
+
+func (l SignInLimits) withDefaults() SignInLimits {
+	if l.FreeAttempts <= 0 {
+		l.FreeAttempts = defaultFreeAttempts
+	}
+	if l.IPFreeAttempts <= 0 {
+		l.IPFreeAttempts = defaultIPFreeAttempts
+	}
+	if l.BaseLockout <= 0 {
+		l.BaseLockout = defaultBaseLockout
+	}
+	if l.MaxLockout <= 0 {
+		l.MaxLockout = defaultMaxLockout
+	}
+	if l.AttemptsWindow <= 0 {
+		l.AttemptsWindow = defaultAttemptsWindow
+	}
+	return l
+}
+
+// lockout returns how long sign in is locked after the failed attempt with
+// given number
+func (l SignInLimits) lockout(failures int64, free int) time.Duration {
+	extra := failures - int64(free)
+	if extra <= 0 {
+		return 0
+	}
+	lock := l.MaxLockout
+	if extra <= 32 {
+		if d := l.BaseLockout << (extra - 1); d > 0 && d < lock {
+			lock = d
+		}
+	}
+	return lock
+}
+
+func nicknameKey(nickname string) string {
+	return "nickname:" + nickname
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// signInKeys returns keys of the failed attempts counters, IP is unknown if
+// it is empty
+func signInKeys(nickname, ip string) []string {
+	keys := make([]string, 0, 2)
+	if nickname != "" {
+		keys = append(keys, nicknameKey(nickname))
+	}
+	if ip != "" {
+		keys = append(keys, ipKey(ip))
+	}
+	return keys
+}
+
+// failSignIn counts failed attempt of the nickname and of the IP and locks
+// them if they have no free attempts left
+func (a *app) failSignIn(ctx context.Context, nickname, ip string) {
+	free := map[string]int{
+		nicknameKey(nickname): a.signInLimits.FreeAttempts,
+		ipKey(ip):             a.signInLimits.IPFreeAttempts,
+	}
+	for _, key := range signInKeys(nickname, ip) {
+		failures, err := a.AddFailedSignIn(ctx, key, a.signInLimits.AttemptsWindow)
+		if err != nil {
+			log.Println("can't count failed sign in attempt of", key, err.Error())
+			continue
+		}
+		if lock := a.signInLimits.lockout(failures, free[key]); lock > 0 {
+			log.Println("sign in of", key, "is locked for", lock, "after", failures, "failed attempts")
+			if err := a.LockSignIn(ctx, key, lock); err != nil {
+				log.Println("can't lock sign in of", key, err.Error())
+			}
+		}
+	}
+}
+
+func (a *app) UnlockSignIn(ctx context.Context, nickname, ip string) error {
+	keys := signInKeys(nickname, ip)
+	if len(keys) == 0 {
+		return nil
+	}
+	return a.ResetFailedSignIns(ctx, keys...)
+}
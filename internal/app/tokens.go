@@ -0,0 +1,102 @@
+package app
+
+import (
+	"console-chat/internal/model"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"log"
+	"time"
+)
+
+// defaultRefreshTTL is how long refresh token stays valid if it isn't
+// exchanged
+const defaultRefreshTTL = 30 * 24 * time.Hour
+
+// randomString returns n random bytes encoded as url-safe base64
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashRefreshToken returns hash of the plain refresh token which is stored
+// in the repo instead of the token itself
+func hashRefreshToken(token string) string {
+	hashSum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hashSum[:])
+}
+
+func (a *app) IssueRefreshToken(ctx context.Context, nickname string) (model.RefreshToken, error) {
+	family, err := randomString(16)
+	if err != nil {
+		return model.RefreshToken{}, model.TokenRepoError
+	}
+	return a.addRefreshToken(ctx, nickname, family)
+}
+
+func (a *app) RotateRefreshToken(ctx context.Context, token string) (model.RefreshToken, error) {
+	t, err := a.tokenRepo.GetRefreshToken(ctx, hashRefreshToken(token))
+	if err != nil {
+		return model.RefreshToken{}, err
+	}
+	if t.Revoked || !time.Now().Before(t.ExpiresAt) {
+		return model.RefreshToken{}, model.RefreshTokenInvalid
+	}
+
+	// token may be exchanged only once, second exchange means that it was
+	// stolen, so nobody may use the family anymore
+	if err := a.tokenRepo.UseRefreshToken(ctx, t.Hash); err == model.RefreshTokenReused {
+		log.Println("refresh token of", t.Nickname, "was reused, revoking its family")
+		if err := a.tokenRepo.RevokeTokenFamily(ctx, t.Family); err != nil {
+			return model.RefreshToken{}, err
+		}
+		return model.RefreshToken{Family: t.Family, Nickname: t.Nickname}, model.RefreshTokenReused
+	} else if err != nil {
+		return model.RefreshToken{}, err
+	}
+
+	return a.addRefreshToken(ctx, t.Nickname, t.Family)
+}
+
+func (a *app) RevokeSession(ctx context.Context, family string) error {
+	return a.tokenRepo.RevokeTokenFamily(ctx, family)
+}
+
+func (a *app) RevokeToken(ctx context.Context, id string, expiresAt time.Time) error {
+	// revoked token is kept in the list only while it could be used
+	ttl := time.Until(expiresAt)
+	if id == "" || ttl <= 0 {
+		return nil
+	}
+	return a.tokenRepo.AddRevokedToken(ctx, id, ttl)
+}
+
+func (a *app) IsTokenRevoked(ctx context.Context, ids ...string) (bool, error) {
+	return a.tokenRepo.IsTokenRevoked(ctx, ids...)
+}
+
+// addRefreshToken creates new refresh token of the family
+func (a *app) addRefreshToken(ctx context.Context, nickname, family string) (model.RefreshToken, error) {
+	token, err := randomString(32)
+	if err != nil {
+		return model.RefreshToken{}, model.TokenRepoError
+	}
+	now := time.Now().UTC()
+	t, err := a.tokenRepo.AddRefreshToken(ctx, model.RefreshToken{
+		Hash:      hashRefreshToken(token),
+		Family:    family,
+		Nickname:  nickname,
+		CreatedAt: now,
+		ExpiresAt: now.Add(a.refreshTTL),
+	})
+	if err != nil {
+		return model.RefreshToken{}, err
+	}
+	t.Token = token
+	return t, nil
+}
@@ -0,0 +1,141 @@
+package app
+
+import (
+	"console-chat/internal/model"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// memTokenRepo is an in-memory TokenRepo for testing
+type memTokenRepo struct {
+	tokens  map[string]model.RefreshToken
+	revoked map[string]time.Duration
+}
+
+func (r *memTokenRepo) AddRefreshToken(_ context.Context, t model.RefreshToken) (model.RefreshToken, error) {
+	r.tokens[t.Hash] = t
+	return t, nil
+}
+
+func (r *memTokenRepo) GetRefreshToken(_ context.Context, hash string) (model.RefreshToken, error) {
+	if t, ok := r.tokens[hash]; ok {
+		return t, nil
+	}
+	return model.RefreshToken{}, model.RefreshTokenInvalid
+}
+
+func (r *memTokenRepo) UseRefreshToken(_ context.Context, hash string) error {
+	t := r.tokens[hash]
+	if !t.UsedAt.IsZero() || t.Revoked {
+		return model.RefreshTokenReused
+	}
+	t.UsedAt = time.Now()
+	r.tokens[hash] = t
+	return nil
+}
+
+func (r *memTokenRepo) RevokeTokenFamily(_ context.Context, family string) error {
+	for hash, t := range r.tokens {
+		if t.Family == family {
+			t.Revoked = true
+			r.tokens[hash] = t
+		}
+	}
+	return nil
+}
+
+func (r *memTokenRepo) AddRevokedToken(_ context.Context, id string, ttl time.Duration) error {
+	r.revoked[id] = ttl
+	return nil
+}
+
+func (r *memTokenRepo) IsTokenRevoked(_ context.Context, ids ...string) (bool, error) {
+	for _, id := range ids {
+		if _, ok := r.revoked[id]; ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	repo := &memTokenRepo{tokens: make(map[string]model.RefreshToken), revoked: make(map[string]time.Duration)}
+	a := New(nil, nil, repo, nil, Config{RefreshTTL: time.Hour})
+	ctx := context.Background()
+
+	first, err := a.IssueRefreshToken(ctx, "papey08")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, first.Token)
+	assert.NotContains(t, repo.tokens, first.Token) // only hash is stored
+	assert.WithinDuration(t, time.Now().Add(time.Hour), first.ExpiresAt, time.Second)
+
+	second, err := a.RotateRefreshToken(ctx, first.Token)
+	assert.NoError(t, err)
+	assert.Equal(t, "papey08", second.Nickname)
+	assert.Equal(t, first.Family, second.Family)
+	assert.NotEqual(t, first.Token, second.Token)
+
+	third, err := a.RotateRefreshToken(ctx, second.Token)
+	assert.NoError(t, err)
+
+	// another sign in starts independent family
+	other, err := a.IssueRefreshToken(ctx, "papey08")
+	assert.NoError(t, err)
+	assert.NotEqual(t, first.Family, other.Family)
+
+	// reuse of exchanged token revokes the whole family including the
+	// latest token
+	reused, err := a.RotateRefreshToken(ctx, first.Token)
+	assert.Equal(t, model.RefreshTokenReused, err)
+	assert.Equal(t, first.Family, reused.Family)
+	_, err = a.RotateRefreshToken(ctx, third.Token)
+	assert.Equal(t, model.RefreshTokenInvalid, err)
+
+	_, err = a.RotateRefreshToken(ctx, other.Token)
+	assert.NoError(t, err)
+
+	_, err = a.RotateRefreshToken(ctx, "unknown")
+	assert.Equal(t, model.RefreshTokenInvalid, err)
+}
+
+func TestExpiredRefreshToken(t *testing.T) {
+	repo := &memTokenRepo{tokens: make(map[string]model.RefreshToken), revoked: make(map[string]time.Duration)}
+	a := New(nil, nil, repo, nil, Config{RefreshTTL: time.Millisecond})
+	ctx := context.Background()
+
+	refresh, err := a.IssueRefreshToken(ctx, "papey08")
+	assert.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+
+	_, err = a.RotateRefreshToken(ctx, refresh.Token)
+	assert.Equal(t, model.RefreshTokenInvalid, err)
+}
+
+func TestRevokeToken(t *testing.T) {
+	repo := &memTokenRepo{tokens: make(map[string]model.RefreshToken), revoked: make(map[string]time.Duration)}
+	a := New(nil, nil, repo, nil, Config{RefreshTTL: time.Hour})
+	ctx := context.Background()
+
+	// token is kept in the list only for the rest of its lifetime
+	assert.NoError(t, a.RevokeToken(ctx, "jti", time.Now().Add(time.Minute)))
+	assert.InDelta(t, time.Minute, repo.revoked["jti"], float64(time.Second))
+	revoked, err := a.IsTokenRevoked(ctx, "other", "jti")
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+
+	// already expired token isn't added
+	assert.NoError(t, a.RevokeToken(ctx, "expired", time.Now().Add(-time.Minute)))
+	revoked, err = a.IsTokenRevoked(ctx, "expired")
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+
+	// revoked session can't be refreshed
+	refresh, err := a.IssueRefreshToken(ctx, "papey08")
+	assert.NoError(t, err)
+	assert.NoError(t, a.RevokeSession(ctx, refresh.Family))
+	_, err = a.RotateRefreshToken(ctx, refresh.Token)
+	assert.Equal(t, model.RefreshTokenInvalid, err)
+}
@@ -1,6 +1,6 @@
 package valid
 
-import "strings"
+import "console-chat/internal/app/moderation"
 
 const allowedNicknameSymbols = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_"
 
@@ -13,10 +13,9 @@ func isAllowed(c rune, allowedSymbols string) bool {
 	return false
 }
 
-var obscenities = map[string]struct{}{
-	"fuck":   {},
-	"nigger": {},
-}
+// obscenities finds words of the built-in list which are never allowed in
+// nicknames
+var obscenities = moderation.DefaultWordFilter()
 
 // IsValidNickname checks if nickname has valid len, contains only allowed
 // symbols and doesn't contain obscenities
@@ -35,11 +34,8 @@ func IsValidNickname(nickname string) bool {
 	}
 
 	// check if nickname doesn't contain obscenities
-	lowerNickname := strings.ToLower(nickname)
-	for word := range obscenities {
-		if strings.Contains(lowerNickname, word) {
-			return false
-		}
+	if len(obscenities.Find(nickname)) != 0 {
+		return false
 	}
 
 	return true
@@ -44,6 +44,11 @@ func TestIsValidNickname(t *testing.T) {
 			nickname:       "fuck_you",
 			expectedResult: false,
 		},
+		{
+			description:    "place name similar to obscenity",
+			nickname:       "Nigerian_fan",
+			expectedResult: true,
+		},
 	}
 
 	for _, test := range tests {
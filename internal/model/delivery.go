@@ -0,0 +1,9 @@
+package model
+
+// Delivery is a message queued for the recipient who was offline when it
+// was sent
+type Delivery struct {
+	ID        int64
+	Recipient string
+	Message   Message
+}
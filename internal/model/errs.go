@@ -1,6 +1,9 @@
 package model
 
-import "errors"
+import (
+	"errors"
+	"time"
+)
 
 var UserNotFound = errors.New("could not find required user")
 var UserRepoError = errors.New("something wrong with user repo")
@@ -8,3 +11,24 @@ var UserAlreadyExists = errors.New("user with required nickname already exists")
 var UserWrongPassword = errors.New("wrong password of required user")
 var UserInvalidNickname = errors.New("user has invalid nickname")
 var UserInvalidPassword = errors.New("user has invalid password")
+var PasswordHashError = errors.New("can't process password hash")
+var MessageRepoError = errors.New("something wrong with message repo")
+var MessageRejected = errors.New("message was rejected by moderation")
+var TokenRepoError = errors.New("something wrong with token repo")
+var RefreshTokenInvalid = errors.New("refresh token is invalid or expired")
+var RefreshTokenReused = errors.New("refresh token was already used")
+var UserSignInLocked = errors.New("too many failed sign in attempts, try again later")
+
+// SignInLockedError is returned instead of checking password when sign in
+// with the nickname or from the IP is temporarily locked
+type SignInLockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *SignInLockedError) Error() string {
+	return UserSignInLocked.Error()
+}
+
+func (e *SignInLockedError) Unwrap() error {
+	return UserSignInLocked
+}
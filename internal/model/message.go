@@ -0,0 +1,13 @@
+package model
+
+import "time"
+
+type Message struct {
+	ID        int64
+	Seq       int64 // number of the message in the room, 0 for direct messages
+	Room      string
+	Sender    string
+	To        string // recipient of direct message, empty for room messages
+	Text      string
+	CreatedAt time.Time
+}
@@ -0,0 +1,17 @@
+package model
+
+import "time"
+
+// RefreshToken is a long-lived token which is exchanged for a new access
+// token and a new refresh token. Only hash of the token is stored, all tokens
+// issued by rotation since one sign in belong to the same family
+type RefreshToken struct {
+	Token     string // plain token, known only right after it was issued
+	Hash      string
+	Family    string
+	Nickname  string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    time.Time // zero until the token is exchanged
+	Revoked   bool
+}
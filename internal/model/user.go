@@ -1,6 +1,9 @@
 package model
 
+import "time"
+
 type User struct {
 	Nickname       string
 	HashedPassword string
+	CreatedAt      time.Time
 }
@@ -0,0 +1,124 @@
+package broker
+
+import (
+	"console-chat/internal/protocol"
+	"context"
+	"time"
+)
+
+// Kind is a kind of event sent between instances of the chat server
+type Kind string
+
+const (
+	KindRoom   Kind = "room"   // Frame is sent to members of Room
+	KindUser   Kind = "user"   // Frame is sent to sessions of User
+	KindJoin   Kind = "join"   // User has joined Room
+	KindLeave  Kind = "leave"  // User has left Room
+	KindRevoke Kind = "revoke" // sessions opened with tokens IDs are closed
+
+	KindPresence Kind = "presence" // Frame is sent to sessions watching presence
+)
+
+// Lifetimes of claims of messages with client-generated IDs: claim of the
+// message which is being handled expires if its instance stops before the
+// message is handled, ack is kept for retries of the client
+const (
+	claimTTL = 30 * time.Second
+	ackTTL   = 24 * time.Hour
+)
+
+// resumeTTL is how long rooms of the user who has closed the last session
+// are kept for the client which reconnects after losing the connection
+const resumeTTL = 10 * time.Minute
+
+// Event is a message from one instance of the chat server to all others
+type Event struct {
+	Kind   Kind   `json:"kind"`
+	Origin string `json:"origin"` // ID of the instance which published the event
+
+	Room   string          `json:"room,omitempty"`
+	User   string          `json:"user,omitempty"`
+	Except string          `json:"except,omitempty"` // ID of the session which shouldn't get Frame
+	Frame  *protocol.Frame `json:"frame,omitempty"`
+	IDs    []string        `json:"ids,omitempty"`
+}
+
+// Broker delivers events between instances of the chat server and keeps
+// state of the chat shared by all of them: sessions of users and members of
+// rooms, acks of handled messages and rooms of users who have lost the
+// connection
+type Broker interface {
+	// Publish sends event to subscribers of all instances including this one
+	Publish(ctx context.Context, e Event) error
+
+	// Subscribe returns events published by any instance until ctx is done,
+	// channel may be closed if subscription fails
+	Subscribe(ctx context.Context) (<-chan Event, error)
+
+	// AddSession registers session of the user, returns true if it is the
+	// first session of the user in the cluster
+	AddSession(ctx context.Context, nickname, sessionID string) (bool, error)
+
+	// RemoveSession unregisters session of the user, returns true if it was
+	// the last session of the user in the cluster
+	RemoveSession(ctx context.Context, nickname, sessionID string) (bool, error)
+
+	// IsOnline checks if user has sessions on any instance
+	IsOnline(ctx context.Context, nickname string) (bool, error)
+
+	// SetStatus changes presence status of the online user
+	SetStatus(ctx context.Context, nickname, status string) error
+
+	// Presence returns presence of the users, users without sessions are
+	// offline. Adding the first session makes user online and removing the
+	// last one makes user offline
+	Presence(ctx context.Context, nicknames ...string) ([]protocol.Presence, error)
+
+	// OnlineUsers returns sorted nicknames of users having sessions on any
+	// instance
+	OnlineUsers(ctx context.Context) ([]string, error)
+
+	// JoinRoom adds user to members of the room, returns false if user is
+	// already a member
+	JoinRoom(ctx context.Context, room, nickname string) (bool, error)
+
+	// LeaveRoom removes user from members of the room, empty rooms are
+	// deleted. Returns false if user wasn't a member
+	LeaveRoom(ctx context.Context, room, nickname string) (bool, error)
+
+	// RoomMembers returns nicknames of members of the room
+	RoomMembers(ctx context.Context, room string) ([]string, error)
+
+	// UserRooms returns rooms the user is a member of
+	UserRooms(ctx context.Context, nickname string) ([]string, error)
+
+	// Rooms returns all rooms with their member counts
+	Rooms(ctx context.Context) (map[string]int, error)
+
+	// ClaimMessage marks message of the sender with client-generated ID as
+	// being handled, returns false if it was already claimed. Ack of the
+	// handled message is returned with false, it is nil while the message is
+	// still being handled
+	ClaimMessage(ctx context.Context, sender, clientID string) (bool, *protocol.Frame, error)
+
+	// AckMessage saves ack of the claimed message for retries of the client
+	AckMessage(ctx context.Context, sender, clientID string, ack protocol.Frame) error
+
+	// ReleaseMessage drops claim of the rejected message, so that the client
+	// may send it again
+	ReleaseMessage(ctx context.Context, sender, clientID string) error
+
+	// SaveRooms remembers rooms of the user who has closed the last session
+	// for resumeTTL, saved rooms replace previous ones
+	SaveRooms(ctx context.Context, nickname string, rooms []string) error
+
+	// TakeRooms returns and forgets rooms saved by SaveRooms, it returns nil
+	// if they have expired
+	TakeRooms(ctx context.Context, nickname string) ([]string, error)
+}
+
+// claimKey identifies message of the sender with client-generated ID,
+// nicknames can't contain ':'
+func claimKey(sender, clientID string) string {
+	return sender + ":" + clientID
+}
@@ -0,0 +1,316 @@
+package broker
+
+import (
+	"console-chat/internal/protocol"
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// subscriptionSize is a capacity of channels of subscriptions
+const subscriptionSize = 1024
+
+// memory is an in-process Broker, several chat servers sharing it behave as
+// a cluster
+type memory struct {
+	mu          sync.Mutex
+	subscribers map[chan Event]<-chan struct{} // channel -> done of subscription
+	sessions    map[string]map[string]struct{} // nickname -> session IDs
+	rooms       map[string]map[string]struct{} // room -> nicknames
+	presence    map[string]protocol.Presence   // nickname -> last known presence
+	claims      map[string]claim               // claim key -> claim of the message
+	lastSweep   time.Time
+	saved       map[string]savedRooms // nickname -> rooms saved on the last close
+}
+
+// claim is a claim of the message, ack is nil while it is being handled
+type claim struct {
+	ack       *protocol.Frame
+	expiresAt time.Time
+}
+
+// savedRooms are rooms of the user who has closed the last session
+type savedRooms struct {
+	rooms     []string
+	expiresAt time.Time
+}
+
+// NewMemory creates Broker for chat servers running in the same process
+func NewMemory() Broker {
+	return &memory{
+		subscribers: make(map[chan Event]<-chan struct{}),
+		sessions:    make(map[string]map[string]struct{}),
+		rooms:       make(map[string]map[string]struct{}),
+		presence:    make(map[string]protocol.Presence),
+		claims:      make(map[string]claim),
+		saved:       make(map[string]savedRooms),
+	}
+}
+
+// Publish waits until every subscriber has room for the event, it shouldn't
+// be called by subscriber which stopped reading its channel
+func (m *memory) Publish(ctx context.Context, e Event) error {
+	m.mu.Lock()
+	subscribers := make(map[chan Event]<-chan struct{}, len(m.subscribers))
+	for ch, done := range m.subscribers {
+		subscribers[ch] = done
+	}
+	m.mu.Unlock()
+
+	for ch, done := range subscribers {
+		select {
+		case ch <- e:
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Subscribe never fails and the channel is never closed, subscriber should
+// stop reading it when ctx is done
+func (m *memory) Subscribe(ctx context.Context) (<-chan Event, error) {
+	ch := make(chan Event, subscriptionSize)
+	m.mu.Lock()
+	m.subscribers[ch] = ctx.Done()
+	m.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		m.mu.Lock()
+		delete(m.subscribers, ch)
+		m.mu.Unlock()
+	}()
+	return ch, nil
+}
+
+func (m *memory) AddSession(_ context.Context, nickname, sessionID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	first := add(m.sessions, nickname, sessionID)
+	p := m.presence[nickname]
+	if first {
+		p.Status = protocol.StatusOnline
+	}
+	m.setPresence(nickname, p.Status)
+	return first, nil
+}
+
+func (m *memory) RemoveSession(_ context.Context, nickname, sessionID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !remove(m.sessions, nickname, sessionID) {
+		return false, nil
+	}
+	if len(m.sessions[nickname]) != 0 {
+		m.setPresence(nickname, m.presence[nickname].Status)
+		return false, nil
+	}
+	m.setPresence(nickname, protocol.StatusOffline)
+	return true, nil
+}
+
+func (m *memory) IsOnline(_ context.Context, nickname string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.sessions[nickname]) != 0, nil
+}
+
+func (m *memory) SetStatus(_ context.Context, nickname, status string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.sessions[nickname]) != 0 {
+		m.setPresence(nickname, status)
+	}
+	return nil
+}
+
+// setPresence changes status of the user and updates last seen time, should
+// be called under m.mu
+func (m *memory) setPresence(nickname, status string) {
+	m.presence[nickname] = protocol.Presence{
+		Nickname: nickname,
+		Status:   status,
+		LastSeen: time.Now().UTC(),
+	}
+}
+
+func (m *memory) Presence(_ context.Context, nicknames ...string) ([]protocol.Presence, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	presence := make([]protocol.Presence, 0, len(nicknames))
+	for _, nickname := range nicknames {
+		p, ok := m.presence[nickname]
+		if !ok || len(m.sessions[nickname]) == 0 {
+			p.Nickname = nickname
+			p.Status = protocol.StatusOffline
+		}
+		presence = append(presence, p)
+	}
+	return presence, nil
+}
+
+func (m *memory) OnlineUsers(_ context.Context) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return sortedKeys(m.sessions), nil
+}
+
+func (m *memory) RoomMembers(_ context.Context, room string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return sortedKeys(m.rooms[room]), nil
+}
+
+func (m *memory) JoinRoom(_ context.Context, room, nickname string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.rooms[room][nickname]; ok {
+		return false, nil
+	}
+	add(m.rooms, room, nickname)
+	return true, nil
+}
+
+func (m *memory) LeaveRoom(_ context.Context, room, nickname string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return remove(m.rooms, room, nickname), nil
+}
+
+func (m *memory) UserRooms(_ context.Context, nickname string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rooms := make([]string, 0)
+	for room, members := range m.rooms {
+		if _, ok := members[nickname]; ok {
+			rooms = append(rooms, room)
+		}
+	}
+	return rooms, nil
+}
+
+func (m *memory) Rooms(_ context.Context) (map[string]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rooms := make(map[string]int, len(m.rooms))
+	for room, members := range m.rooms {
+		rooms[room] = len(members)
+	}
+	return rooms, nil
+}
+
+func (m *memory) ClaimMessage(_ context.Context, sender, clientID string) (bool, *protocol.Frame, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(m.lastSweep) >= claimTTL {
+		for key, cl := range m.claims {
+			if !now.Before(cl.expiresAt) {
+				delete(m.claims, key)
+			}
+		}
+		m.lastSweep = now
+	}
+
+	key := claimKey(sender, clientID)
+	if cl, ok := m.claims[key]; ok && now.Before(cl.expiresAt) {
+		return false, cl.ack, nil
+	}
+	m.claims[key] = claim{expiresAt: now.Add(claimTTL)}
+	return true, nil, nil
+}
+
+func (m *memory) AckMessage(_ context.Context, sender, clientID string, ack protocol.Frame) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.claims[claimKey(sender, clientID)] = claim{
+		ack:       &ack,
+		expiresAt: time.Now().Add(ackTTL),
+	}
+	return nil
+}
+
+func (m *memory) ReleaseMessage(_ context.Context, sender, clientID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.claims, claimKey(sender, clientID))
+	return nil
+}
+
+func (m *memory) SaveRooms(_ context.Context, nickname string, rooms []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.saved[nickname] = savedRooms{
+		rooms:     append([]string(nil), rooms...),
+		expiresAt: time.Now().Add(resumeTTL),
+	}
+	return nil
+}
+
+func (m *memory) TakeRooms(_ context.Context, nickname string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	saved, ok := m.saved[nickname]
+	delete(m.saved, nickname)
+	if !ok || !time.Now().Before(saved.expiresAt) {
+		return nil, nil
+	}
+	return saved.rooms, nil
+}
+
+// sortedKeys returns sorted keys of the map
+func sortedKeys[V any](set map[string]V) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// add adds value to the set of the key, returns true if the set was empty
+func add(sets map[string]map[string]struct{}, key, value string) bool {
+	set, ok := sets[key]
+	if !ok {
+		set = make(map[string]struct{})
+		sets[key] = set
+	}
+	set[value] = struct{}{}
+	return len(set) == 1
+}
+
+// remove removes value from the set of the key deleting empty sets, returns
+// false if there was no such value
+func remove(sets map[string]map[string]struct{}, key, value string) bool {
+	set, ok := sets[key]
+	if !ok {
+		return false
+	}
+	if _, ok := set[value]; !ok {
+		return false
+	}
+	delete(set, value)
+	if len(set) == 0 {
+		delete(sets, key)
+	}
+	return true
+}
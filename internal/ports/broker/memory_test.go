@@ -0,0 +1,177 @@
+package broker
+
+import (
+	"console-chat/internal/protocol"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryPresence(t *testing.T) {
+	b := NewMemory()
+	ctx := context.Background()
+
+	first, err := b.AddSession(ctx, "user01", "session1")
+	assert.NoError(t, err)
+	assert.True(t, first)
+	first, err = b.AddSession(ctx, "user01", "session2")
+	assert.NoError(t, err)
+	assert.False(t, first)
+	_, _ = b.AddSession(ctx, "user02", "session3")
+
+	// status is kept by other sessions of the user
+	assert.NoError(t, b.SetStatus(ctx, "user01", protocol.StatusAway))
+	users, err := b.OnlineUsers(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"user01", "user02"}, users)
+	presence, err := b.Presence(ctx, "user01", "user02", "user03")
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.StatusAway, presence[0].Status)
+	assert.Equal(t, protocol.StatusOnline, presence[1].Status)
+	assert.Equal(t, protocol.Presence{Nickname: "user03", Status: protocol.StatusOffline}, presence[2])
+
+	last, err := b.RemoveSession(ctx, "user01", "session1")
+	assert.NoError(t, err)
+	assert.False(t, last)
+	online, err := b.IsOnline(ctx, "user01")
+	assert.NoError(t, err)
+	assert.True(t, online)
+
+	// unknown session isn't the last one
+	last, err = b.RemoveSession(ctx, "user01", "session1")
+	assert.NoError(t, err)
+	assert.False(t, last)
+
+	presence, err = b.Presence(ctx, "user01")
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.StatusAway, presence[0].Status)
+
+	last, err = b.RemoveSession(ctx, "user01", "session2")
+	assert.NoError(t, err)
+	assert.True(t, last)
+	online, err = b.IsOnline(ctx, "user01")
+	assert.NoError(t, err)
+	assert.False(t, online)
+
+	// offline user remembers when it was seen, next session makes it online
+	presence, err = b.Presence(ctx, "user01")
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.StatusOffline, presence[0].Status)
+	assert.WithinDuration(t, time.Now(), presence[0].LastSeen, time.Second)
+	users, err = b.OnlineUsers(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"user02"}, users)
+	_, _ = b.AddSession(ctx, "user01", "session4")
+	presence, err = b.Presence(ctx, "user01")
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.StatusOnline, presence[0].Status)
+}
+
+func TestMemoryRooms(t *testing.T) {
+	b := NewMemory()
+	ctx := context.Background()
+
+	joined, err := b.JoinRoom(ctx, "general", "user01")
+	assert.NoError(t, err)
+	assert.True(t, joined)
+	joined, err = b.JoinRoom(ctx, "general", "user01")
+	assert.NoError(t, err)
+	assert.False(t, joined)
+	_, _ = b.JoinRoom(ctx, "general", "user02")
+	_, _ = b.JoinRoom(ctx, "golang", "user01")
+
+	rooms, err := b.UserRooms(ctx, "user01")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"general", "golang"}, rooms)
+	counts, err := b.Rooms(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"general": 2, "golang": 1}, counts)
+
+	// empty rooms are deleted
+	left, err := b.LeaveRoom(ctx, "golang", "user01")
+	assert.NoError(t, err)
+	assert.True(t, left)
+	left, err = b.LeaveRoom(ctx, "golang", "user01")
+	assert.NoError(t, err)
+	assert.False(t, left)
+	counts, err = b.Rooms(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"general": 2}, counts)
+}
+
+func TestMemoryEvents(t *testing.T) {
+	b := NewMemory()
+	ctx, cancel := context.WithCancel(context.Background())
+	events1, err := b.Subscribe(ctx)
+	assert.NoError(t, err)
+	events2, err := b.Subscribe(context.Background())
+	assert.NoError(t, err)
+
+	// every subscriber gets the event
+	e := Event{Kind: KindJoin, Origin: "instance", Room: "general", User: "user01"}
+	assert.NoError(t, b.Publish(context.Background(), e))
+	assert.Equal(t, e, <-events1)
+	assert.Equal(t, e, <-events2)
+
+	// cancelled subscriber doesn't block publishing
+	cancel()
+	time.Sleep(10 * time.Millisecond)
+	for i := 0; i < subscriptionSize+1; i++ {
+		assert.NoError(t, b.Publish(context.Background(), e))
+		<-events2
+	}
+}
+
+func TestMemoryClaims(t *testing.T) {
+	b := NewMemory()
+	ctx := context.Background()
+
+	claimed, ack, err := b.ClaimMessage(ctx, "user01", "client-1")
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+	assert.Nil(t, ack)
+
+	// message being handled has no ack yet, other senders have own IDs
+	claimed, ack, err = b.ClaimMessage(ctx, "user01", "client-1")
+	assert.NoError(t, err)
+	assert.False(t, claimed)
+	assert.Nil(t, ack)
+	claimed, _, err = b.ClaimMessage(ctx, "user02", "client-1")
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+
+	sent := protocol.Frame{Type: protocol.TypeAck, ID: "42", ReplyTo: "client-1"}
+	assert.NoError(t, b.AckMessage(ctx, "user01", "client-1", sent))
+	claimed, ack, err = b.ClaimMessage(ctx, "user01", "client-1")
+	assert.NoError(t, err)
+	assert.False(t, claimed)
+	assert.Equal(t, &sent, ack)
+
+	// released message may be claimed again
+	assert.NoError(t, b.ReleaseMessage(ctx, "user02", "client-1"))
+	claimed, _, err = b.ClaimMessage(ctx, "user02", "client-1")
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+}
+
+func TestMemorySavedRooms(t *testing.T) {
+	b := NewMemory()
+	ctx := context.Background()
+
+	rooms, err := b.TakeRooms(ctx, "user01")
+	assert.NoError(t, err)
+	assert.Nil(t, rooms)
+
+	assert.NoError(t, b.SaveRooms(ctx, "user01", []string{"general", "golang"}))
+	assert.NoError(t, b.SaveRooms(ctx, "user02", []string{"general"}))
+	rooms, err = b.TakeRooms(ctx, "user01")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"general", "golang"}, rooms)
+
+	// saved rooms are taken only once
+	rooms, err = b.TakeRooms(ctx, "user01")
+	assert.NoError(t, err)
+	assert.Nil(t, rooms)
+}
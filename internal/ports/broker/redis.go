@@ -0,0 +1,416 @@
+package broker
+
+import (
+	"console-chat/internal/protocol"
+	"context"
+	"encoding/json"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// eventsChannel is a redis channel events are published to
+const eventsChannel = "chat_events"
+
+// Keys and key prefixes of the shared state, all of them contain ':' so they
+// don't clash with cached users because nicknames can't contain ':'. Keys
+// without nickname use own prefix, so they don't clash with prefixed ones
+const (
+	presenceKeyPrefix     = "presence:"      // sessions of the user with their expiration
+	userPresenceKeyPrefix = "user_presence:" // status and last seen time of the user
+	onlineUsersKey        = "online:users"   // users with their latest session expiration
+	roomKeyPrefix         = "room_members:"  // members of the room with expiration of their membership
+	userRoomsKeyPrefix    = "joined_rooms:"  // rooms of the user with expiration of the membership
+	claimKeyPrefix        = "message_claim:" // ack of the message of the user with client-generated ID
+	savedRoomsKeyPrefix   = "saved_rooms:"   // rooms of the user who has closed the last session
+)
+
+// presenceTTL is how long session and room membership stay without being
+// refreshed by the instance of the user, so sessions and rooms of users of
+// crashed instances are dropped by themselves
+const presenceTTL = time.Minute
+
+// redisBroker is a Broker using redis pub/sub for events and redis sets for
+// shared state
+type redisBroker struct {
+	client *redis.Client
+	ttl    time.Duration // presenceTTL, shorter in tests
+
+	// sessions of this instance and rooms of their users are refreshed every
+	// ttl/3 while it is subscribed
+	mu       sync.Mutex
+	sessions map[string]string // session ID -> nickname
+}
+
+// NewRedis creates Broker for chat servers sharing the redis
+func NewRedis(client *redis.Client) Broker {
+	return &redisBroker{
+		client:   client,
+		ttl:      presenceTTL,
+		sessions: make(map[string]string),
+	}
+}
+
+func (r *redisBroker) Publish(ctx context.Context, e Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return r.client.Publish(ctx, eventsChannel, data).Err()
+}
+
+func (r *redisBroker) Subscribe(ctx context.Context) (<-chan Event, error) {
+	sub := r.client.Subscribe(ctx, eventsChannel)
+	if _, err := sub.Receive(ctx); err != nil {
+		_ = sub.Close()
+		return nil, err
+	}
+
+	ch := make(chan Event, subscriptionSize)
+	go func() {
+		defer close(ch)
+		defer sub.Close()
+
+		messages := sub.Channel()
+		refresh := time.NewTicker(r.ttl / 3)
+		defer refresh.Stop()
+		for {
+			select {
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				var e Event
+				if err := json.Unmarshal([]byte(msg.Payload), &e); err != nil {
+					log.Println("can't decode chat event:", err.Error())
+					continue
+				}
+				select {
+				case ch <- e:
+				case <-ctx.Done():
+					return
+				}
+			case <-refresh.C:
+				r.refreshSessions(ctx)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// refreshSessions extends presence of all sessions of this instance and
+// membership of their users in rooms
+func (r *redisBroker) refreshSessions(ctx context.Context) {
+	r.mu.Lock()
+	sessions := make(map[string]string, len(r.sessions))
+	nicknames := make(map[string]*redis.StringSliceCmd)
+	for sessionID, nickname := range r.sessions {
+		sessions[sessionID] = nickname
+		nicknames[nickname] = nil
+	}
+	r.mu.Unlock()
+
+	expiresAt := r.expiresAt()
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for sessionID, nickname := range sessions {
+			pipe.ZAdd(ctx, presenceKeyPrefix+nickname, &redis.Z{Score: expiresAt, Member: sessionID})
+			pipe.Expire(ctx, presenceKeyPrefix+nickname, r.ttl)
+			pipe.ZAdd(ctx, onlineUsersKey, &redis.Z{Score: expiresAt, Member: nickname})
+		}
+		for nickname := range nicknames {
+			nicknames[nickname] = pipe.ZRange(ctx, userRoomsKeyPrefix+nickname, 0, -1)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("can't refresh presence of chat sessions:", err.Error())
+		return
+	}
+
+	// only existing members are updated, so users who have left rooms
+	// meanwhile aren't added back
+	_, err = r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for nickname, rooms := range nicknames {
+			for _, room := range rooms.Val() {
+				pipe.ZAddXX(ctx, roomKeyPrefix+room, &redis.Z{Score: expiresAt, Member: nickname})
+				pipe.Expire(ctx, roomKeyPrefix+room, r.ttl)
+				pipe.ZAddXX(ctx, userRoomsKeyPrefix+nickname, &redis.Z{Score: expiresAt, Member: room})
+			}
+			if len(rooms.Val()) != 0 {
+				pipe.Expire(ctx, userRoomsKeyPrefix+nickname, r.ttl)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("can't refresh rooms of chat sessions:", err.Error())
+	}
+}
+
+// expiresAt is a score of sessions and memberships which are refreshed now
+func (r *redisBroker) expiresAt() float64 {
+	return float64(time.Now().Add(r.ttl).UnixMilli())
+}
+
+// now is a minimal score of sessions which are still online
+func now() string {
+	return strconv.FormatInt(time.Now().UnixMilli(), 10)
+}
+
+func (r *redisBroker) AddSession(ctx context.Context, nickname, sessionID string) (bool, error) {
+	key := presenceKeyPrefix + nickname
+	expiresAt := r.expiresAt()
+	var count *redis.IntCmd
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRemRangeByScore(ctx, key, "-inf", now())
+		pipe.ZAdd(ctx, key, &redis.Z{Score: expiresAt, Member: sessionID})
+		pipe.Expire(ctx, key, r.ttl)
+		pipe.ZAdd(ctx, onlineUsersKey, &redis.Z{Score: expiresAt, Member: nickname})
+		count = pipe.ZCard(ctx, key)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	r.mu.Lock()
+	r.sessions[sessionID] = nickname
+	r.mu.Unlock()
+
+	first := count.Val() == 1
+	if first {
+		err = r.setPresence(ctx, nickname, protocol.StatusOnline)
+	} else {
+		err = r.setPresence(ctx, nickname, "")
+	}
+	return first, err
+}
+
+// setPresence changes status of the user and updates last seen time, empty
+// status is left unchanged
+func (r *redisBroker) setPresence(ctx context.Context, nickname, status string) error {
+	values := []any{"last_seen", time.Now().UTC().Format(time.RFC3339Nano)}
+	if status != "" {
+		values = append(values, "status", status)
+	}
+	return r.client.HSet(ctx, userPresenceKeyPrefix+nickname, values...).Err()
+}
+
+func (r *redisBroker) RemoveSession(ctx context.Context, nickname, sessionID string) (bool, error) {
+	r.mu.Lock()
+	delete(r.sessions, sessionID)
+	r.mu.Unlock()
+
+	key := presenceKeyPrefix + nickname
+	var removed, count *redis.IntCmd
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		removed = pipe.ZRem(ctx, key, sessionID)
+		pipe.ZRemRangeByScore(ctx, key, "-inf", now())
+		count = pipe.ZCard(ctx, key)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	last := removed.Val() == 1 && count.Val() == 0
+	if last {
+		if err := r.client.ZRem(ctx, onlineUsersKey, nickname).Err(); err != nil {
+			return last, err
+		}
+		return last, r.setPresence(ctx, nickname, protocol.StatusOffline)
+	}
+	return last, r.setPresence(ctx, nickname, "")
+}
+
+func (r *redisBroker) IsOnline(ctx context.Context, nickname string) (bool, error) {
+	count, err := r.client.ZCount(ctx, presenceKeyPrefix+nickname, now(), "+inf").Result()
+	return count != 0, err
+}
+
+func (r *redisBroker) SetStatus(ctx context.Context, nickname, status string) error {
+	return r.setPresence(ctx, nickname, status)
+}
+
+func (r *redisBroker) Presence(ctx context.Context, nicknames ...string) ([]protocol.Presence, error) {
+	stored := make([]*redis.StringStringMapCmd, len(nicknames))
+	sessions := make([]*redis.IntCmd, len(nicknames))
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, nickname := range nicknames {
+			stored[i] = pipe.HGetAll(ctx, userPresenceKeyPrefix+nickname)
+			sessions[i] = pipe.ZCount(ctx, presenceKeyPrefix+nickname, now(), "+inf")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	presence := make([]protocol.Presence, 0, len(nicknames))
+	for i, nickname := range nicknames {
+		fields := stored[i].Val()
+		p := protocol.Presence{
+			Nickname: nickname,
+			Status:   fields["status"],
+		}
+		p.LastSeen, _ = time.Parse(time.RFC3339Nano, fields["last_seen"])
+		if sessions[i].Val() == 0 || p.Status == "" {
+			p.Status = protocol.StatusOffline
+		}
+		presence = append(presence, p)
+	}
+	return presence, nil
+}
+
+func (r *redisBroker) OnlineUsers(ctx context.Context) ([]string, error) {
+	nicknames, err := r.client.ZRangeByScore(ctx, onlineUsersKey, &redis.ZRangeBy{Min: now(), Max: "+inf"}).Result()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(nicknames)
+	return nicknames, nil
+}
+
+func (r *redisBroker) RoomMembers(ctx context.Context, room string) ([]string, error) {
+	nicknames, err := r.client.ZRangeByScore(ctx, roomKeyPrefix+room, &redis.ZRangeBy{Min: now(), Max: "+inf"}).Result()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(nicknames)
+	return nicknames, nil
+}
+
+// JoinRoom drops expired members first, so user whose instance has crashed
+// joins the room again as a new member
+func (r *redisBroker) JoinRoom(ctx context.Context, room, nickname string) (bool, error) {
+	roomKey, userKey := roomKeyPrefix+room, userRoomsKeyPrefix+nickname
+	expiresAt := r.expiresAt()
+	var added *redis.IntCmd
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRemRangeByScore(ctx, roomKey, "-inf", now())
+		added = pipe.ZAdd(ctx, roomKey, &redis.Z{Score: expiresAt, Member: nickname})
+		pipe.Expire(ctx, roomKey, r.ttl)
+		pipe.ZRemRangeByScore(ctx, userKey, "-inf", now())
+		pipe.ZAdd(ctx, userKey, &redis.Z{Score: expiresAt, Member: room})
+		pipe.Expire(ctx, userKey, r.ttl)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return added.Val() == 1, nil
+}
+
+func (r *redisBroker) LeaveRoom(ctx context.Context, room, nickname string) (bool, error) {
+	roomKey, userKey := roomKeyPrefix+room, userRoomsKeyPrefix+nickname
+	var removed *redis.IntCmd
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRemRangeByScore(ctx, roomKey, "-inf", now())
+		removed = pipe.ZRem(ctx, roomKey, nickname)
+		pipe.ZRem(ctx, userKey, room)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return removed.Val() == 1, nil
+}
+
+func (r *redisBroker) UserRooms(ctx context.Context, nickname string) ([]string, error) {
+	return r.client.ZRangeByScore(ctx, userRoomsKeyPrefix+nickname, &redis.ZRangeBy{Min: now(), Max: "+inf"}).Result()
+}
+
+// Rooms finds rooms by their keys, redis deletes keys of empty sets and of
+// rooms which members weren't refreshed, so there is no separate list of
+// rooms to keep consistent
+func (r *redisBroker) Rooms(ctx context.Context) (map[string]int, error) {
+	var keys []string
+	iter := r.client.Scan(ctx, 0, roomKeyPrefix+"*", 0).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	counts := make([]*redis.IntCmd, len(keys))
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			counts[i] = pipe.ZCount(ctx, key, now(), "+inf")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rooms := make(map[string]int, len(keys))
+	for i, key := range keys {
+		if n := counts[i].Val(); n != 0 {
+			rooms[key[len(roomKeyPrefix):]] = int(n)
+		}
+	}
+	return rooms, nil
+}
+
+func (r *redisBroker) ClaimMessage(ctx context.Context, sender, clientID string) (bool, *protocol.Frame, error) {
+	key := claimKeyPrefix + claimKey(sender, clientID)
+	claimed, err := r.client.SetNX(ctx, key, "", claimTTL).Result()
+	if err != nil || claimed {
+		return claimed, nil, err
+	}
+
+	data, err := r.client.Get(ctx, key).Result()
+	if err == redis.Nil {
+		// claim has just expired or was released, it is safer to let the
+		// client retry than to handle the message twice
+		return false, nil, nil
+	} else if err != nil || data == "" {
+		return false, nil, err
+	}
+	var ack protocol.Frame
+	if err := json.Unmarshal([]byte(data), &ack); err != nil {
+		return false, nil, err
+	}
+	return false, &ack, nil
+}
+
+func (r *redisBroker) AckMessage(ctx context.Context, sender, clientID string, ack protocol.Frame) error {
+	data, err := json.Marshal(ack)
+	if err != nil {
+		return err
+	}
+	return r.client.Set(ctx, claimKeyPrefix+claimKey(sender, clientID), data, ackTTL).Err()
+}
+
+func (r *redisBroker) ReleaseMessage(ctx context.Context, sender, clientID string) error {
+	return r.client.Del(ctx, claimKeyPrefix+claimKey(sender, clientID)).Err()
+}
+
+func (r *redisBroker) SaveRooms(ctx context.Context, nickname string, rooms []string) error {
+	data, err := json.Marshal(rooms)
+	if err != nil {
+		return err
+	}
+	return r.client.Set(ctx, savedRoomsKeyPrefix+nickname, data, resumeTTL).Err()
+}
+
+func (r *redisBroker) TakeRooms(ctx context.Context, nickname string) ([]string, error) {
+	data, err := r.client.GetDel(ctx, savedRoomsKeyPrefix+nickname).Result()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var rooms []string
+	if err := json.Unmarshal([]byte(data), &rooms); err != nil {
+		return nil, err
+	}
+	return rooms, nil
+}
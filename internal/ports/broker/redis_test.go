@@ -0,0 +1,77 @@
+package broker
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+// testRedisAddrEnv is an address of redis for tests of redis broker, they
+// are skipped if it isn't set
+const testRedisAddrEnv = "CONSOLE_CHAT_TEST_REDIS_ADDR"
+
+// newTestRedis creates redis broker with short presence lifetime
+func newTestRedis(t *testing.T, ttl time.Duration) *redisBroker {
+	addr := os.Getenv(testRedisAddrEnv)
+	if addr == "" {
+		t.Skip(testRedisAddrEnv + " isn't set")
+	}
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+	b := NewRedis(client).(*redisBroker)
+	b.ttl = ttl
+	return b
+}
+
+func TestRedisRoomsExpire(t *testing.T) {
+	ttl := time.Second
+	alive, crashed := newTestRedis(t, ttl), newTestRedis(t, ttl)
+	ctx := context.Background()
+	room := "room" + strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	// user01 is on the instance which crashes, user02 is on the alive one
+	_, err := crashed.AddSession(ctx, "user01", room+"-session1")
+	assert.NoError(t, err)
+	joined, err := crashed.JoinRoom(ctx, room, "user01")
+	assert.NoError(t, err)
+	assert.True(t, joined)
+	_, err = alive.AddSession(ctx, "user02", room+"-session2")
+	assert.NoError(t, err)
+	_, err = alive.JoinRoom(ctx, room, "user02")
+	assert.NoError(t, err)
+
+	// only the alive instance refreshes its users
+	for i := 0; i < 6; i++ {
+		time.Sleep(ttl / 3)
+		alive.refreshSessions(ctx)
+	}
+
+	members, err := alive.RoomMembers(ctx, room)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"user02"}, members)
+	counts, err := alive.Rooms(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, counts[room])
+	rooms, err := alive.UserRooms(ctx, "user01")
+	assert.NoError(t, err)
+	assert.NotContains(t, rooms, room)
+
+	// user of the crashed instance joins the room again as a new member
+	joined, err = alive.JoinRoom(ctx, room, "user01")
+	assert.NoError(t, err)
+	assert.True(t, joined)
+
+	for _, nickname := range []string{"user01", "user02"} {
+		left, err := alive.LeaveRoom(ctx, room, nickname)
+		assert.NoError(t, err)
+		assert.True(t, left)
+	}
+	_, _ = alive.RemoveSession(ctx, "user02", room+"-session2")
+}
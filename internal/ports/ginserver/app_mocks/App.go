@@ -5,6 +5,7 @@ package mocks
 import context "context"
 import mock "github.com/stretchr/testify/mock"
 import model "console-chat/internal/model"
+import time "time"
 
 // App is an autogenerated mock type for the App type
 type App struct {
@@ -32,20 +33,299 @@ func (_m *App) RegisterUser(ctx context.Context, nickname string, password strin
 	return r0, r1
 }
 
-// SignInUser provides a mock function with given fields: ctx, nickname, password
-func (_m *App) SignInUser(ctx context.Context, nickname string, password string) (model.User, error) {
-	ret := _m.Called(ctx, nickname, password)
+// SignInUser provides a mock function with given fields: ctx, nickname, password, ip
+func (_m *App) SignInUser(ctx context.Context, nickname string, password string, ip string) (model.User, error) {
+	ret := _m.Called(ctx, nickname, password, ip)
 
 	var r0 model.User
-	if rf, ok := ret.Get(0).(func(context.Context, string, string) model.User); ok {
-		r0 = rf(ctx, nickname, password)
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, string) model.User); ok {
+		r0 = rf(ctx, nickname, password, ip)
 	} else {
 		r0 = ret.Get(0).(model.User)
 	}
 
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, string) error); ok {
+		r1 = rf(ctx, nickname, password, ip)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// UnlockSignIn provides a mock function with given fields: ctx, nickname, ip
+func (_m *App) UnlockSignIn(ctx context.Context, nickname string, ip string) error {
+	ret := _m.Called(ctx, nickname, ip)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) error); ok {
+		r0 = rf(ctx, nickname, ip)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ModerateMessage provides a mock function with given fields: ctx, sender, text
+func (_m *App) ModerateMessage(ctx context.Context, sender string, text string) (string, error) {
+	ret := _m.Called(ctx, sender, text)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) string); ok {
+		r0 = rf(ctx, sender, text)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
 	var r1 error
 	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
-		r1 = rf(ctx, nickname, password)
+		r1 = rf(ctx, sender, text)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// SaveMessage provides a mock function with given fields: ctx, msg
+func (_m *App) SaveMessage(ctx context.Context, msg model.Message) (model.Message, error) {
+	ret := _m.Called(ctx, msg)
+
+	var r0 model.Message
+	if rf, ok := ret.Get(0).(func(context.Context, model.Message) model.Message); ok {
+		r0 = rf(ctx, msg)
+	} else {
+		r0 = ret.Get(0).(model.Message)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, model.Message) error); ok {
+		r1 = rf(ctx, msg)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetHistory provides a mock function with given fields: ctx, room, beforeID, limit
+func (_m *App) GetHistory(ctx context.Context, room string, beforeID int64, limit int) ([]model.Message, error) {
+	ret := _m.Called(ctx, room, beforeID, limit)
+
+	var r0 []model.Message
+	if rf, ok := ret.Get(0).(func(context.Context, string, int64, int) []model.Message); ok {
+		r0 = rf(ctx, room, beforeID, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.Message)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, int64, int) error); ok {
+		r1 = rf(ctx, room, beforeID, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetMissed provides a mock function with given fields: ctx, room, afterSeq, limit
+func (_m *App) GetMissed(ctx context.Context, room string, afterSeq int64, limit int) ([]model.Message, error) {
+	ret := _m.Called(ctx, room, afterSeq, limit)
+
+	var r0 []model.Message
+	if rf, ok := ret.Get(0).(func(context.Context, string, int64, int) []model.Message); ok {
+		r0 = rf(ctx, room, afterSeq, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.Message)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, int64, int) error); ok {
+		r1 = rf(ctx, room, afterSeq, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetUser provides a mock function with given fields: ctx, nickname
+func (_m *App) GetUser(ctx context.Context, nickname string) (model.User, error) {
+	ret := _m.Called(ctx, nickname)
+
+	var r0 model.User
+	if rf, ok := ret.Get(0).(func(context.Context, string) model.User); ok {
+		r0 = rf(ctx, nickname)
+	} else {
+		r0 = ret.Get(0).(model.User)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, nickname)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// QueueMessage provides a mock function with given fields: ctx, recipient, msg
+func (_m *App) QueueMessage(ctx context.Context, recipient string, msg model.Message) (model.Delivery, error) {
+	ret := _m.Called(ctx, recipient, msg)
+
+	var r0 model.Delivery
+	if rf, ok := ret.Get(0).(func(context.Context, string, model.Message) model.Delivery); ok {
+		r0 = rf(ctx, recipient, msg)
+	} else {
+		r0 = ret.Get(0).(model.Delivery)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, model.Message) error); ok {
+		r1 = rf(ctx, recipient, msg)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetUndelivered provides a mock function with given fields: ctx, recipient
+func (_m *App) GetUndelivered(ctx context.Context, recipient string) ([]model.Delivery, error) {
+	ret := _m.Called(ctx, recipient)
+
+	var r0 []model.Delivery
+	if rf, ok := ret.Get(0).(func(context.Context, string) []model.Delivery); ok {
+		r0 = rf(ctx, recipient)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.Delivery)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, recipient)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MarkDelivered provides a mock function with given fields: ctx, recipient, ids
+func (_m *App) MarkDelivered(ctx context.Context, recipient string, ids []int64) error {
+	ret := _m.Called(ctx, recipient, ids)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, []int64) error); ok {
+		r0 = rf(ctx, recipient, ids)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// IssueRefreshToken provides a mock function with given fields: ctx, nickname
+func (_m *App) IssueRefreshToken(ctx context.Context, nickname string) (model.RefreshToken, error) {
+	ret := _m.Called(ctx, nickname)
+
+	var r0 model.RefreshToken
+	if rf, ok := ret.Get(0).(func(context.Context, string) model.RefreshToken); ok {
+		r0 = rf(ctx, nickname)
+	} else {
+		r0 = ret.Get(0).(model.RefreshToken)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, nickname)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RotateRefreshToken provides a mock function with given fields: ctx, token
+func (_m *App) RotateRefreshToken(ctx context.Context, token string) (model.RefreshToken, error) {
+	ret := _m.Called(ctx, token)
+
+	var r0 model.RefreshToken
+	if rf, ok := ret.Get(0).(func(context.Context, string) model.RefreshToken); ok {
+		r0 = rf(ctx, token)
+	} else {
+		r0 = ret.Get(0).(model.RefreshToken)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, token)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RevokeSession provides a mock function with given fields: ctx, family
+func (_m *App) RevokeSession(ctx context.Context, family string) error {
+	ret := _m.Called(ctx, family)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, family)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RevokeToken provides a mock function with given fields: ctx, id, expiresAt
+func (_m *App) RevokeToken(ctx context.Context, id string, expiresAt time.Time) error {
+	ret := _m.Called(ctx, id, expiresAt)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Time) error); ok {
+		r0 = rf(ctx, id, expiresAt)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// IsTokenRevoked provides a mock function with given fields: ctx, ids
+func (_m *App) IsTokenRevoked(ctx context.Context, ids ...string) (bool, error) {
+	_va := make([]interface{}, len(ids))
+	for _i := range ids {
+		_va[_i] = ids[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, ctx)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func(context.Context, ...string) bool); ok {
+		r0 = rf(ctx, ids...)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, ...string) error); ok {
+		r1 = rf(ctx, ids...)
 	} else {
 		r1 = ret.Error(1)
 	}
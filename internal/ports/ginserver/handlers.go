@@ -3,40 +3,239 @@ package ginserver
 import (
 	"console-chat/internal/app"
 	"console-chat/internal/model"
+	"console-chat/internal/ports/token"
+	"console-chat/internal/ports/wsserver"
+	"crypto/subtle"
+	"errors"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt"
 )
 
-func getUser(a app.App, tokenKey []byte) gin.HandlerFunc {
+// issueTokens signs access token of the user and responds with it and the
+// refresh token
+func issueTokens(c *gin.Context, keys *token.Keyring, accessTTL time.Duration, refresh model.RefreshToken) {
+	expiresAt := time.Now().Add(accessTTL)
+	claims := jwt.MapClaims{
+		"nickname": refresh.Nickname,
+		"exp":      expiresAt.Unix(),
+		"sid":      refresh.Family,
+	}
+	if tokenInStr, err := keys.Sign(claims); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse(err))
+	} else {
+		c.JSON(http.StatusOK, getUserResponse(tokenInStr, expiresAt, refresh))
+	}
+}
+
+// signIn checks password of the user and responds with new tokens
+func signIn(c *gin.Context, a app.App, keys *token.Keyring, accessTTL time.Duration, nickname, password string) {
+	usr, signInErr := a.SignInUser(c, nickname, password, c.ClientIP())
+	var locked *model.SignInLockedError
+	if errors.As(signInErr, &locked) {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(locked.RetryAfter.Seconds()))))
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, ErrorResponse(signInErr))
+		return
+	}
+	switch signInErr {
+	case model.UserNotFound:
+		c.AbortWithStatusJSON(http.StatusNotFound, ErrorResponse(signInErr))
+	case model.UserWrongPassword:
+		c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse(signInErr))
+	case nil:
+		if refresh, err := a.IssueRefreshToken(c, usr.Nickname); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse(err))
+		} else {
+			issueTokens(c, keys, accessTTL, refresh)
+		}
+	default:
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse(signInErr))
+	}
+}
+
+func postSession(a app.App, keys *token.Keyring, accessTTL time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var reqBody postSessionRequest
+		if err := c.BindJSON(&reqBody); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse(err))
+			return
+		}
+		signIn(c, a, keys, accessTTL, reqBody.Nickname, reqBody.Password)
+	}
+}
+
+// getUser is deprecated sign in with password in body of GET request, use
+// postSession instead
+func getUser(a app.App, keys *token.Keyring, accessTTL time.Duration) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		nickname := c.Param("user_nickname")
 		var reqBody getUserRequest
 		if err := c.BindJSON(&reqBody); err != nil {
 			c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse(err))
+			return
 		}
+		signIn(c, a, keys, accessTTL, c.Param("user_nickname"), reqBody.Password)
+	}
+}
 
-		usr, getErr := a.SignInUser(c, nickname, reqBody.Password)
-		switch getErr {
-		case model.UserNotFound:
-			c.AbortWithStatusJSON(http.StatusNotFound, ErrorResponse(getErr))
-		case model.UserWrongPassword:
-			c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse(getErr))
+// deprecated marks responses of the route with Deprecation header and link to
+// the route which replaces it
+func deprecated(successor string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Deprecation", "true")
+		c.Header("Link", "<"+successor+`>; rel="successor-version"`)
+		c.Next()
+	}
+}
+
+func postRefresh(a app.App, ws wsserver.WsServer, keys *token.Keyring, accessTTL time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var reqBody postRefreshRequest
+		if err := c.BindJSON(&reqBody); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse(err))
+			return
+		}
+
+		refresh, refreshErr := a.RotateRefreshToken(c, reqBody.RefreshToken)
+		switch refreshErr {
+		case model.RefreshTokenReused:
+			// refresh token was stolen, so access tokens and chat sessions of
+			// its sign in are revoked the same way as on logout
+			if refresh.Family != "" {
+				if err := a.RevokeToken(c, refresh.Family, time.Now().Add(accessTTL)); err != nil {
+					c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse(err))
+					return
+				}
+				ws.RevokeTokens(refresh.Family)
+			}
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse(refreshErr))
+		case model.RefreshTokenInvalid:
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse(refreshErr))
 		case nil:
-			token := jwt.New(jwt.SigningMethodHS256)
-			claims := token.Claims.(jwt.MapClaims)
-			claims["nickname"] = usr.Nickname
-			claims["exp"] = time.Now().Add(24 * time.Hour).Unix()
-			if tokenInStr, err := token.SignedString(tokenKey); err != nil {
+			issueTokens(c, keys, accessTTL, refresh)
+		default:
+			c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse(refreshErr))
+		}
+	}
+}
+
+var errNoBearerToken = errors.New("access token is required in authorization header")
+
+// bearerToken returns access token from authorization header
+func bearerToken(c *gin.Context) (string, error) {
+	tokenString, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !found || tokenString == "" {
+		return "", errNoBearerToken
+	}
+	return tokenString, nil
+}
+
+// postLogout revokes access token, all tokens of its sign in and closes chat
+// sessions opened with them
+func postLogout(a app.App, ws wsserver.WsServer, keys *token.Keyring, accessTTL time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenString, err := bearerToken(c)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse(err))
+			return
+		}
+		claims, err := keys.Parse(tokenString)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse(err))
+			return
+		}
+		tokenID, _ := claims["jti"].(string)
+		family, _ := claims["sid"].(string)
+		var expiresAt time.Time
+		if exp, ok := claims["exp"].(float64); ok {
+			expiresAt = time.Unix(int64(exp), 0)
+		}
+
+		// other access tokens of the sign in expire not later than accessTTL
+		// since now, so family is kept in the revocation list for this time
+		if err := a.RevokeToken(c, tokenID, expiresAt); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse(err))
+			return
+		}
+		if family != "" {
+			if err := a.RevokeSession(c, family); err != nil {
 				c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse(err))
-			} else {
-				c.JSON(http.StatusOK, getUserResponse(tokenInStr))
+				return
+			}
+			if err := a.RevokeToken(c, family, time.Now().Add(accessTTL)); err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse(err))
+				return
 			}
-		default:
-			c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse(getErr))
 		}
+		ws.RevokeTokens(tokenID, family)
+		c.JSON(http.StatusOK, emptyResponse())
+	}
+}
+
+var errTokenRevoked = errors.New("access token was revoked")
+
+// authorized lets through only requests with valid access token which wasn't
+// revoked in authorization header
+func authorized(a app.App, keys *token.Keyring) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenString, err := bearerToken(c)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse(err))
+			return
+		}
+		claims, err := keys.Parse(tokenString)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse(err))
+			return
+		}
+		tokenID, _ := claims["jti"].(string)
+		family, _ := claims["sid"].(string)
+		if revoked, err := a.IsTokenRevoked(c, tokenID, family); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse(err))
+			return
+		} else if revoked {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse(errTokenRevoked))
+			return
+		}
+		c.Next()
+	}
+}
+
+var errNotAdmin = errors.New("admin token is required")
+
+// adminOnly lets through only requests with admin token in authorization
+// header
+func adminOnly(adminToken string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenString, err := bearerToken(c)
+		if err != nil || subtle.ConstantTimeCompare([]byte(tokenString), []byte(adminToken)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse(errNotAdmin))
+			return
+		}
+		c.Next()
+	}
+}
+
+func deleteSignInLock(a app.App) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var reqBody deleteSignInLockRequest
+		if err := c.BindJSON(&reqBody); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse(err))
+			return
+		}
+		if reqBody.Nickname == "" && reqBody.IP == "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse(errors.New("nickname or ip is required")))
+			return
+		}
+		if err := a.UnlockSignIn(c, reqBody.Nickname, reqBody.IP); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse(err))
+			return
+		}
+		c.JSON(http.StatusOK, emptyResponse())
 	}
 }
 
@@ -62,3 +261,28 @@ func postUser(a app.App) gin.HandlerFunc {
 		}
 	}
 }
+
+// getOnline lists users which are online in the chat, only members of the
+// room if it is given in query
+func getOnline(ws wsserver.WsServer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		online, err := ws.OnlineUsers(c, c.Query("room"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse(err))
+			return
+		}
+		c.JSON(http.StatusOK, onlineResponse(online))
+	}
+}
+
+func getMetrics(ws wsserver.WsServer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, metricsResponse(ws.QueueStats()))
+	}
+}
+
+func getJWKS(keys *token.Keyring) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, keys.JWKS())
+	}
+}
@@ -3,10 +3,17 @@ package ginserver
 import (
 	"bytes"
 	"console-chat/internal/model"
+	"console-chat/internal/ports/token"
+	"console-chat/internal/ports/wsserver"
+	"console-chat/internal/protocol"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
 
 	"github.com/golang-jwt/jwt"
 	"github.com/stretchr/testify/assert"
@@ -121,7 +128,14 @@ func (s *ginServerTestSuite) TestGetUser() {
 		if m.usr.Nickname != "" {
 			m.usr.HashedPassword = getHash(m.password)
 		}
-		s.app.On("SignInUser", mock.Anything, m.nickname, m.password).Return(m.usr, m.err).Once()
+		s.app.On("SignInUser", mock.Anything, m.nickname, m.password, mock.Anything).Return(m.usr, m.err).Once()
+		if m.err == nil {
+			s.app.On("IssueRefreshToken", mock.Anything, m.nickname).Return(model.RefreshToken{
+				Token:     "refresh-" + m.nickname,
+				Nickname:  m.nickname,
+				ExpiresAt: time.Now().Add(time.Hour),
+			}, nil).Once()
+		}
 	}
 
 	for _, test := range tests {
@@ -130,6 +144,8 @@ func (s *ginServerTestSuite) TestGetUser() {
 		assert.NoError(s.T(), err)
 
 		if test.expectGetToken {
+			assert.Equal(s.T(), "refresh-"+test.expectedNickname, resp.Data.RefreshToken)
+			assert.True(s.T(), resp.Data.ExpiresAt.After(time.Now()))
 			tokenStr := resp.Data.TokenString
 			token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (any, error) {
 				if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
@@ -138,6 +154,7 @@ func (s *ginServerTestSuite) TestGetUser() {
 				return []byte("abcd"), nil
 			})
 			assert.NoError(s.T(), err)
+			assert.Equal(s.T(), "test", token.Header["kid"])
 
 			if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
 				assert.Equal(s.T(), test.expectedNickname, claims["nickname"].(string))
@@ -148,8 +165,94 @@ func (s *ginServerTestSuite) TestGetUser() {
 	}
 }
 
+func (s *ginServerTestSuite) postSession(body map[string]any) (tokenData, int, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return tokenData{}, 0, err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.baseURL+"/console-chat/sessions", bytes.NewReader(data))
+	if err != nil {
+		return tokenData{}, 0, err
+	}
+	req.Header.Add("Content-Type", "application/json")
+	var resp tokenData
+	code, err := s.getResponse(req, &resp)
+	if err != nil {
+		return tokenData{}, 0, err
+	}
+	return resp, code, nil
+}
+
+func (s *ginServerTestSuite) TestPostSession() {
+	s.app.On("SignInUser", mock.Anything, "session01", "qwerty_123", mock.Anything).Return(model.User{Nickname: "session01"}, nil).Once()
+	s.app.On("IssueRefreshToken", mock.Anything, "session01").Return(model.RefreshToken{
+		Token:     "refresh-session01",
+		Family:    "family",
+		Nickname:  "session01",
+		ExpiresAt: time.Now().Add(time.Hour),
+	}, nil).Once()
+	s.app.On("SignInUser", mock.Anything, "session02", "qwerty_123", mock.Anything).Return(model.User{}, model.UserWrongPassword).Once()
+	s.app.On("SignInUser", mock.Anything, "session03", "qwerty_123", mock.Anything).Return(model.User{}, model.UserNotFound).Once()
+
+	resp, code, err := s.postSession(map[string]any{
+		"nickname": "session01",
+		"password": "qwerty_123",
+	})
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), http.StatusOK, code)
+	assert.Equal(s.T(), "Bearer", resp.Data.TokenType)
+	assert.InDelta(s.T(), 60, resp.Data.ExpiresIn, 1)
+	assert.WithinDuration(s.T(), time.Now().Add(time.Minute), resp.Data.ExpiresAt, 2*time.Second)
+	assert.Equal(s.T(), "refresh-session01", resp.Data.RefreshToken)
+	claims, err := s.keys.Parse(resp.Data.TokenString)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), "session01", claims["nickname"])
+	assert.Equal(s.T(), "family", claims["sid"])
+
+	_, code, err = s.postSession(map[string]any{
+		"nickname": "session02",
+		"password": "qwerty_123",
+	})
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), http.StatusUnauthorized, code)
+
+	_, code, err = s.postSession(map[string]any{
+		"nickname": "session03",
+		"password": "qwerty_123",
+	})
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), http.StatusNotFound, code)
+
+	req, err := http.NewRequest(http.MethodPost, s.baseURL+"/console-chat/sessions", bytes.NewReader([]byte("password")))
+	assert.NoError(s.T(), err)
+	var errResp map[string]any
+	code, err = s.getResponse(req, &errResp)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), http.StatusBadRequest, code)
+}
+
+func (s *ginServerTestSuite) TestLegacySignIn() {
+	s.app.On("SignInUser", mock.Anything, "legacy01", "qwerty_123", mock.Anything).Return(model.User{}, model.UserWrongPassword).Once()
+
+	req, err := http.NewRequest(http.MethodGet, s.baseURL+"/console-chat/users/legacy01", bytes.NewReader([]byte(`{"password":"qwerty_123"}`)))
+	assert.NoError(s.T(), err)
+	resp, err := s.client.Do(req)
+	assert.NoError(s.T(), err)
+	_ = resp.Body.Close()
+	assert.Equal(s.T(), http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(s.T(), "true", resp.Header.Get("Deprecation"))
+	assert.Equal(s.T(), `</console-chat/sessions>; rel="successor-version"`, resp.Header.Get("Link"))
+
+	// route is removed when legacy sign in is turned off
+	ws := wsserver.New(s.keys, s.app, wsserver.Config{})
+	server := NewHTTPServer("localhost", 8081, ws, s.app, s.keys, Config{})
+	rec := httptest.NewRecorder()
+	server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/console-chat/users/legacy01", nil))
+	assert.Equal(s.T(), http.StatusNotFound, rec.Code)
+}
+
 type userData struct {
-	UserResp userResponse `json:"data"`
+	UserResp protocol.User `json:"data"`
 }
 
 func (s *ginServerTestSuite) postUser(body map[string]any) (userData, int, error) {
@@ -190,7 +293,8 @@ func (s *ginServerTestSuite) TestPostUser() {
 			password: "qwerty_123",
 			usr: model.User{
 				Nickname:       "papey08",
-				HashedPassword: "",
+				HashedPassword: "$argon2id$v=19$m=65536,t=1,p=4$c2FsdA$aGFzaA",
+				CreatedAt:      time.Date(2023, 8, 1, 12, 0, 0, 0, time.UTC),
 			},
 			err: nil,
 		},
@@ -239,8 +343,289 @@ func (s *ginServerTestSuite) TestPostUser() {
 	}
 
 	for _, test := range tests {
-		_, code, err := s.postUser(test.givenBody)
+		resp, code, err := s.postUser(test.givenBody)
 		assert.Equal(s.T(), test.expectedStatusCode, code)
 		assert.NoError(s.T(), err)
+		if code == http.StatusOK {
+			assert.Equal(s.T(), protocol.User{
+				Nickname:  "papey08",
+				CreatedAt: time.Date(2023, 8, 1, 12, 0, 0, 0, time.UTC),
+			}, resp.UserResp)
+		}
+	}
+}
+
+type metricsData struct {
+	Data metrics `json:"data"`
+}
+
+func (s *ginServerTestSuite) TestGetMetrics() {
+	// metrics are available only to admin
+	req, err := http.NewRequest(http.MethodGet, s.baseURL+"/console-chat/admin/metrics", nil)
+	assert.NoError(s.T(), err)
+	code, err := s.getResponse(req, nil)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), http.StatusUnauthorized, code)
+
+	req, err = http.NewRequest(http.MethodGet, s.baseURL+"/console-chat/admin/metrics", nil)
+	assert.NoError(s.T(), err)
+	req.Header.Add("Authorization", "Bearer "+testAdminToken)
+
+	var resp metricsData
+	code, err = s.getResponse(req, &resp)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), http.StatusOK, code)
+	assert.Equal(s.T(), 0, resp.Data.WsQueues.Sessions)
+	assert.Equal(s.T(), 256, resp.Data.WsQueues.QueueCapacity)
+}
+
+func (s *ginServerTestSuite) TestGetJWKS() {
+	req, err := http.NewRequest(http.MethodGet, s.baseURL+"/console-chat/.well-known/jwks.json", nil)
+	assert.NoError(s.T(), err)
+
+	var resp token.JWKS
+	code, err := s.getResponse(req, &resp)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), http.StatusOK, code)
+
+	// hmac secret is never published
+	assert.Len(s.T(), resp.Keys, 1)
+	assert.Equal(s.T(), "ed", resp.Keys[0].Kid)
+	assert.Equal(s.T(), token.EdDSA, resp.Keys[0].Alg)
+	assert.NotEmpty(s.T(), resp.Keys[0].X)
+}
+
+func (s *ginServerTestSuite) postRefresh(refreshToken string) (tokenData, int, error) {
+	data, err := json.Marshal(map[string]any{
+		"refresh_token": refreshToken,
+	})
+	if err != nil {
+		return tokenData{}, 0, err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.baseURL+"/console-chat/tokens/refresh", bytes.NewReader(data))
+	if err != nil {
+		return tokenData{}, 0, err
+	}
+	req.Header.Add("Content-Type", "application/json")
+	var resp tokenData
+	code, err := s.getResponse(req, &resp)
+	if err != nil {
+		return tokenData{}, 0, err
+	}
+	return resp, code, nil
+}
+
+func (s *ginServerTestSuite) TestPostRefresh() {
+	s.app.On("RotateRefreshToken", mock.Anything, "valid").Return(model.RefreshToken{
+		Token:     "rotated",
+		Nickname:  "papey08",
+		ExpiresAt: time.Now().Add(time.Hour),
+	}, nil).Once()
+	s.app.On("RotateRefreshToken", mock.Anything, "used").Return(model.RefreshToken{}, model.RefreshTokenReused).Once()
+	s.app.On("RotateRefreshToken", mock.Anything, "unknown").Return(model.RefreshToken{}, model.RefreshTokenInvalid).Once()
+
+	resp, code, err := s.postRefresh("valid")
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), http.StatusOK, code)
+	assert.Equal(s.T(), "rotated", resp.Data.RefreshToken)
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(resp.Data.TokenString, claims, func(*jwt.Token) (any, error) {
+		return []byte("abcd"), nil
+	})
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), "papey08", claims["nickname"])
+
+	// access token lives as long as configured
+	assert.WithinDuration(s.T(), time.Now().Add(time.Minute), resp.Data.ExpiresAt, 2*time.Second)
+
+	for _, refreshToken := range []string{"used", "unknown"} {
+		_, code, err := s.postRefresh(refreshToken)
+		assert.NoError(s.T(), err, refreshToken)
+		assert.Equal(s.T(), http.StatusUnauthorized, code, refreshToken)
+	}
+}
+
+func (s *ginServerTestSuite) TestPostRefreshReused() {
+	accessToken, err := s.keys.Sign(jwt.MapClaims{
+		"nickname": "papey08",
+		"exp":      time.Now().Add(time.Minute).Unix(),
+		"jti":      "stolen-token-id",
+		"sid":      "stolen-family",
+	})
+	assert.NoError(s.T(), err)
+
+	var revoked sync.Map
+	s.app.On("RotateRefreshToken", mock.Anything, "stolen").Return(model.RefreshToken{
+		Nickname: "papey08",
+		Family:   "stolen-family",
+	}, model.RefreshTokenReused).Once()
+	s.app.On("RevokeToken", mock.Anything, "stolen-family", mock.AnythingOfType("time.Time")).Run(func(args mock.Arguments) {
+		revoked.Store(args.String(1), struct{}{})
+	}).Return(nil).Once()
+	s.app.On("IsTokenRevoked", mock.Anything, "stolen-token-id", "stolen-family").Return(func(_ context.Context, ids ...string) bool {
+		for _, id := range ids {
+			if _, ok := revoked.Load(id); ok {
+				return true
+			}
+		}
+		return false
+	}, nil).Once()
+
+	_, code, err := s.postRefresh("stolen")
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), http.StatusUnauthorized, code)
+
+	// access token of the family is rejected after reuse of its refresh token
+	req, err := http.NewRequest(http.MethodGet, s.baseURL+"/console-chat/online", nil)
+	assert.NoError(s.T(), err)
+	req.Header.Add("Authorization", "Bearer "+accessToken)
+	code, err = s.getResponse(req, nil)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), http.StatusUnauthorized, code)
+}
+
+func (s *ginServerTestSuite) postLogout(accessToken string) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, s.baseURL+"/console-chat/logout", nil)
+	if err != nil {
+		return 0, err
+	}
+	if accessToken != "" {
+		req.Header.Add("Authorization", "Bearer "+accessToken)
+	}
+	var resp map[string]any
+	return s.getResponse(req, &resp)
+}
+
+func (s *ginServerTestSuite) TestLogout() {
+	expiresAt := time.Now().Add(time.Minute).Truncate(time.Second)
+	accessToken, err := s.keys.Sign(jwt.MapClaims{
+		"nickname": "papey08",
+		"exp":      expiresAt.Unix(),
+		"jti":      "token-id",
+		"sid":      "family",
+	})
+	assert.NoError(s.T(), err)
+
+	s.app.On("RevokeToken", mock.Anything, "token-id", expiresAt).Return(nil).Once()
+	s.app.On("RevokeSession", mock.Anything, "family").Return(nil).Once()
+	s.app.On("RevokeToken", mock.Anything, "family", mock.AnythingOfType("time.Time")).Return(nil).Once()
+
+	code, err := s.postLogout(accessToken)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), http.StatusOK, code)
+	s.app.AssertCalled(s.T(), "RevokeSession", mock.Anything, "family")
+
+	// token must be signed by the server
+	forged, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"nickname": "papey08",
+		"jti":      "forged",
+	}).SignedString([]byte("wrong"))
+	assert.NoError(s.T(), err)
+	for _, accessToken := range []string{"", forged} {
+		code, err := s.postLogout(accessToken)
+		assert.NoError(s.T(), err)
+		assert.Equal(s.T(), http.StatusUnauthorized, code)
+	}
+}
+
+func (s *ginServerTestSuite) TestSignInLocked() {
+	s.app.On("SignInUser", mock.Anything, "locked01", "qwerty_123", "127.0.0.1").Return(model.User{}, &model.SignInLockedError{
+		RetryAfter: 1500 * time.Millisecond,
+	}).Once()
+
+	data, err := json.Marshal(map[string]any{
+		"nickname": "locked01",
+		"password": "qwerty_123",
+	})
+	assert.NoError(s.T(), err)
+	req, err := http.NewRequest(http.MethodPost, s.baseURL+"/console-chat/sessions", bytes.NewReader(data))
+	assert.NoError(s.T(), err)
+
+	// client can't pretend to be another IP without trusted proxy
+	req.Header.Add("X-Forwarded-For", "10.0.0.1")
+	resp, err := s.client.Do(req)
+	assert.NoError(s.T(), err)
+	_ = resp.Body.Close()
+	assert.Equal(s.T(), http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(s.T(), "2", resp.Header.Get("Retry-After"))
+}
+
+func (s *ginServerTestSuite) deleteSignInLock(adminToken string, body map[string]any) (int, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequest(http.MethodDelete, s.baseURL+"/console-chat/admin/sign-in-locks", bytes.NewReader(data))
+	if err != nil {
+		return 0, err
 	}
+	req.Header.Add("Authorization", "Bearer "+adminToken)
+	var resp map[string]any
+	return s.getResponse(req, &resp)
+}
+
+func (s *ginServerTestSuite) TestUnlockSignIn() {
+	s.app.On("UnlockSignIn", mock.Anything, "locked01", "10.0.0.1").Return(nil).Once()
+
+	code, err := s.deleteSignInLock(testAdminToken, map[string]any{
+		"nickname": "locked01",
+		"ip":       "10.0.0.1",
+	})
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), http.StatusOK, code)
+
+	code, err = s.deleteSignInLock(testAdminToken, map[string]any{})
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), http.StatusBadRequest, code)
+
+	code, err = s.deleteSignInLock("wrong", map[string]any{
+		"nickname": "locked01",
+	})
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), http.StatusUnauthorized, code)
+	s.app.AssertNumberOfCalls(s.T(), "UnlockSignIn", 1)
+}
+
+func (s *ginServerTestSuite) getOnline(accessToken, room string) (int, map[string]any, error) {
+	req, err := http.NewRequest(http.MethodGet, s.baseURL+"/console-chat/online?room="+room, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	if accessToken != "" {
+		req.Header.Add("Authorization", "Bearer "+accessToken)
+	}
+	var resp map[string]any
+	code, err := s.getResponse(req, &resp)
+	return code, resp, err
+}
+
+func (s *ginServerTestSuite) TestOnline() {
+	accessToken, err := s.keys.Sign(jwt.MapClaims{
+		"nickname": "papey08",
+		"exp":      time.Now().Add(time.Minute).Unix(),
+		"jti":      "online-token",
+		"sid":      "online-family",
+	})
+	assert.NoError(s.T(), err)
+
+	s.app.On("IsTokenRevoked", mock.Anything, "online-token", "online-family").Return(false, nil).Twice()
+	code, resp, err := s.getOnline(accessToken, "")
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), http.StatusOK, code)
+	assert.Equal(s.T(), map[string]any{"users": []any{}}, resp["data"])
+
+	code, resp, err = s.getOnline(accessToken, "general")
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), http.StatusOK, code)
+	assert.Equal(s.T(), map[string]any{"users": []any{}}, resp["data"])
+
+	// list of online users is only for signed in users
+	code, _, err = s.getOnline("", "")
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), http.StatusUnauthorized, code)
+
+	s.app.On("IsTokenRevoked", mock.Anything, "online-token", "online-family").Return(true, nil).Once()
+	code, _, err = s.getOnline(accessToken, "")
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), http.StatusUnauthorized, code)
 }
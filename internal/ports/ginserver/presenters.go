@@ -4,7 +4,21 @@ type getUserRequest struct {
 	Password string `json:"password"`
 }
 
+type postSessionRequest struct {
+	Nickname string `json:"nickname"`
+	Password string `json:"password"`
+}
+
 type postUserRequest struct {
 	Nickname string `json:"nickname"`
 	Password string `json:"password"`
 }
+
+type postRefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type deleteSignInLockRequest struct {
+	Nickname string `json:"nickname"`
+	IP       string `json:"ip"`
+}
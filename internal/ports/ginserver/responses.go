@@ -2,33 +2,71 @@ package ginserver
 
 import (
 	"console-chat/internal/model"
+	"console-chat/internal/ports/wsserver"
+	"console-chat/internal/protocol"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 type tokenResponse struct {
-	TokenString string `json:"token_string"`
+	TokenString           string    `json:"token_string"`
+	TokenType             string    `json:"token_type"`
+	ExpiresAt             time.Time `json:"expires_at"`
+	ExpiresIn             int64     `json:"expires_in"` // seconds
+	RefreshToken          string    `json:"refresh_token"`
+	RefreshTokenExpiresAt time.Time `json:"refresh_token_expires_at"`
 }
 
-func getUserResponse(token string) *gin.H {
+func getUserResponse(token string, expiresAt time.Time, refresh model.RefreshToken) *gin.H {
 	return &gin.H{
 		"data": tokenResponse{
-			TokenString: token,
+			TokenString:           token,
+			TokenType:             "Bearer",
+			ExpiresAt:             expiresAt.UTC().Truncate(time.Second),
+			ExpiresIn:             int64(time.Until(expiresAt).Round(time.Second).Seconds()),
+			RefreshToken:          refresh.Token,
+			RefreshTokenExpiresAt: refresh.ExpiresAt.UTC().Truncate(time.Second),
 		},
 		"error": nil,
 	}
 }
 
-type userResponse struct {
-	Nickname       string `json:"nickname"`
-	HashedPassword string `json:"hashed_password"`
+func postUserResponse(usr model.User) *gin.H {
+	return &gin.H{
+		"data":  protocol.NewUser(usr.Nickname, usr.CreatedAt),
+		"error": nil,
+	}
 }
 
-func postUserResponse(usr model.User) *gin.H {
+func emptyResponse() *gin.H {
+	return &gin.H{
+		"data":  nil,
+		"error": nil,
+	}
+}
+
+type onlineUsers struct {
+	Users []protocol.Presence `json:"users"`
+}
+
+func onlineResponse(online []protocol.Presence) *gin.H {
+	return &gin.H{
+		"data": onlineUsers{
+			Users: online,
+		},
+		"error": nil,
+	}
+}
+
+type metrics struct {
+	WsQueues wsserver.QueueStats `json:"ws_queues"`
+}
+
+func metricsResponse(stats wsserver.QueueStats) *gin.H {
 	return &gin.H{
-		"data": userResponse{
-			Nickname:       usr.Nickname,
-			HashedPassword: usr.HashedPassword,
+		"data": metrics{
+			WsQueues: stats,
 		},
 		"error": nil,
 	}
@@ -0,0 +1,89 @@
+package ginserver
+
+import (
+	"console-chat/internal/model"
+	"console-chat/internal/ports/wsserver"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// credentialWords are parts of field names and json keys which point to
+// credential material
+var credentialWords = []string{"password", "hash", "salt", "secret"}
+
+func isCredential(name string) bool {
+	name = strings.ToLower(name)
+	for _, word := range credentialWords {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkType fails if struct type t or any type nested in it has field which
+// looks like credential material
+func checkType(t *testing.T, typ reflect.Type, seen map[reflect.Type]bool) {
+	for typ.Kind() == reflect.Pointer || typ.Kind() == reflect.Slice || typ.Kind() == reflect.Array || typ.Kind() == reflect.Map {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct || seen[typ] {
+		return
+	}
+	seen[typ] = true
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag := strings.Split(field.Tag.Get("json"), ",")[0]
+		assert.False(t, isCredential(field.Name) || isCredential(tag), "%s.%s is a credential", typ, field.Name)
+		checkType(t, field.Type, seen)
+	}
+}
+
+func TestResponsesHaveNoCredentials(t *testing.T) {
+	usr := model.User{
+		Nickname:       "papey08",
+		HashedPassword: "$argon2id$v=19$m=65536,t=1,p=4$c2FsdA$aGFzaA",
+		CreatedAt:      time.Now(),
+	}
+	responses := []*gin.H{
+		getUserResponse("token", time.Now(), model.RefreshToken{}),
+		postUserResponse(usr),
+		emptyResponse(),
+		metricsResponse(wsserver.QueueStats{}),
+		onlineResponse(nil),
+		ErrorResponse(model.UserNotFound),
+	}
+	seen := make(map[reflect.Type]bool)
+	for _, resp := range responses {
+		for key, value := range *resp {
+			assert.False(t, isCredential(key), key)
+			if value != nil {
+				checkType(t, reflect.TypeOf(value), seen)
+			}
+		}
+	}
+
+	// every struct declared among responses is checked, even if it isn't
+	// listed above yet
+	file, err := parser.ParseFile(token.NewFileSet(), "responses.go", nil, 0)
+	assert.NoError(t, err)
+	ast.Inspect(file, func(node ast.Node) bool {
+		if field, ok := node.(*ast.Field); ok {
+			for _, name := range field.Names {
+				assert.False(t, isCredential(name.Name), "field %s is a credential", name.Name)
+			}
+			if field.Tag != nil {
+				assert.False(t, isCredential(field.Tag.Value), "tag %s is a credential", field.Tag.Value)
+			}
+		}
+		return true
+	})
+}
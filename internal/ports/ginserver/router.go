@@ -2,13 +2,34 @@ package ginserver
 
 import (
 	"console-chat/internal/app"
+	"console-chat/internal/ports/token"
 	"console-chat/internal/ports/wsserver"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-func AppRouter(r *gin.RouterGroup, ws wsserver.WsServer, a app.App, tokenKey []byte) {
+// defaultAccessTTL is how long access token is valid if lifetime isn't configured
+const defaultAccessTTL = 15 * time.Minute
+
+func AppRouter(r *gin.RouterGroup, ws wsserver.WsServer, a app.App, keys *token.Keyring, cfg Config) {
+	accessTTL := cfg.AccessTTL
+	if accessTTL <= 0 {
+		accessTTL = defaultAccessTTL
+	}
 	r.GET("/chat", gin.WrapF(ws.Chat))
-	r.GET("/users/:user_nickname", getUser(a, tokenKey))
+	r.POST("/sessions", postSession(a, keys, accessTTL))
+	if cfg.LegacySignIn {
+		r.GET("/users/:user_nickname", deprecated(r.BasePath()+"/sessions"), getUser(a, keys, accessTTL))
+	}
+	r.POST("/tokens/refresh", postRefresh(a, ws, keys, accessTTL))
+	r.POST("/logout", postLogout(a, ws, keys, accessTTL))
 	r.POST("users", postUser(a))
+	r.GET("/online", authorized(a, keys), getOnline(ws))
+	r.GET("/.well-known/jwks.json", getJWKS(keys))
+	if cfg.AdminToken != "" {
+		admin := r.Group("/admin", adminOnly(cfg.AdminToken))
+		admin.DELETE("/sign-in-locks", deleteSignInLock(a))
+		admin.GET("/metrics", getMetrics(ws))
+	}
 }
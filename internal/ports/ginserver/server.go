@@ -2,18 +2,41 @@ package ginserver
 
 import (
 	"console-chat/internal/app"
+	"console-chat/internal/ports/token"
 	"console-chat/internal/ports/wsserver"
 	"fmt"
+	"log"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-func NewHTTPServer(host string, port int, ws wsserver.WsServer, app app.App, tokenKey []byte) *http.Server {
+// Config is configuration of the http server
+type Config struct {
+	AccessTTL time.Duration // lifetime of access tokens
+
+	// LegacySignIn keeps deprecated sign in with GET /users/:user_nickname
+	// for clients which weren't updated to POST /sessions
+	LegacySignIn bool
+
+	// AdminToken authorizes admin routes, they are disabled if it is empty
+	AdminToken string
+
+	// TrustedProxies may set client IP in X-Forwarded-For header, nobody is
+	// trusted by default so that sign in throttling of IP can't be bypassed
+	TrustedProxies []string
+}
+
+func NewHTTPServer(host string, port int, ws wsserver.WsServer, app app.App, keys *token.Keyring, cfg Config) *http.Server {
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.Default()
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Println("invalid trusted proxies, nobody is trusted:", err.Error())
+		_ = router.SetTrustedProxies(nil)
+	}
 	api := router.Group("console-chat")
-	AppRouter(api, ws, app, tokenKey)
+	AppRouter(api, ws, app, keys, cfg)
 	return &http.Server{
 		Addr:    fmt.Sprintf("%s:%d", host, port),
 		Handler: router,
@@ -2,20 +2,29 @@ package ginserver
 
 import (
 	mocks "console-chat/internal/ports/ginserver/app_mocks"
+	"console-chat/internal/ports/token"
 	"console-chat/internal/ports/wsserver"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/suite"
 )
 
+const testAdminToken = "admin-token"
+
 type ginServerTestSuite struct {
 	suite.Suite
 	app     *mocks.App
+	keys    *token.Keyring
 	client  *http.Client
 	server  *http.Server
 	baseURL string
@@ -24,9 +33,17 @@ type ginServerTestSuite struct {
 func ginServerTestSuiteInit(s *ginServerTestSuite) {
 	s.app = new(mocks.App)
 
-	tokenKey := []byte("abcd")
-	ws := wsserver.New(tokenKey)
-	s.server = NewHTTPServer("localhost", 8081, ws, s.app, tokenKey)
+	// Ed25519 key isn't active, it is only published in jwks
+	_, edPrivate, _ := ed25519.GenerateKey(rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(edPrivate)
+	edKey, _ := token.ParsePrivateKey("ed", token.EdDSA, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	s.keys, _ = token.NewKeyring("test", token.NewHMACKey("test", []byte("abcd")), edKey)
+	ws := wsserver.New(s.keys, s.app, wsserver.Config{})
+	s.server = NewHTTPServer("localhost", 8081, ws, s.app, s.keys, Config{
+		AccessTTL:    time.Minute,
+		LegacySignIn: true,
+		AdminToken:   testAdminToken,
+	})
 	testServer := httptest.NewServer(s.server.Handler)
 	s.client = testServer.Client()
 	s.baseURL = testServer.URL
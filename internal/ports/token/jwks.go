@@ -0,0 +1,242 @@
+package token
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrInvalidJWK = errors.New("invalid json web key")
+
+const (
+	// jwksTimeout limits the whole request for JWKS
+	jwksTimeout = 10 * time.Second
+
+	// DefaultJWKSRefresh is how often verifier refetches JWKS if interval
+	// isn't configured
+	DefaultJWKSRefresh = 10 * time.Minute
+
+	// minJWKSRefetch limits how often tokens signed by unknown keys make
+	// verifier refetch JWKS
+	minJWKSRefetch = 30 * time.Second
+)
+
+// jwksClient fetches JWKS, unlike http.DefaultClient it doesn't wait for
+// the response forever
+var jwksClient = &http.Client{Timeout: jwksTimeout}
+
+// JWK is a public key in JSON Web Key format (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n,omitempty"`   // RSA modulus
+	E   string `json:"e,omitempty"`   // RSA exponent
+	Crv string `json:"crv,omitempty"` // Ed25519 curve
+	X   string `json:"x,omitempty"`   // Ed25519 public key
+}
+
+// JWKS is a set of public keys
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns public parts of RSA and Ed25519 keys of the keyring, HMAC
+// keys are never published
+func (k *Keyring) JWKS() JWKS {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	set := JWKS{
+		Keys: make([]JWK, 0, len(k.keys)),
+	}
+	for _, key := range k.keys {
+		switch public := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "RSA",
+				Kid: key.ID,
+				Alg: RS256,
+				Use: "sig",
+				N:   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "OKP",
+				Kid: key.ID,
+				Alg: EdDSA,
+				Use: "sig",
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(public),
+			})
+		}
+	}
+	sort.Slice(set.Keys, func(i, j int) bool {
+		return set.Keys[i].Kid < set.Keys[j].Kid
+	})
+	return set
+}
+
+// Key converts JWK to the key which verifies tokens
+func (jwk JWK) Key() (Key, error) {
+	switch {
+	case jwk.Kty == "RSA" && (jwk.Alg == RS256 || jwk.Alg == ""):
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return Key{}, ErrInvalidJWK
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return Key{}, ErrInvalidJWK
+		}
+		public := &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+		if public.N.BitLen() < minRSABits {
+			return Key{}, ErrWeakKey
+		}
+		return newRSAPublicKey(jwk.Kid, public), nil
+	case jwk.Kty == "OKP" && jwk.Crv == "Ed25519" && (jwk.Alg == EdDSA || jwk.Alg == ""):
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return Key{}, ErrInvalidJWK
+		}
+		return newEdPublicKey(jwk.Kid, ed25519.PublicKey(x)), nil
+	default:
+		return Key{}, ErrUnknownAlgorithm
+	}
+}
+
+// ParseJWKS decodes set of public keys, keys of unknown types are skipped
+func ParseJWKS(data []byte) ([]Key, error) {
+	var set JWKS
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	keys := make([]Key, 0, len(set.Keys))
+	for _, jwk := range set.Keys {
+		key, err := jwk.Key()
+		if err == ErrUnknownAlgorithm {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("key %q: %w", jwk.Kid, err)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, ErrNoKeys
+	}
+	return keys, nil
+}
+
+// FetchJWKS downloads set of public keys from url
+func FetchJWKS(ctx context.Context, url string) ([]Key, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := jwksClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("can't fetch jwks: %s", resp.Status)
+	}
+
+	var set json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+	return ParseJWKS(set)
+}
+
+// jwksSource is where verifier refetches keys from
+type jwksSource struct {
+	url string
+
+	mu        sync.Mutex
+	lastFetch time.Time
+}
+
+// allow reports if keys may be refetched now and remembers the time
+func (s *jwksSource) allow() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Since(s.lastFetch) < minJWKSRefetch {
+		return false
+	}
+	s.lastFetch = time.Now()
+	return true
+}
+
+// NewJWKSVerifier creates Keyring which verifies tokens with keys fetched
+// from url. Keys are refetched every interval until ctx is done and when a
+// token is signed by unknown key, but not more often than minJWKSRefetch,
+// so keys rotated by the signer are picked up without restart
+func NewJWKSVerifier(ctx context.Context, url string, interval time.Duration) (*Keyring, error) {
+	keys, err := FetchJWKS(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	k, err := NewVerifier(keys...)
+	if err != nil {
+		return nil, err
+	}
+	k.jwks = &jwksSource{url: url, lastFetch: time.Now()}
+
+	if interval <= 0 {
+		interval = DefaultJWKSRefresh
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				k.jwks.mu.Lock()
+				k.jwks.lastFetch = time.Now()
+				k.jwks.mu.Unlock()
+				if err := k.refetch(); err != nil {
+					log.Println("can't refetch jwks:", err.Error())
+				}
+			}
+		}
+	}()
+	return k, nil
+}
+
+// refetch replaces keys of the verifier with keys fetched from JWKS, keys
+// are kept if they can't be fetched
+func (k *Keyring) refetch() error {
+	keys, err := FetchJWKS(context.Background(), k.jwks.url)
+	if err != nil {
+		return err
+	}
+	m, err := keyMap(keys)
+	if err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys = m
+	return nil
+}
@@ -0,0 +1,229 @@
+package token
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+)
+
+// encodePrivateKey encodes private key to PKCS #8 PEM
+func encodePrivateKey(t *testing.T, private any) []byte {
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	assert.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+// encodePublicKey encodes public key to PKIX PEM
+func encodePublicKey(t *testing.T, public any) []byte {
+	der, err := x509.MarshalPKIXPublicKey(public)
+	assert.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+// newTestKeys generates RS256 and EdDSA keys
+func newTestKeys(t *testing.T) (Key, Key) {
+	rsaPrivate, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	rsaKey, err := ParsePrivateKey("rsa", RS256, encodePrivateKey(t, rsaPrivate))
+	assert.NoError(t, err)
+
+	_, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	edKey, err := ParsePrivateKey("ed", EdDSA, encodePrivateKey(t, edPrivate))
+	assert.NoError(t, err)
+	return rsaKey, edKey
+}
+
+func TestAsymmetricKeys(t *testing.T) {
+	rsaKey, edKey := newTestKeys(t)
+
+	for _, key := range []Key{rsaKey, edKey} {
+		keyring, err := NewKeyring(key.ID, key, newKey)
+		assert.NoError(t, err, key.ID)
+		signed, err := keyring.Sign(testClaims())
+		assert.NoError(t, err, key.ID)
+
+		parsed, _ := jwt.Parse(signed, nil)
+		assert.Equal(t, key.Algorithm(), parsed.Header["alg"], key.ID)
+
+		// verifier has no private keys, but verifies tokens of the keyring
+		verifier := keyring.Verifier()
+		_, err = verifier.Sign(testClaims())
+		assert.ErrorIs(t, err, ErrCannotSign, key.ID)
+		claims, err := verifier.Parse(signed)
+		assert.NoError(t, err, key.ID)
+		assert.Equal(t, "user01", claims["nickname"], key.ID)
+
+		// key made from public PEM can't become active
+		public, _ := key.Public()
+		publicKey, err := ParsePublicKey(key.ID, key.Algorithm(), encodePublicKey(t, public.verifyKey))
+		assert.NoError(t, err, key.ID)
+		_, err = NewKeyring(key.ID, publicKey)
+		assert.ErrorIs(t, err, ErrCannotSign, key.ID)
+	}
+
+	weakPrivate, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert.NoError(t, err)
+	_, err = ParsePrivateKey("weak", RS256, encodePrivateKey(t, weakPrivate))
+	assert.ErrorIs(t, err, ErrWeakKey)
+
+	_, err = ParsePrivateKey("unknown", "ES256", nil)
+	assert.ErrorIs(t, err, ErrUnknownAlgorithm)
+}
+
+func TestJWKS(t *testing.T) {
+	rsaKey, edKey := newTestKeys(t)
+	keyring, err := NewKeyring("ed", rsaKey, edKey, newKey)
+	assert.NoError(t, err)
+
+	data, err := json.Marshal(keyring.JWKS())
+	assert.NoError(t, err)
+
+	// HMAC secrets are never published
+	set := keyring.JWKS()
+	assert.Len(t, set.Keys, 2)
+	assert.Equal(t, "ed", set.Keys[0].Kid)
+	assert.Equal(t, "OKP", set.Keys[0].Kty)
+	assert.Equal(t, "rsa", set.Keys[1].Kid)
+	assert.Equal(t, "RSA", set.Keys[1].Kty)
+
+	keys, err := ParseJWKS(data)
+	assert.NoError(t, err)
+	verifier, err := NewVerifier(keys...)
+	assert.NoError(t, err)
+
+	for _, activeID := range []string{"rsa", "ed"} {
+		signer, err := NewKeyring(activeID, rsaKey, edKey)
+		assert.NoError(t, err)
+		signed, err := signer.Sign(testClaims())
+		assert.NoError(t, err)
+		_, err = verifier.Parse(signed)
+		assert.NoError(t, err, activeID)
+	}
+
+	// token signed by HMAC key is unknown to the verifier made from jwks
+	hmacKeyring, err := NewKeyring("2023-08", newKey)
+	assert.NoError(t, err)
+	signed, err := hmacKeyring.Sign(testClaims())
+	assert.NoError(t, err)
+	_, err = verifier.Parse(signed)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+
+	_, err = ParseJWKS([]byte(`{"keys":[{"kty":"oct","kid":"secret","k":"abcd"}]}`))
+	assert.ErrorIs(t, err, ErrNoKeys)
+}
+
+// serveJWKS serves JWKS of the signer which may be replaced, returns url and
+// counter of requests
+func serveJWKS(t *testing.T, signer *atomic.Pointer[Keyring]) (string, *atomic.Int32) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		_ = json.NewEncoder(w).Encode(signer.Load().JWKS())
+	}))
+	t.Cleanup(server.Close)
+	return server.URL, &requests
+}
+
+// setLastFetch changes time of the last fetch of JWKS by the verifier
+func setLastFetch(k *Keyring, lastFetch time.Time) {
+	k.jwks.mu.Lock()
+	defer k.jwks.mu.Unlock()
+	k.jwks.lastFetch = lastFetch
+}
+
+func TestJWKSVerifierRefetch(t *testing.T) {
+	rsaKey, edKey := newTestKeys(t)
+	var signer atomic.Pointer[Keyring]
+	before, err := NewKeyring("rsa", rsaKey)
+	assert.NoError(t, err)
+	signer.Store(before)
+	url, requests := serveJWKS(t, &signer)
+
+	verifier, err := NewJWKSVerifier(context.Background(), url, time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), requests.Load())
+
+	// signer rotates the key
+	after, err := NewKeyring("ed", rsaKey, edKey)
+	assert.NoError(t, err)
+	signer.Store(after)
+	signed, err := after.Sign(testClaims())
+	assert.NoError(t, err)
+
+	// keys aren't refetched right after the previous fetch
+	_, err = verifier.Parse(signed)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+	assert.Equal(t, int32(1), requests.Load())
+
+	// token with unknown kid makes verifier refetch keys
+	setLastFetch(verifier, time.Time{})
+	_, err = verifier.Parse(signed)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), requests.Load())
+
+	// refetches are rate-limited
+	hmacKeyring, err := NewKeyring("2023-08", newKey)
+	assert.NoError(t, err)
+	forged, err := hmacKeyring.Sign(testClaims())
+	assert.NoError(t, err)
+	_, err = verifier.Parse(forged)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+	assert.Equal(t, int32(2), requests.Load())
+}
+
+func TestJWKSVerifierRefresh(t *testing.T) {
+	rsaKey, edKey := newTestKeys(t)
+	var signer atomic.Pointer[Keyring]
+	before, err := NewKeyring("rsa", rsaKey)
+	assert.NoError(t, err)
+	signer.Store(before)
+	url, _ := serveJWKS(t, &signer)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	verifier, err := NewJWKSVerifier(ctx, url, 50*time.Millisecond)
+	assert.NoError(t, err)
+	after, err := NewKeyring("ed", rsaKey, edKey)
+	assert.NoError(t, err)
+	signer.Store(after)
+	signed, err := after.Sign(testClaims())
+	assert.NoError(t, err)
+
+	// keys are refetched periodically without tokens of unknown keys
+	assert.Eventually(t, func() bool {
+		_, ok := verifier.key("ed")
+		return ok
+	}, time.Second, 10*time.Millisecond)
+	_, err = verifier.Parse(signed)
+	assert.NoError(t, err)
+}
+
+func TestWrongAlgorithm(t *testing.T) {
+	rsaKey, _ := newTestKeys(t)
+	verifier := func() *Keyring {
+		keyring, _ := NewKeyring("rsa", rsaKey)
+		return keyring.Verifier()
+	}()
+
+	// public key of RSA key is known to everybody, it must not be accepted
+	// as HMAC secret
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims())
+	forged.Header["kid"] = "rsa"
+	signed, err := forged.SignedString(encodePublicKey(t, rsaKey.verifyKey))
+	assert.NoError(t, err)
+	_, err = verifier.Parse(signed)
+	assert.ErrorIs(t, err, ErrWrongAlgorithm)
+}
@@ -0,0 +1,143 @@
+package token
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"errors"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// Token signing algorithms
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	EdDSA = "EdDSA"
+)
+
+// minRSABits is the minimal size of RSA key accepted for signing tokens
+const minRSABits = 2048
+
+var ErrUnknownAlgorithm = errors.New("unknown token signing algorithm")
+var ErrWeakKey = errors.New("token key is too weak")
+
+// Key signs and verifies tokens. HMAC keys are symmetric, RSA and Ed25519
+// keys may have only the public part, such keys verify tokens but can't sign
+// them
+type Key struct {
+	ID        string
+	method    jwt.SigningMethod
+	signKey   any // nil if the key can only verify tokens
+	verifyKey any
+}
+
+// NewHMACKey creates HS256 key from secret
+func NewHMACKey(id string, secret []byte) Key {
+	return Key{
+		ID:        id,
+		method:    jwt.SigningMethodHS256,
+		signKey:   secret,
+		verifyKey: secret,
+	}
+}
+
+// ParsePrivateKey creates RS256 or EdDSA key from PEM-encoded private key
+func ParsePrivateKey(id, alg string, data []byte) (Key, error) {
+	switch alg {
+	case RS256:
+		private, err := jwt.ParseRSAPrivateKeyFromPEM(data)
+		if err != nil {
+			return Key{}, err
+		}
+		if private.N.BitLen() < minRSABits {
+			return Key{}, ErrWeakKey
+		}
+		return Key{
+			ID:        id,
+			method:    jwt.SigningMethodRS256,
+			signKey:   private,
+			verifyKey: &private.PublicKey,
+		}, nil
+	case EdDSA:
+		private, err := jwt.ParseEdPrivateKeyFromPEM(data)
+		if err != nil {
+			return Key{}, err
+		}
+		edPrivate, ok := private.(ed25519.PrivateKey)
+		if !ok {
+			return Key{}, jwt.ErrNotEdPrivateKey
+		}
+		return Key{
+			ID:        id,
+			method:    jwt.SigningMethodEdDSA,
+			signKey:   edPrivate,
+			verifyKey: edPrivate.Public(),
+		}, nil
+	default:
+		return Key{}, ErrUnknownAlgorithm
+	}
+}
+
+// ParsePublicKey creates RS256 or EdDSA key which only verifies tokens from
+// PEM-encoded public key
+func ParsePublicKey(id, alg string, data []byte) (Key, error) {
+	switch alg {
+	case RS256:
+		public, err := jwt.ParseRSAPublicKeyFromPEM(data)
+		if err != nil {
+			return Key{}, err
+		}
+		return newRSAPublicKey(id, public), nil
+	case EdDSA:
+		public, err := jwt.ParseEdPublicKeyFromPEM(data)
+		if err != nil {
+			return Key{}, err
+		}
+		edPublic, ok := public.(ed25519.PublicKey)
+		if !ok {
+			return Key{}, jwt.ErrNotEdPublicKey
+		}
+		return newEdPublicKey(id, edPublic), nil
+	default:
+		return Key{}, ErrUnknownAlgorithm
+	}
+}
+
+func newRSAPublicKey(id string, public *rsa.PublicKey) Key {
+	return Key{
+		ID:        id,
+		method:    jwt.SigningMethodRS256,
+		verifyKey: public,
+	}
+}
+
+func newEdPublicKey(id string, public ed25519.PublicKey) Key {
+	return Key{
+		ID:        id,
+		method:    jwt.SigningMethodEdDSA,
+		verifyKey: public,
+	}
+}
+
+// Algorithm returns name of the signing algorithm of the key
+func (k Key) Algorithm() string {
+	return k.method.Alg()
+}
+
+// CanSign checks if the key has the private part
+func (k Key) CanSign() bool {
+	return k.signKey != nil
+}
+
+// Public returns the key without its private part, ok is false for HMAC keys
+// which can't be split
+func (k Key) Public() (Key, bool) {
+	if k.method == jwt.SigningMethodHS256 {
+		return Key{}, false
+	}
+	return Key{
+		ID:        k.ID,
+		method:    k.method,
+		verifyKey: k.verifyKey,
+	}, true
+}
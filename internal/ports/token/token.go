@@ -0,0 +1,184 @@
+// Package token signs and verifies jwt tokens of chat users. Tokens are
+// signed by the active key and carry its ID in the kid header, so keys can be
+// rotated: a new key becomes active while the old ones still verify tokens
+// issued before the rotation. Tokens signed by RSA or Ed25519 keys are
+// verified with public keys only, which are published as JWKS.
+package token
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+
+	"github.com/golang-jwt/jwt"
+)
+
+var ErrNoKeys = errors.New("no token keys are configured")
+var ErrUnknownKey = errors.New("token is signed by unknown key")
+var ErrDuplicateKey = errors.New("token key ID is used twice")
+var ErrExpired = errors.New("token has expired")
+var ErrCannotSign = errors.New("token key can't sign tokens")
+var ErrWrongAlgorithm = errors.New("token is signed by another algorithm than its key")
+
+// Keyring signs tokens with the active key and verifies tokens signed by any
+// of its keys
+type Keyring struct {
+	active *Key // nil if keyring only verifies tokens
+
+	// keys are replaced when verifier refetches them from JWKS
+	mu   sync.RWMutex
+	keys map[string]Key
+	jwks *jwksSource // nil unless keys are fetched from JWKS
+}
+
+// NewKeyring creates Keyring from keys, key with activeID is used for
+// signing. If activeID is empty the first key becomes active
+func NewKeyring(activeID string, keys ...Key) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, ErrNoKeys
+	}
+	if activeID == "" {
+		activeID = keys[0].ID
+	}
+
+	k, err := NewVerifier(keys...)
+	if err != nil {
+		return nil, err
+	}
+	active, ok := k.keys[activeID]
+	if !ok {
+		return nil, ErrUnknownKey
+	} else if !active.CanSign() {
+		return nil, ErrCannotSign
+	}
+	k.active = &active
+	return k, nil
+}
+
+// NewVerifier creates Keyring which only verifies tokens
+func NewVerifier(keys ...Key) (*Keyring, error) {
+	m, err := keyMap(keys)
+	if err != nil {
+		return nil, err
+	}
+	return &Keyring{
+		keys: m,
+	}, nil
+}
+
+// keyMap indexes keys by their IDs
+func keyMap(keys []Key) (map[string]Key, error) {
+	if len(keys) == 0 {
+		return nil, ErrNoKeys
+	}
+	m := make(map[string]Key, len(keys))
+	for _, key := range keys {
+		if _, ok := m[key.ID]; ok {
+			return nil, ErrDuplicateKey
+		}
+		m[key.ID] = key
+	}
+	return m, nil
+}
+
+// Verifier returns keyring which verifies the same tokens but has no private
+// keys. HMAC secrets are kept as they can't be split, so use RSA or Ed25519
+// keys to keep the ability to sign tokens away from verifiers
+func (k *Keyring) Verifier() *Keyring {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	v := &Keyring{
+		keys: make(map[string]Key, len(k.keys)),
+	}
+	for id, key := range k.keys {
+		if public, ok := key.Public(); ok {
+			key = public
+		}
+		v.keys[id] = key
+	}
+	return v
+}
+
+// Sign creates token with claims signed by the active key. Every token gets
+// unique jti claim unless claims already have it
+func (k *Keyring) Sign(claims jwt.MapClaims) (string, error) {
+	if k.active == nil {
+		return "", ErrCannotSign
+	}
+	if _, ok := claims["jti"]; !ok {
+		id, err := newTokenID()
+		if err != nil {
+			return "", err
+		}
+		claims["jti"] = id
+	}
+	token := jwt.NewWithClaims(k.active.method, claims)
+	token.Header["kid"] = k.active.ID
+	return token.SignedString(k.active.signKey)
+}
+
+// Parse verifies token and returns its claims. Token is verified by the key
+// from its kid header, tokens without kid are verified by the active key.
+// Algorithm of the token should match algorithm of the key.
+// Returns ErrExpired for valid but expired tokens and ErrUnknownKey for
+// tokens signed by removed keys
+func (k *Keyring) Parse(tokenString string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, k.keyFunc)
+	var validationErr *jwt.ValidationError
+	if errors.As(err, &validationErr) {
+		switch {
+		case validationErr.Inner == ErrUnknownKey, validationErr.Inner == ErrWrongAlgorithm:
+			return nil, validationErr.Inner
+		case validationErr.Errors == jwt.ValidationErrorExpired:
+			return nil, ErrExpired
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// key returns the key with the ID
+func (k *Keyring) key(id string) (Key, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok := k.keys[id]
+	return key, ok
+}
+
+// newTokenID generates random unique ID of the token
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// keyFunc chooses the key which verifies the token, verifier fetching keys
+// from JWKS refetches them if the key is unknown
+func (k *Keyring) keyFunc(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	var key Key
+	if kid == "" && k.active != nil {
+		key = *k.active
+	} else if known, ok := k.key(kid); ok {
+		key = known
+	} else if k.jwks == nil || !k.jwks.allow() {
+		return nil, ErrUnknownKey
+	} else if err := k.refetch(); err != nil {
+		return nil, ErrUnknownKey
+	} else if known, ok := k.key(kid); ok {
+		key = known
+	} else {
+		return nil, ErrUnknownKey
+	}
+	if token.Method.Alg() != key.Algorithm() {
+		return nil, ErrWrongAlgorithm
+	}
+	return key.verifyKey, nil
+}
@@ -0,0 +1,100 @@
+package token
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	oldKey = NewHMACKey("2023-07", []byte("old secret which is long enough for hs256"))
+	newKey = NewHMACKey("2023-08", []byte("new secret which is long enough for hs256"))
+)
+
+func testClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"nickname": "user01",
+		"exp":      time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func TestNewKeyring(t *testing.T) {
+	_, err := NewKeyring("")
+	assert.ErrorIs(t, err, ErrNoKeys)
+
+	_, err = NewKeyring("", oldKey, oldKey)
+	assert.ErrorIs(t, err, ErrDuplicateKey)
+
+	_, err = NewKeyring("2023-09", oldKey, newKey)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+
+	_, err = NewKeyring("", oldKey)
+	assert.NoError(t, err)
+}
+
+func TestRotation(t *testing.T) {
+	before, err := NewKeyring("2023-07", oldKey)
+	assert.NoError(t, err)
+	oldToken, err := before.Sign(testClaims())
+	assert.NoError(t, err)
+
+	// new key becomes active, old one is kept for verification
+	after, err := NewKeyring("2023-08", oldKey, newKey)
+	assert.NoError(t, err)
+	newToken, err := after.Sign(testClaims())
+	assert.NoError(t, err)
+
+	claims, err := after.Parse(oldToken)
+	assert.NoError(t, err)
+	assert.Equal(t, "user01", claims["nickname"])
+
+	claims, err = after.Parse(newToken)
+	assert.NoError(t, err)
+	assert.Equal(t, "user01", claims["nickname"])
+
+	parsed, _ := jwt.Parse(newToken, nil)
+	assert.Equal(t, "2023-08", parsed.Header["kid"])
+
+	// every token gets its own ID
+	otherToken, err := after.Sign(testClaims())
+	assert.NoError(t, err)
+	otherClaims, err := after.Parse(otherToken)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, claims["jti"])
+	assert.NotEqual(t, claims["jti"], otherClaims["jti"])
+
+	// old key is removed, its tokens are not valid anymore
+	retired, err := NewKeyring("2023-08", newKey)
+	assert.NoError(t, err)
+	_, err = retired.Parse(oldToken)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+	_, err = retired.Parse(newToken)
+	assert.NoError(t, err)
+}
+
+func TestParse(t *testing.T) {
+	keyring, err := NewKeyring("", newKey)
+	assert.NoError(t, err)
+
+	// token without kid is verified by the active key
+	legacy, err := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims()).SignedString(newKey.signKey)
+	assert.NoError(t, err)
+	_, err = keyring.Parse(legacy)
+	assert.NoError(t, err)
+
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims())
+	forged.Header["kid"] = newKey.ID
+	forgedToken, err := forged.SignedString([]byte("another secret"))
+	assert.NoError(t, err)
+	_, err = keyring.Parse(forgedToken)
+	assert.Error(t, err)
+
+	expiredClaims := testClaims()
+	expiredClaims["exp"] = time.Now().Add(-time.Minute).Unix()
+	expired, err := keyring.Sign(expiredClaims)
+	assert.NoError(t, err)
+	_, err = keyring.Parse(expired)
+	assert.ErrorIs(t, err, ErrExpired)
+}
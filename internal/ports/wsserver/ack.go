@@ -0,0 +1,80 @@
+package wsserver
+
+import (
+	"console-chat/internal/protocol"
+	"context"
+	"fmt"
+	"log"
+)
+
+// maxClientIDLength is the longest client-generated ID of the message
+const maxClientIDLength = 64
+
+// handleMessage sends message frame of the client to the room or to the
+// user. Message with client-generated ID is acked with ID and timestamp
+// assigned by server, retries of the handled message get the same ack and
+// aren't sent again
+func (s *wsServer) handleMessage(c *client, f protocol.Frame) {
+	if f.ID == "" {
+		s.sendMessage(c, f)
+		return
+	}
+	if len(f.ID) > maxClientIDLength {
+		s.reject(c, f.ID, protocol.ErrCodeBadRequest, fmt.Sprintf("message id is longer than %d characters", maxClientIDLength))
+		return
+	}
+
+	ctx := context.Background()
+	claimed, ack, err := s.broker.ClaimMessage(ctx, c.nickname, f.ID)
+	if err != nil {
+		log.Println("can't claim message", f.ID, "of", c.nickname, err.Error())
+		s.reject(c, f.ID, protocol.ErrCodeInternal, "can't send message, please try again later")
+		return
+	}
+	if !claimed {
+		// message which is still being handled is acked when it is done
+		if ack != nil {
+			s.sendToSession(c, *ack)
+		}
+		return
+	}
+
+	sent, ok := s.sendMessage(c, f)
+	if !ok {
+		if err := s.broker.ReleaseMessage(ctx, c.nickname, f.ID); err != nil {
+			log.Println("can't release message", f.ID, "of", c.nickname, err.Error())
+		}
+		return
+	}
+	a := ackFrame(f.ID, sent)
+	if err := s.broker.AckMessage(ctx, c.nickname, f.ID, a); err != nil {
+		log.Println("can't save ack of message", f.ID, "of", c.nickname, err.Error())
+	}
+	s.sendToSession(c, a)
+}
+
+// sendMessage applies limits to the message of the client and sends it,
+// returns sent frame or false if the message was rejected
+func (s *wsServer) sendMessage(c *client, f protocol.Frame) (protocol.Frame, bool) {
+	if !s.allowMessage(c, f) || !s.checkText(c, &f) {
+		return protocol.Frame{}, false
+	}
+	if f.To != "" {
+		return s.sendDirect(c, f)
+	}
+	return s.publish(c, f)
+}
+
+// ackFrame confirms that the client frame with ID replyTo was sent as frame
+// sent
+func ackFrame(replyTo string, sent protocol.Frame) protocol.Frame {
+	return protocol.Frame{
+		Type:      protocol.TypeAck,
+		ID:        sent.ID,
+		Seq:       sent.Seq,
+		ReplyTo:   replyTo,
+		Room:      sent.Room,
+		To:        sent.To,
+		Timestamp: sent.Timestamp,
+	}
+}
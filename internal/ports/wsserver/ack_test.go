@@ -0,0 +1,138 @@
+package wsserver
+
+import (
+	"console-chat/internal/app"
+	"console-chat/internal/ports/broker"
+	"console-chat/internal/protocol"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeMessage sends message with client-generated ID to the current room or
+// to the user
+func writeMessage(conn net.Conn, id, to, text string) error {
+	data, err := protocol.Encode(protocol.Frame{
+		Type: protocol.TypeMessage,
+		ID:   id,
+		To:   to,
+		Body: text,
+	})
+	if err != nil {
+		return err
+	}
+	return wsutil.WriteClientMessage(conn, ws.OpText, data)
+}
+
+func TestMessageAcks(t *testing.T) {
+	// retries may come to another instance after reconnect
+	shared := broker.NewMemory()
+	app := newTestApp()
+	wsserverA := New(testKeyring(), app, Config{Broker: shared})
+	wsserverB := New(testKeyring(), app, Config{Broker: shared})
+	serverA := httptest.NewServer(http.HandlerFunc(wsserverA.Chat))
+	defer serverA.Close()
+	serverB := httptest.NewServer(http.HandlerFunc(wsserverB.Chat))
+	defer serverB.Close()
+
+	conn01 := connect(t, "ws"+serverA.URL[4:], "user01")
+	defer conn01.Close()
+	conn02 := connect(t, "ws"+serverA.URL[4:], "user02")
+	defer conn02.Close()
+	expectTexts(t, conn01, "[general] user02 joins the room")
+
+	// ack has ID and timestamp assigned by server
+	assert.NoError(t, writeMessage(conn02, "client-1", "", "Hello"))
+	expectTexts(t, conn01, "[general] user02: Hello")
+	ack, err := readFrame(conn02)
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.TypeAck, ack.Type)
+	assert.Equal(t, "client-1", ack.ReplyTo)
+	assert.Equal(t, "1", ack.ID)
+	assert.Equal(t, int64(1), ack.Seq)
+	assert.Equal(t, "general", ack.Room)
+	assert.WithinDuration(t, time.Now(), ack.Timestamp, time.Second)
+
+	// retry from another session gets the same ack and isn't sent again
+	conn02B := connect(t, "ws"+serverB.URL[4:], "user02")
+	defer conn02B.Close()
+	assert.NoError(t, writeMessage(conn02B, "client-1", "", "Hello"))
+	retried, err := readFrame(conn02B)
+	assert.NoError(t, err)
+	assert.Equal(t, ack, retried)
+	assert.NoError(t, writeMessage(conn02, "client-2", "", "Bye"))
+	expectTexts(t, conn01, "[general] user02: Bye")
+	ack, err = readFrame(conn02)
+	assert.NoError(t, err)
+	assert.Equal(t, "client-2", ack.ReplyTo)
+	assert.Equal(t, "2", ack.ID)
+
+	// rejected message may be sent again
+	assert.NoError(t, writeMessage(conn02, "client-3", "", "\x1b[0m"))
+	f, err := readFrame(conn02)
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.ErrCodeInvalidText, f.Error.Code)
+	assert.Equal(t, "client-3", f.ReplyTo)
+	assert.NoError(t, writeMessage(conn02, "client-3", "", "Fixed"))
+	expectTexts(t, conn01, "[general] user02: Fixed")
+	ack, err = readFrame(conn02)
+	assert.NoError(t, err)
+	assert.Equal(t, "client-3", ack.ReplyTo)
+
+	// direct messages get random IDs
+	assert.NoError(t, writeMessage(conn01, "client-1", "user02", "Hi"))
+	expectTexts(t, conn02, "[dm] user01 -> user02: Hi")
+	ack, err = readFrame(conn01)
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.TypeAck, ack.Type)
+	assert.Equal(t, "client-1", ack.ReplyTo)
+	assert.Equal(t, "user02", ack.To)
+	assert.Len(t, ack.ID, 32)
+
+	assert.NoError(t, writeMessage(conn01, strings.Repeat("x", maxClientIDLength+1), "", "Hi"))
+	f, err = readFrame(conn01)
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.ErrCodeBadRequest, f.Error.Code)
+}
+
+func TestMessageNotSaved(t *testing.T) {
+	repo := newMemMessageRepo()
+	wsserver := New(testKeyring(), app.New(newMemUserRepo("user01", "user02"), repo, newMemTokenRepo(), nil, app.Config{}), Config{})
+	server := httptest.NewServer(http.HandlerFunc(wsserver.Chat))
+	defer server.Close()
+	url := "ws" + server.URL[4:]
+
+	conn01 := connect(t, url, "user01")
+	defer conn01.Close()
+	conn02 := connect(t, url, "user02")
+	defer conn02.Close()
+	expectTexts(t, conn01, "[general] user02 joins the room")
+
+	// message which isn't saved to history isn't sent and may be sent again
+	repo.mu.Lock()
+	repo.broken = true
+	repo.mu.Unlock()
+	assert.NoError(t, writeMessage(conn02, "client-1", "", "Hello"))
+	f, err := readFrame(conn02)
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.TypeError, f.Type)
+	assert.Equal(t, protocol.ErrCodeInternal, f.Error.Code)
+	assert.Equal(t, "client-1", f.ReplyTo)
+
+	repo.mu.Lock()
+	repo.broken = false
+	repo.mu.Unlock()
+	assert.NoError(t, writeMessage(conn02, "client-1", "", "Hello"))
+	expectTexts(t, conn01, "[general] user02: Hello")
+	ack, err := readFrame(conn02)
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.TypeAck, ack.Type)
+	assert.Equal(t, int64(1), ack.Seq)
+}
@@ -0,0 +1,100 @@
+package wsserver
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// client is a state of a single session of the user, user may have several
+// sessions at once
+type client struct {
+	sessionID   string
+	nickname    string
+	conn        net.Conn
+	currentRoom string
+
+	// tokenID and tokenFamily identify the token the session was opened
+	// with, session is closed when any of them is revoked
+	tokenID     string
+	tokenFamily string
+
+	// historyCursors are IDs of the oldest messages sent to the client from
+	// history of each room, 0 means nothing was sent yet
+	historyCursors map[string]int64
+
+	// watchPresence is set if the session gets presence changes of other
+	// users, guarded by mu of the server
+	watchPresence bool
+
+	// holding is set by Chat until history, missed and queued messages are
+	// sent to the new session, frames of other users are kept in held.
+	// sentSeqs are the latest sequence numbers of rooms sent to the session
+	// from history, held messages which were sent from history are dropped.
+	// Guarded by mu of the server
+	holding  bool
+	held     []heldFrame
+	sentSeqs map[string]int64
+
+	// out is a bounded queue of encoded frames drained by writeLoop
+	out chan []byte
+
+	// control is a queue of compiled control frames which are written before
+	// anything from out
+	control chan []byte
+
+	// queued, written and dropped count frames put to out, written from it
+	// to the connection and dropped from it because of the overflow. Frames
+	// are put under mu of the server, so the frame has left the queue when
+	// written+dropped reaches its number. progress is signalled when any
+	// frame leaves the queue
+	queued   atomic.Uint64
+	written  atomic.Uint64
+	dropped  atomic.Uint64
+	progress chan struct{}
+
+	// done is closed when the session is closed
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// heldFrame is a frame to the session which is held until the session gets
+// history, seq is set for room messages
+type heldFrame struct {
+	room string
+	seq  int64
+	data []byte
+}
+
+func newClient(sessionID, nickname string, conn net.Conn, queueSize int) *client {
+	return &client{
+		sessionID:      sessionID,
+		nickname:       nickname,
+		conn:           conn,
+		currentRoom:    defaultRoom,
+		historyCursors: make(map[string]int64),
+		out:            make(chan []byte, queueSize),
+		control:        make(chan []byte, controlQueueSize),
+		progress:       make(chan struct{}, 1),
+		done:           make(chan struct{}),
+	}
+}
+
+// signalProgress wakes up waiter of frames leaving the outbound queue
+func (c *client) signalProgress() {
+	select {
+	case c.progress <- struct{}{}:
+	default:
+	}
+}
+
+// close stops the session: reader is interrupted immediately and writer
+// flushes pending control frames and closes the connection. It is safe to
+// call close several times
+func (c *client) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		_ = c.conn.SetReadDeadline(time.Now())
+	})
+}
@@ -0,0 +1,99 @@
+package wsserver
+
+import (
+	"console-chat/internal/ports/broker"
+	"console-chat/internal/protocol"
+	"context"
+	"log"
+	"net"
+	"time"
+
+	"github.com/gobwas/ws"
+)
+
+const defaultAuthTimeout = 10 * time.Second
+
+// handshakeError is a reason of failed handshake, it is sent to the client
+// in the close frame
+type handshakeError struct {
+	code   int
+	reason string
+}
+
+func (e *handshakeError) Error() string {
+	return e.reason
+}
+
+// closeFrame compiles websocket close frame with status code and reason
+func closeFrame(code int, reason string) []byte {
+	return ws.MustCompileFrame(ws.NewCloseFrame(ws.NewCloseFrameBody(ws.StatusCode(code), reason)))
+}
+
+// rejectConn sends close frame to the connection which has no session yet
+// and closes it
+func (s *wsServer) rejectConn(conn net.Conn, code int, reason string) {
+	if err := conn.SetWriteDeadline(time.Now().Add(s.cfg.WriteTimeout)); err == nil {
+		_, _ = conn.Write(closeFrame(code, reason))
+	}
+	_ = conn.Close()
+}
+
+// closeSession sends close frame with status code and reason to the session
+// and closes it
+func (s *wsServer) closeSession(c *client, code int, reason string) {
+	s.enqueueControl(c, closeFrame(code, reason))
+	c.close()
+}
+
+// RevokeTokens closes all sessions in the cluster opened with tokens which
+// have any of IDs as jti or sid claim
+func (s *wsServer) RevokeTokens(ids ...string) {
+	s.closeRevoked(ids)
+	s.broadcast(broker.Event{Kind: broker.KindRevoke, IDs: ids})
+}
+
+// closeRevoked closes sessions of this instance opened with tokens which
+// have any of IDs as jti or sid claim
+func (s *wsServer) closeRevoked(ids []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, sessions := range s.connections {
+		for _, c := range sessions {
+			for _, id := range ids {
+				if id != "" && (id == c.tokenID || id == c.tokenFamily) {
+					log.Println("token of session", c.sessionID, "of", c.nickname, "was revoked")
+					s.closeSession(c, protocol.CloseTokenRevoked, "token was revoked")
+					break
+				}
+			}
+		}
+	}
+}
+
+// Shutdown closes all sessions with going away status and waits until their
+// close frames are written or ctx is done
+func (s *wsServer) Shutdown(ctx context.Context) error {
+	s.stopListen()
+	s.mu.Lock()
+	s.closing = true
+	for _, sessions := range s.connections {
+		for _, c := range sessions {
+			s.closeSession(c, protocol.CloseGoingAway, "server is shutting down")
+		}
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.writers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		log.Println("all chat sessions were closed")
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
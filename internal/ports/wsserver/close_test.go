@@ -0,0 +1,210 @@
+package wsserver
+
+import (
+	"console-chat/internal/protocol"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+)
+
+// readCloseCode skips data frames from the server until close frame and
+// returns its status code
+func readCloseCode(conn net.Conn) (int, error) {
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		f, err := ws.ReadFrame(conn)
+		if err != nil {
+			return 0, err
+		}
+		if f.Header.OpCode == ws.OpClose {
+			code, _ := ws.ParseCloseFrameData(ws.UnmaskFrame(f).Payload)
+			return int(code), nil
+		}
+	}
+}
+
+type closeTest struct {
+	description  string
+	firstFrame   []byte
+	expectedCode int
+}
+
+func TestHandshakeCloseCodes(t *testing.T) {
+	wsserver := New(testKeyring(), newTestApp(), Config{})
+	server := httptest.NewServer(http.HandlerFunc(wsserver.Chat))
+	defer server.Close()
+
+	url := "ws" + server.URL[4:]
+
+	expired, err := testKeyring().Sign(jwt.MapClaims{
+		"nickname": "user01",
+		"exp":      time.Now().Add(-time.Minute).Unix(),
+	})
+	assert.NoError(t, err)
+	encode := func(f protocol.Frame) []byte {
+		data, _ := protocol.Encode(f)
+		return data
+	}
+
+	tests := []closeTest{
+		{
+			description:  "message instead of auth frame",
+			firstFrame:   encode(protocol.Frame{Type: protocol.TypeMessage, Body: "Ping"}),
+			expectedCode: protocol.CloseProtocolError,
+		},
+		{
+			description:  "unsupported protocol version",
+			firstFrame:   encode(protocol.Frame{Type: protocol.TypeAuth, Body: expired, Versions: []int{2}}),
+			expectedCode: protocol.CloseUnsupportedVersion,
+		},
+		{
+			description:  "invalid token",
+			firstFrame:   encode(protocol.Frame{Type: protocol.TypeAuth, Body: "abcd", Versions: []int{1}}),
+			expectedCode: protocol.CloseBadToken,
+		},
+		{
+			description:  "expired token",
+			firstFrame:   encode(protocol.Frame{Type: protocol.TypeAuth, Body: expired, Versions: []int{1}}),
+			expectedCode: protocol.CloseTokenExpired,
+		},
+	}
+
+	for _, test := range tests {
+		conn, _, _, err := ws.DefaultDialer.Dial(context.Background(), url)
+		assert.NoError(t, err, test.description)
+
+		err = wsutil.WriteClientMessage(conn, ws.OpText, test.firstFrame)
+		assert.NoError(t, err, test.description)
+
+		code, err := readCloseCode(conn)
+		assert.NoError(t, err, test.description)
+		assert.Equal(t, test.expectedCode, code, test.description)
+		_ = conn.Close()
+	}
+
+	// client gets error frame with its own code for expired token
+	conn, _, _, err := ws.DefaultDialer.Dial(context.Background(), url)
+	assert.NoError(t, err)
+	defer func() {
+		_ = conn.Close()
+	}()
+	assert.NoError(t, wsutil.WriteClientMessage(conn, ws.OpText, tests[3].firstFrame))
+	f, err := readFrame(conn)
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.ErrCodeTokenExpired, f.Error.Code)
+}
+
+func TestAuthTimeout(t *testing.T) {
+	wsserver := New(testKeyring(), newTestApp(), Config{AuthTimeout: 50 * time.Millisecond})
+	server := httptest.NewServer(http.HandlerFunc(wsserver.Chat))
+	defer server.Close()
+
+	conn, _, _, err := ws.DefaultDialer.Dial(context.Background(), "ws"+server.URL[4:])
+	assert.NoError(t, err)
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	code, err := readCloseCode(conn)
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.CloseAuthTimeout, code)
+}
+
+func TestShutdown(t *testing.T) {
+	wsserver := New(testKeyring(), newTestApp(), Config{})
+	server := httptest.NewServer(http.HandlerFunc(wsserver.Chat))
+	defer server.Close()
+
+	url := "ws" + server.URL[4:]
+
+	token1, _ := codeNicknameInToken("user01")
+	token2, _ := codeNicknameInToken("user02")
+	conn1, err := getChat(url, token1)
+	assert.NoError(t, err)
+	defer func() {
+		_ = conn1.Close()
+	}()
+	conn2, err := getChat(url, token2)
+	assert.NoError(t, err)
+	defer func() {
+		_ = conn2.Close()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, wsserver.Shutdown(ctx))
+
+	for _, conn := range []net.Conn{conn1, conn2} {
+		code, err := readCloseCode(conn)
+		assert.NoError(t, err)
+		assert.Equal(t, protocol.CloseGoingAway, code)
+	}
+
+	// sessions opened after shutdown are closed right away
+	conn3, err := getChat(url, token1)
+	assert.NoError(t, err)
+	defer func() {
+		_ = conn3.Close()
+	}()
+	code, err := readCloseCode(conn3)
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.CloseGoingAway, code)
+}
+
+func TestRevokedToken(t *testing.T) {
+	a := newTestApp()
+	wsserver := New(testKeyring(), a, Config{})
+	server := httptest.NewServer(http.HandlerFunc(wsserver.Chat))
+	defer server.Close()
+
+	url := "ws" + server.URL[4:]
+
+	signed, err := testKeyring().Sign(jwt.MapClaims{
+		"nickname": "user01",
+		"exp":      time.Now().Add(time.Hour).Unix(),
+		"sid":      "family",
+	})
+	assert.NoError(t, err)
+	token2, _ := codeNicknameInToken("user02")
+
+	conn1, err := getChat(url, []byte(signed))
+	assert.NoError(t, err)
+	defer func() {
+		_ = conn1.Close()
+	}()
+	conn2, err := getChat(url, token2)
+	assert.NoError(t, err)
+	defer func() {
+		_ = conn2.Close()
+	}()
+
+	// live session opened with revoked token is closed at once, others stay
+	assert.NoError(t, a.RevokeToken(context.Background(), "family", time.Now().Add(time.Hour)))
+	wsserver.RevokeTokens("family")
+	code, err := readCloseCode(conn1)
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.CloseTokenRevoked, code)
+	text, err := readServerText(conn2)
+	assert.NoError(t, err)
+	assert.Equal(t, "[general] user01 leaves the room", text)
+
+	// revoked token can't open new sessions
+	conn3, _, _, err := ws.DefaultDialer.Dial(context.Background(), url)
+	assert.NoError(t, err)
+	defer func() {
+		_ = conn3.Close()
+	}()
+	data, _ := protocol.Encode(protocol.Frame{Type: protocol.TypeAuth, Body: signed, Versions: []int{1}})
+	assert.NoError(t, wsutil.WriteClientMessage(conn3, ws.OpText, data))
+	code, err = readCloseCode(conn3)
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.CloseTokenRevoked, code)
+}
@@ -0,0 +1,108 @@
+package wsserver
+
+import (
+	"console-chat/internal/ports/broker"
+	"console-chat/internal/protocol"
+	"context"
+	"log"
+	"time"
+)
+
+// resubscribeDelay is a pause before subscribing again after the
+// subscription to the broker failed
+const resubscribeDelay = time.Second
+
+// broadcast publishes event to other instances of the cluster
+func (s *wsServer) broadcast(e broker.Event) {
+	e.Origin = s.instanceID
+	if err := s.broker.Publish(context.Background(), e); err != nil {
+		log.Println("can't publish chat event:", err.Error())
+	}
+}
+
+// listen handles events of other instances until ctx is done, subscribing
+// again if subscription fails
+func (s *wsServer) listen(ctx context.Context) {
+	for {
+		events, err := s.broker.Subscribe(ctx)
+		if err != nil {
+			log.Println("can't subscribe to chat events:", err.Error())
+		} else {
+			s.handleEvents(ctx, events)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(resubscribeDelay):
+		}
+	}
+}
+
+// handleEvents applies events of other instances to sessions of this one,
+// events of this instance were already applied before publishing
+func (s *wsServer) handleEvents(ctx context.Context, events <-chan broker.Event) {
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			if e.Origin != s.instanceID {
+				s.handleEvent(e)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *wsServer) handleEvent(e broker.Event) {
+	switch e.Kind {
+	case broker.KindRoom:
+		if e.Frame != nil {
+			s.deliverToRoom(e.Room, e.Except, *e.Frame)
+		}
+	case broker.KindUser:
+		if e.Frame != nil {
+			s.deliverToUser(e.User, e.Except, *e.Frame)
+		}
+	case broker.KindJoin:
+		s.cacheMembership(e.User, e.Room, true)
+	case broker.KindLeave:
+		s.cacheMembership(e.User, e.Room, false)
+	case broker.KindRevoke:
+		s.closeRevoked(e.IDs)
+	case broker.KindPresence:
+		if e.Frame != nil {
+			s.deliverPresence(*e.Frame)
+		}
+	}
+}
+
+// sendToRoom sends frame from publisher session to all sessions of the room
+// in the cluster, publisher may be nil
+func (s *wsServer) sendToRoom(roomName string, publisher *client, f protocol.Frame) {
+	except := ""
+	if publisher != nil {
+		except = publisher.sessionID
+	}
+	s.deliverToRoom(roomName, except, f)
+	s.broadcast(broker.Event{Kind: broker.KindRoom, Room: roomName, Except: except, Frame: &f})
+}
+
+// sendToUser sends frame to all sessions of the user in the cluster
+func (s *wsServer) sendToUser(nickname string, f protocol.Frame) {
+	s.sendToUserExcept(nickname, nil, f)
+}
+
+// sendToUserExcept sends frame to all sessions of the user in the cluster
+// except the given one
+func (s *wsServer) sendToUserExcept(nickname string, except *client, f protocol.Frame) {
+	exceptID := ""
+	if except != nil {
+		exceptID = except.sessionID
+	}
+	s.deliverToUser(nickname, exceptID, f)
+	s.broadcast(broker.Event{Kind: broker.KindUser, User: nickname, Except: exceptID, Frame: &f})
+}
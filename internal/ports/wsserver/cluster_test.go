@@ -0,0 +1,112 @@
+package wsserver
+
+import (
+	"console-chat/internal/ports/broker"
+	"console-chat/internal/protocol"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+)
+
+// connect opens session of the user on the server
+func connect(t *testing.T, url, nickname string) net.Conn {
+	token, err := codeNicknameInToken(nickname)
+	assert.NoError(t, err)
+	conn, err := getChat(url, token)
+	assert.NoError(t, err)
+	time.Sleep(100 * time.Millisecond)
+	return conn
+}
+
+// expectTexts reads frames from the connection and compares them with texts
+func expectTexts(t *testing.T, conn net.Conn, texts ...string) {
+	for _, text := range texts {
+		msg, err := readServerText(conn)
+		assert.NoError(t, err)
+		assert.Equal(t, text, msg)
+	}
+}
+
+func TestCluster(t *testing.T) {
+	// two instances of the server share in-process broker
+	shared := broker.NewMemory()
+	app := newTestApp()
+	wsserverA := New(testKeyring(), app, Config{Broker: shared})
+	wsserverB := New(testKeyring(), app, Config{Broker: shared})
+	serverA := httptest.NewServer(http.HandlerFunc(wsserverA.Chat))
+	defer serverA.Close()
+	serverB := httptest.NewServer(http.HandlerFunc(wsserverB.Chat))
+	defer serverB.Close()
+	urlA, urlB := "ws"+serverA.URL[4:], "ws"+serverB.URL[4:]
+
+	// user01 is on instance A, user02 is on instance B
+	conn01A := connect(t, urlA, "user01")
+	defer conn01A.Close()
+	conn02 := connect(t, urlB, "user02")
+	defer conn02.Close()
+	expectTexts(t, conn01A, "[general] user02 joins the room")
+
+	// room messages are delivered across instances
+	assert.NoError(t, writeClientText(conn02, "Hello from B"))
+	expectTexts(t, conn01A, "[general] user02: Hello from B")
+
+	// rooms are shared by instances
+	assert.NoError(t, writeClientText(conn01A, "/join golang"))
+	expectTexts(t, conn01A, "[golang] you are now writing to golang")
+	assert.NoError(t, writeClientText(conn02, "/rooms"))
+	expectTexts(t, conn02, "rooms:\ngeneral (2)\ngolang (1)")
+
+	// user01 opens another session on instance B and gets its rooms
+	conn01B := connect(t, urlB, "user01")
+	defer conn01B.Close()
+	assert.NoError(t, writeClientText(conn02, "/join golang"))
+	expectTexts(t, conn02, "[golang] you are now writing to golang")
+	expectTexts(t, conn01A, "[golang] user02 joins the room")
+	expectTexts(t, conn01B, "[golang] user02 joins the room")
+
+	// direct messages find recipient on another instance, user is online
+	// while any instance has its session
+	assert.NoError(t, writeDirect(conn02, "user01", "Hi"))
+	expectTexts(t, conn01A, "[dm] user02 -> user01: Hi")
+	expectTexts(t, conn01B, "[dm] user02 -> user01: Hi")
+	assert.NoError(t, conn01A.Close())
+	time.Sleep(100 * time.Millisecond)
+	assert.True(t, wsserverA.(*wsServer).isOnline("user01"))
+	assert.NoError(t, writeDirect(conn02, "user01", "Still here?"))
+	expectTexts(t, conn01B, "[dm] user02 -> user01: Still here?")
+
+	// user leaves rooms only after the last session in the cluster
+	assert.NoError(t, conn01B.Close())
+	expectTexts(t, conn02, "[general] user01 leaves the room", "[golang] user01 leaves the room")
+	assert.False(t, wsserverA.(*wsServer).isOnline("user01"))
+}
+
+func TestClusterRevokeTokens(t *testing.T) {
+	shared := broker.NewMemory()
+	app := newTestApp()
+	wsserverA := New(testKeyring(), app, Config{Broker: shared})
+	wsserverB := New(testKeyring(), app, Config{Broker: shared})
+	serverB := httptest.NewServer(http.HandlerFunc(wsserverB.Chat))
+	defer serverB.Close()
+
+	signed, err := testKeyring().Sign(jwt.MapClaims{
+		"nickname": "user01",
+		"exp":      time.Now().Add(time.Hour).Unix(),
+		"sid":      "family",
+	})
+	assert.NoError(t, err)
+	conn, err := getChat("ws"+serverB.URL[4:], []byte(signed))
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	// logout handled by instance A closes session on instance B
+	wsserverA.RevokeTokens("family")
+	code, err := readCloseCode(conn)
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.CloseTokenRevoked, code)
+}
@@ -0,0 +1,217 @@
+package wsserver
+
+import (
+	"console-chat/internal/model"
+	"console-chat/internal/protocol"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// commandPrefix is an optional prefix of command lines
+const commandPrefix = "/"
+
+const helpMessage = `available commands:
+/join <room>     join the room and write to it
+/leave [room]    leave the room, current room by default
+/rooms           list all rooms with member counts
+/history [room]  load older messages of the room, current room by default
+/dm <nickname> <message>  send direct message to the user
+/whois <nickname>         show public profile of the user
+/online [room]   list online users, only members of the room if it is given
+/away            mark yourself as away
+/back            mark yourself as online again
+/presence on|off          get or stop getting status changes of other users
+/help            show this message`
+
+// parseCommand splits command line into command name and its arguments,
+// returns false if command line is empty
+func parseCommand(line string) (string, []string, bool) {
+	fields := strings.Fields(strings.TrimPrefix(line, commandPrefix))
+	if len(fields) == 0 {
+		return "", nil, false
+	}
+	return fields[0], fields[1:], true
+}
+
+// info sends informational system frame to the client session
+func (s *wsServer) info(c *client, roomName, text string) {
+	s.sendToSession(c, protocol.NewSystem(roomName, protocol.EventInfo, text))
+}
+
+// reject sends error frame replying to the client frame with given ID
+func (s *wsServer) reject(c *client, replyTo, code, text string) {
+	s.sendToSession(c, protocol.NewError(replyTo, code, text))
+}
+
+// sendProfile sends public representation of the user to the client session
+func (s *wsServer) sendProfile(c *client, replyTo, nickname string) {
+	usr, err := s.app.GetUser(context.Background(), nickname)
+	if err == model.UserNotFound {
+		s.reject(c, replyTo, protocol.ErrCodeNotFound, "user "+nickname+" doesn't exist")
+		return
+	} else if err != nil {
+		s.reject(c, replyTo, protocol.ErrCodeInternal, "can't get user, please try again later")
+		return
+	}
+
+	public := protocol.NewUser(usr.Nickname, usr.CreatedAt)
+	p := s.presenceOf(usr.Nickname)
+	body := fmt.Sprintf("%s, registered %s", public.Nickname, public.CreatedAt.Format(time.DateOnly))
+	if p.Status != protocol.StatusOffline {
+		body += ", " + p.Status
+	} else if !p.LastSeen.IsZero() {
+		body += ", last seen " + p.LastSeen.UTC().Format("2006-01-02 15:04 MST")
+	}
+	f := protocol.NewSystem("", protocol.EventUser, body)
+	f.ReplyTo = replyTo
+	f.User = &public
+	f.Presence = []protocol.Presence{p}
+	s.sendToSession(c, f)
+}
+
+// handleCommand executes command frame of the client
+func (s *wsServer) handleCommand(c *client, f protocol.Frame) {
+	name, args, ok := parseCommand(f.Body)
+	if !ok {
+		s.reject(c, f.ID, protocol.ErrCodeBadRequest, "empty command, use /help")
+		return
+	}
+
+	switch name {
+	case "join":
+		if len(args) != 1 {
+			s.reject(c, f.ID, protocol.ErrCodeBadRequest, "usage: /join <room>")
+			return
+		}
+		roomName := args[0]
+		if !isValidRoomName(roomName) {
+			s.reject(c, f.ID, protocol.ErrCodeBadRequest, "room name should have length between 1 and 25 including borders and contain only latin letters, digits, _ or -")
+			return
+		}
+		joined, err := s.joinRoom(c.nickname, roomName)
+		if err != nil {
+			s.reject(c, f.ID, protocol.ErrCodeInternal, "can't join the room "+roomName+", please try again later")
+			return
+		} else if joined {
+			s.sendToRoom(roomName, c, protocol.NewSystem(roomName, protocol.EventJoin, c.nickname+" joins the room"))
+			s.resetHistory(c, roomName)
+			s.sendHistory(c, roomName)
+		}
+		c.currentRoom = roomName
+		s.info(c, roomName, "you are now writing to "+roomName)
+
+	case "leave":
+		roomName := c.currentRoom
+		if len(args) == 1 {
+			roomName = args[0]
+		} else if len(args) > 1 {
+			s.reject(c, f.ID, protocol.ErrCodeBadRequest, "usage: /leave [room]")
+			return
+		}
+		if !s.leaveRoom(c.nickname, roomName) {
+			s.reject(c, f.ID, protocol.ErrCodeNotFound, "you are not a member of the room "+roomName)
+			return
+		}
+		s.resetHistory(c, roomName)
+		s.sendToRoom(roomName, c, protocol.NewSystem(roomName, protocol.EventLeave, c.nickname+" leaves the room"))
+		if roomName != c.currentRoom {
+			s.info(c, roomName, "you left the room")
+			return
+		}
+
+		// switching to any other room of the user
+		if rooms := s.userRooms(c.nickname); len(rooms) != 0 {
+			c.currentRoom = rooms[0]
+			s.info(c, rooms[0], "you left "+roomName+" and are now writing to "+rooms[0])
+			return
+		}
+		c.currentRoom = ""
+		s.info(c, "", "you left "+roomName+" and are not in any room, use /join <room>")
+
+	case "rooms":
+		var b strings.Builder
+		b.WriteString("rooms:")
+		for _, r := range s.listRooms() {
+			b.WriteString(fmt.Sprintf("\n%s (%d)", r.name, r.members))
+		}
+		s.info(c, "", b.String())
+
+	case "history":
+		roomName := c.currentRoom
+		if len(args) == 1 {
+			roomName = args[0]
+		} else if len(args) > 1 {
+			s.reject(c, f.ID, protocol.ErrCodeBadRequest, "usage: /history [room]")
+			return
+		}
+		if !s.isRoomMember(c.nickname, roomName) {
+			s.reject(c, f.ID, protocol.ErrCodeNotFound, "you are not a member of the room "+roomName)
+			return
+		}
+		if !s.sendHistory(c, roomName) {
+			s.info(c, roomName, "no more messages")
+		}
+
+	case "dm":
+		// direct message may also be sent as command, text keeps its spacing
+		parts := strings.SplitN(strings.TrimSpace(strings.TrimPrefix(f.Body, commandPrefix)), " ", 3)
+		if len(parts) != 3 || parts[1] == "" || strings.TrimSpace(parts[2]) == "" {
+			s.reject(c, f.ID, protocol.ErrCodeBadRequest, "usage: /dm <nickname> <message>")
+			return
+		}
+		s.handleMessage(c, protocol.Frame{
+			Type: protocol.TypeMessage,
+			ID:   f.ID,
+			To:   parts[1],
+			Body: parts[2],
+		})
+
+	case "whois":
+		if len(args) != 1 {
+			s.reject(c, f.ID, protocol.ErrCodeBadRequest, "usage: /whois <nickname>")
+			return
+		}
+		s.sendProfile(c, f.ID, args[0])
+
+	case "online":
+		roomName := ""
+		if len(args) == 1 {
+			roomName = args[0]
+		} else if len(args) > 1 {
+			s.reject(c, f.ID, protocol.ErrCodeBadRequest, "usage: /online [room]")
+			return
+		}
+		online, err := s.OnlineUsers(context.Background(), roomName)
+		if err != nil {
+			s.reject(c, f.ID, protocol.ErrCodeInternal, "can't get online users, please try again later")
+			return
+		}
+		s.sendToSession(c, onlineFrame(f.ID, roomName, online))
+
+	case "away":
+		s.setStatus(c, f.ID, protocol.StatusAway)
+
+	case "back":
+		s.setStatus(c, f.ID, protocol.StatusOnline)
+
+	case "presence":
+		if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+			s.reject(c, f.ID, protocol.ErrCodeBadRequest, "usage: /presence on|off")
+			return
+		}
+		s.watchPresence(c, args[0] == "on")
+		if args[0] == "on" {
+			s.info(c, "", "you will get status changes of other users")
+		} else {
+			s.info(c, "", "you won't get status changes of other users")
+		}
+
+	case "help":
+		s.info(c, "", helpMessage)
+
+	default:
+		s.reject(c, f.ID, protocol.ErrCodeBadRequest, "unknown command /"+name+", use /help")
+	}
+}
@@ -0,0 +1,51 @@
+package wsserver
+
+import (
+	"console-chat/internal/model"
+	"console-chat/internal/protocol"
+	"context"
+	"time"
+)
+
+// sendDirect delivers direct message of the client to the recipient, or
+// queues it if recipient is offline, and echoes it to other connections of
+// the sender. Returns sent frame or false if the message was rejected
+func (s *wsServer) sendDirect(c *client, f protocol.Frame) (protocol.Frame, bool) {
+	if f.To == c.nickname {
+		s.reject(c, f.ID, protocol.ErrCodeBadRequest, "you can't send direct message to yourself")
+		return protocol.Frame{}, false
+	}
+
+	// checking if recipient exists
+	ctx := context.Background()
+	if _, err := s.app.GetUser(ctx, f.To); err == model.UserNotFound {
+		s.reject(c, f.ID, protocol.ErrCodeNotFound, "user "+f.To+" doesn't exist")
+		return protocol.Frame{}, false
+	} else if err != nil {
+		s.reject(c, f.ID, protocol.ErrCodeInternal, "can't check recipient, please try again later")
+		return protocol.Frame{}, false
+	}
+
+	msg := model.Message{
+		Sender:    c.nickname,
+		To:        f.To,
+		Text:      f.Body,
+		CreatedAt: time.Now().UTC(),
+	}
+	s.typing.reset(typingKey{nickname: c.nickname, to: f.To})
+
+	// direct messages aren't saved to history, so they get random IDs
+	sent := messageFrame(msg)
+	sent.ID = newID()
+	if s.isOnline(f.To) {
+		s.sendToUser(f.To, sent)
+	} else {
+		if _, err := s.app.QueueMessage(ctx, f.To, msg); err != nil {
+			s.reject(c, f.ID, protocol.ErrCodeOffline, "user "+f.To+" is offline")
+			return protocol.Frame{}, false
+		}
+		s.info(c, "", "user "+f.To+" is offline, the message will be delivered when they come back")
+	}
+	s.sendToUserExcept(c.nickname, c, sent)
+	return sent, true
+}
@@ -0,0 +1,108 @@
+package wsserver
+
+import (
+	"console-chat/internal/protocol"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeDirect sends direct message frame to the user
+func writeDirect(conn net.Conn, to, text string) error {
+	data, err := protocol.Encode(protocol.Frame{
+		Type: protocol.TypeMessage,
+		To:   to,
+		Body: text,
+	})
+	if err != nil {
+		return err
+	}
+	return wsutil.WriteClientMessage(conn, ws.OpText, data)
+}
+
+func TestDirectMessages(t *testing.T) {
+	wsserver := New(testKeyring(), newTestApp(), Config{})
+	server := httptest.NewServer(http.HandlerFunc(wsserver.Chat))
+	defer server.Close()
+
+	url := "ws" + server.URL[4:]
+
+	// user01, user02 and user03 join the chat
+	conns := make([]net.Conn, 0, 3)
+	for _, nickname := range []string{"user01", "user02", "user03"} {
+		token, err := codeNicknameInToken(nickname)
+		assert.NoError(t, err)
+		conn, err := getChat(url, token)
+		assert.NoError(t, err)
+		defer conn.Close()
+		conns = append(conns, conn)
+
+		time.Sleep(100 * time.Millisecond)
+	}
+	conn01, conn02, conn03 := conns[0], conns[1], conns[2]
+
+	// skipping join notices
+	for _, text := range []string{"[general] user02 joins the room", "[general] user03 joins the room"} {
+		msg, err := readServerText(conn01)
+		assert.Equal(t, text, msg)
+		assert.NoError(t, err)
+	}
+	msg, err := readServerText(conn02)
+	assert.Equal(t, "[general] user03 joins the room", msg)
+	assert.NoError(t, err)
+
+	// user01 sends direct message to user02
+	err = writeDirect(conn01, "user02", "Ping")
+	assert.NoError(t, err)
+
+	time.Sleep(100 * time.Millisecond)
+
+	msg, err = readServerText(conn02)
+	assert.Equal(t, "[dm] user01 -> user02: Ping", msg)
+	assert.NoError(t, err)
+
+	// user03 doesn't get it and user01 writes to the room, so the next
+	// frame of user03 is the room message
+	err = writeClientText(conn01, "Hello")
+	assert.NoError(t, err)
+
+	time.Sleep(100 * time.Millisecond)
+
+	msg, err = readServerText(conn03)
+	assert.Equal(t, "[general] user01: Hello", msg)
+	assert.NoError(t, err)
+
+	// user01 sends direct message to the user who doesn't exist
+	err = writeDirect(conn01, "user99", "Ping")
+	assert.NoError(t, err)
+
+	time.Sleep(100 * time.Millisecond)
+
+	msg, err = readServerText(conn01)
+	assert.Equal(t, "error: "+protocol.ErrCodeNotFound+": user user99 doesn't exist", msg)
+	assert.NoError(t, err)
+
+	// user03 leaves and user01 sends direct message to offline user03 which is queued
+	_ = conn03.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	msg, err = readServerText(conn01)
+	assert.Equal(t, "[general] user03 leaves the room", msg)
+	assert.NoError(t, err)
+
+	err = writeDirect(conn01, "user03", "Ping")
+	assert.NoError(t, err)
+
+	time.Sleep(100 * time.Millisecond)
+
+	msg, err = readServerText(conn01)
+	assert.Equal(t, "user user03 is offline, the message will be delivered when they come back", msg)
+	assert.NoError(t, err)
+}
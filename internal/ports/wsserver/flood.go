@@ -0,0 +1,172 @@
+package wsserver
+
+import (
+	"console-chat/internal/protocol"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Defaults of flood control
+const (
+	defaultMessageRate      = 1.0
+	defaultMessageBurst     = 5
+	defaultRoomMessageRate  = 10.0
+	defaultRoomMessageBurst = 30
+	defaultMuteAfter        = 10
+	defaultMuteDuration     = time.Minute
+)
+
+// violationWindow is how long throttled messages of the user are remembered
+// to decide if the user should be muted
+const violationWindow = time.Minute
+
+// tokenBucket allows rate messages per second on average and up to burst
+// messages at once
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take spends one token if there is any
+func (b *tokenBucket) take(now time.Time, rate float64, burst int) bool {
+	if b.last.IsZero() {
+		b.tokens = float64(burst)
+	} else if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * rate
+		if b.tokens > float64(burst) {
+			b.tokens = float64(burst)
+		}
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// userFlood is a flood state of the user shared by all sessions
+type userFlood struct {
+	bucket         tokenBucket
+	violations     int
+	firstViolation time.Time
+	mutedUntil     time.Time
+}
+
+// floodVerdict is a decision of flood control about the message
+type floodVerdict int
+
+const (
+	floodAllowed       floodVerdict = iota
+	floodUserThrottled              // user sends messages too fast
+	floodRoomThrottled              // room gets too many messages from all users
+	floodMuted                      // user is muted for sustained flooding
+)
+
+// floodControl limits messages per user and per room with token buckets and
+// mutes users who keep flooding
+type floodControl struct {
+	mu    sync.Mutex
+	cfg   Config
+	users map[string]*userFlood
+	rooms map[string]*tokenBucket
+}
+
+func newFloodControl(cfg Config) *floodControl {
+	return &floodControl{
+		cfg:   cfg,
+		users: make(map[string]*userFlood),
+		rooms: make(map[string]*tokenBucket),
+	}
+}
+
+// check decides if message of the user to the room may be sent, room is
+// empty for direct messages. For muted users it also returns remaining time
+// of the mute
+func (fc *floodControl) check(nickname, roomName string, now time.Time) (floodVerdict, time.Duration) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	u, ok := fc.users[nickname]
+	if !ok {
+		u = &userFlood{}
+		fc.users[nickname] = u
+	}
+	if now.Before(u.mutedUntil) {
+		return floodMuted, u.mutedUntil.Sub(now)
+	}
+
+	if !u.bucket.take(now, fc.cfg.MessageRate, fc.cfg.MessageBurst) {
+		if now.Sub(u.firstViolation) > violationWindow {
+			u.violations = 0
+			u.firstViolation = now
+		}
+		u.violations++
+		if u.violations >= fc.cfg.MuteAfter {
+			u.violations = 0
+			u.mutedUntil = now.Add(fc.cfg.MuteDuration)
+			return floodMuted, fc.cfg.MuteDuration
+		}
+		return floodUserThrottled, 0
+	}
+
+	if roomName == "" {
+		return floodAllowed, 0
+	}
+	r, ok := fc.rooms[roomName]
+	if !ok {
+		r = &tokenBucket{}
+		fc.rooms[roomName] = r
+	}
+	if !r.take(now, fc.cfg.RoomMessageRate, fc.cfg.RoomMessageBurst) {
+		return floodRoomThrottled, 0
+	}
+	return floodAllowed, 0
+}
+
+// forgetUser drops flood state of the user who left the chat, muted users
+// are remembered so that reconnecting doesn't lift the mute
+func (fc *floodControl) forgetUser(nickname string, now time.Time) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	if u, ok := fc.users[nickname]; ok && !now.Before(u.mutedUntil) {
+		delete(fc.users, nickname)
+	}
+}
+
+// forgetRoom drops bucket of the deleted room
+func (fc *floodControl) forgetRoom(roomName string) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	delete(fc.rooms, roomName)
+}
+
+// allowMessage applies flood control to the message frame of the client and
+// warns the client if the message is dropped
+func (s *wsServer) allowMessage(c *client, f protocol.Frame) bool {
+	roomName := ""
+	if f.To == "" {
+		roomName = c.currentRoom
+		if f.Room != "" {
+			roomName = f.Room
+		}
+	}
+
+	verdict, muted := s.flood.check(c.nickname, roomName, time.Now())
+	switch verdict {
+	case floodUserThrottled:
+		s.reject(c, f.ID, protocol.ErrCodeRateLimited, "you are sending messages too fast, the message was dropped; keep flooding and you will be muted")
+	case floodRoomThrottled:
+		s.reject(c, f.ID, protocol.ErrCodeRateLimited, "room "+roomName+" is too busy, the message was dropped")
+	case floodMuted:
+		if muted == s.cfg.MuteDuration {
+			log.Println(c.nickname, "is muted for flooding for", muted)
+		}
+		s.reject(c, f.ID, protocol.ErrCodeMuted, fmt.Sprintf("you are muted for flooding, try again in %s", muted.Round(time.Second)))
+	}
+	return verdict == floodAllowed
+}
@@ -0,0 +1,97 @@
+package wsserver
+
+import (
+	"console-chat/internal/protocol"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFloodControl(t *testing.T) {
+	fc := newFloodControl(Config{
+		MessageRate:      1,
+		MessageBurst:     2,
+		RoomMessageRate:  0.1,
+		RoomMessageBurst: 3,
+		MuteAfter:        3,
+		MuteDuration:     time.Minute,
+	})
+	now := time.Now()
+
+	// burst is allowed at once, then one message per second
+	verdict, _ := fc.check("user01", "general", now)
+	assert.Equal(t, floodAllowed, verdict)
+	verdict, _ = fc.check("user01", "general", now)
+	assert.Equal(t, floodAllowed, verdict)
+	verdict, _ = fc.check("user01", "general", now)
+	assert.Equal(t, floodUserThrottled, verdict)
+	verdict, _ = fc.check("user01", "general", now.Add(time.Second))
+	assert.Equal(t, floodAllowed, verdict)
+
+	// room is limited for all members together
+	verdict, _ = fc.check("user02", "general", now.Add(time.Second))
+	assert.Equal(t, floodRoomThrottled, verdict)
+
+	// direct messages are limited only per user
+	verdict, _ = fc.check("user02", "", now.Add(time.Second))
+	assert.Equal(t, floodAllowed, verdict)
+
+	// sustained flooding mutes the user, reconnecting doesn't lift the mute
+	verdict, _ = fc.check("user01", "", now.Add(time.Second))
+	assert.Equal(t, floodUserThrottled, verdict)
+	verdict, muted := fc.check("user01", "", now.Add(time.Second))
+	assert.Equal(t, floodMuted, verdict)
+	assert.Equal(t, time.Minute, muted)
+	fc.forgetUser("user01", now.Add(2*time.Second))
+	verdict, muted = fc.check("user01", "", now.Add(31*time.Second))
+	assert.Equal(t, floodMuted, verdict)
+	assert.Equal(t, 30*time.Second, muted)
+
+	verdict, _ = fc.check("user01", "", now.Add(2*time.Minute))
+	assert.Equal(t, floodAllowed, verdict)
+
+	// throttled messages are forgotten after a while
+	fc.check("user03", "", now)
+	fc.check("user03", "", now)
+	for i := 0; i < 2; i++ {
+		verdict, _ = fc.check("user03", "", now)
+		assert.Equal(t, floodUserThrottled, verdict)
+	}
+	later := now.Add(violationWindow + time.Second)
+	fc.check("user03", "", later)
+	fc.check("user03", "", later)
+	verdict, _ = fc.check("user03", "", later)
+	assert.Equal(t, floodUserThrottled, verdict)
+}
+
+func TestFloodWarning(t *testing.T) {
+	wsserver := New(testKeyring(), newTestApp(), Config{
+		MessageBurst: 1,
+		MuteAfter:    2,
+	})
+	server := httptest.NewServer(http.HandlerFunc(wsserver.Chat))
+	defer server.Close()
+
+	token, err := codeNicknameInToken("user01")
+	assert.NoError(t, err)
+	conn, err := getChat("ws"+server.URL[4:], token)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	for _, text := range []string{"Ping 1", "Ping 2", "Ping 3", "Ping 4"} {
+		assert.NoError(t, writeClientText(conn, text))
+	}
+
+	// first message is sent, then user is warned and muted
+	f, err := readFrame(conn)
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.ErrCodeRateLimited, f.Error.Code)
+	for i := 0; i < 2; i++ {
+		f, err = readFrame(conn)
+		assert.NoError(t, err)
+		assert.Equal(t, protocol.ErrCodeMuted, f.Error.Code)
+	}
+}
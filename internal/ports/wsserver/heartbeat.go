@@ -0,0 +1,125 @@
+package wsserver
+
+import (
+	"bytes"
+	"console-chat/internal/protocol"
+	"fmt"
+	"io"
+	"log"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+)
+
+const (
+	defaultPingInterval = 25 * time.Second
+	defaultPongWait     = 60 * time.Second
+)
+
+// pingFrame is a compiled empty ping frame sent by server
+var pingFrame = ws.MustCompileFrame(ws.NewPingFrame(nil))
+
+// extendReadDeadline gives the peer another PongWait to send anything
+func (s *wsServer) extendReadDeadline(c *client) {
+	select {
+	case <-c.done:
+		// keeping immediate deadline set by close
+	default:
+		_ = c.conn.SetReadDeadline(time.Now().Add(s.cfg.PongWait))
+	}
+}
+
+// readLoop reads data frames of the session and passes them to ch until the
+// connection fails, is closed by the peer or doesn't answer pings for
+// PongWait. Responses to control frames are queued to the writer of the
+// session instead of being written concurrently with it
+func (s *wsServer) readLoop(c *client, ch chan<- []byte) {
+	var response bytes.Buffer
+	controlHandler := wsutil.ControlFrameHandler(&response, ws.StateServerSide)
+	onControl := func(hdr ws.Header, r io.Reader) error {
+		response.Reset()
+		err := controlHandler(hdr, r)
+		if hdr.OpCode == ws.OpPong {
+			s.extendReadDeadline(c)
+		}
+		if response.Len() != 0 {
+			s.enqueueControl(c, append([]byte(nil), response.Bytes()...))
+		}
+		return err
+	}
+
+	rd := wsutil.Reader{
+		Source:         c.conn,
+		State:          ws.StateServerSide,
+		OnIntermediate: onControl,
+	}
+	s.extendReadDeadline(c)
+	for {
+		hdr, err := rd.NextFrame()
+		if err != nil {
+			s.handleReadError(c, err)
+			return
+		}
+		if hdr.OpCode.IsControl() {
+			if err := onControl(hdr, &rd); err != nil {
+				s.handleReadError(c, err)
+				return
+			}
+			continue
+		}
+		if hdr.OpCode&(ws.OpText|ws.OpBinary) == 0 {
+			if err := rd.Discard(); err != nil {
+				return
+			}
+			continue
+		}
+
+		// messages larger than MaxFrameSize are discarded without buffering
+		tooLarge := hdr.Length > s.cfg.MaxFrameSize
+		var data []byte
+		if !tooLarge {
+			data, err = io.ReadAll(io.LimitReader(&rd, s.cfg.MaxFrameSize+1))
+			tooLarge = int64(len(data)) > s.cfg.MaxFrameSize
+		}
+		if err == nil && tooLarge {
+			_, err = io.Copy(io.Discard, &rd)
+		}
+		if err != nil {
+			s.handleReadError(c, err)
+			return
+		}
+		s.extendReadDeadline(c)
+
+		if tooLarge {
+			s.reject(c, "", protocol.ErrCodeTooLarge, fmt.Sprintf("message is larger than %d bytes and was dropped", s.cfg.MaxFrameSize))
+			continue
+		} else if !utf8.Valid(data) {
+			s.reject(c, "", protocol.ErrCodeInvalidText, "message should be valid UTF-8 text")
+			continue
+		}
+
+		select {
+		case ch <- data:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// handleReadError logs why reading from the session stopped and tells the
+// peer which violated the protocol about it
+func (s *wsServer) handleReadError(c *client, err error) {
+	if _, ok := err.(ws.ProtocolError); ok {
+		s.closeSession(c, protocol.CloseProtocolError, err.Error())
+		return
+	}
+	if netErr, ok := err.(interface{ Timeout() bool }); ok && netErr.Timeout() {
+		select {
+		case <-c.done:
+		default:
+			log.Println("session", c.sessionID, "of", c.nickname, "didn't answer pings and was disconnected")
+		}
+	}
+}
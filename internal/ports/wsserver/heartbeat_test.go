@@ -0,0 +1,86 @@
+package wsserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeartbeat(t *testing.T) {
+	wsserver := New(testKeyring(), newTestApp(), Config{
+		PingInterval: 50 * time.Millisecond,
+		PongWait:     150 * time.Millisecond,
+	})
+	server := httptest.NewServer(http.HandlerFunc(wsserver.Chat))
+	defer server.Close()
+
+	url := "ws" + server.URL[4:]
+
+	// user01 joins the chat and keeps reading, so pings are answered
+	token, err := codeNicknameInToken("user01")
+	assert.NoError(t, err)
+	conn01, err := getChat(url, token)
+	assert.NoError(t, err)
+	defer conn01.Close()
+
+	frames := make(chan string, 10)
+	go func() {
+		for {
+			msg, err := readServerText(conn01)
+			if err != nil {
+				return
+			}
+			frames <- msg
+		}
+	}()
+
+	// user02 joins the chat and never reads, so pings aren't answered
+	token, err = codeNicknameInToken("user02")
+	assert.NoError(t, err)
+	conn02, err := getChat(url, token)
+	assert.NoError(t, err)
+	defer conn02.Close()
+
+	assert.Equal(t, "[general] user02 joins the room", <-frames)
+
+	// user02 is dropped as unresponsive, user01 stays
+	select {
+	case msg := <-frames:
+		assert.Equal(t, "[general] user02 leaves the room", msg)
+	case <-time.After(time.Second):
+		assert.Fail(t, "unresponsive user02 wasn't dropped")
+	}
+
+	time.Sleep(300 * time.Millisecond)
+
+	assert.True(t, wsserver.(*wsServer).isOnline("user01"))
+	assert.False(t, wsserver.(*wsServer).isOnline("user02"))
+}
+
+func TestServerAnswersPing(t *testing.T) {
+	wsserver := New(testKeyring(), newTestApp(), Config{})
+	server := httptest.NewServer(http.HandlerFunc(wsserver.Chat))
+	defer server.Close()
+
+	url := "ws" + server.URL[4:]
+
+	token, err := codeNicknameInToken("user01")
+	assert.NoError(t, err)
+	conn01, err := getChat(url, token)
+	assert.NoError(t, err)
+	defer conn01.Close()
+
+	err = wsutil.WriteClientMessage(conn01, ws.OpPing, []byte("ping"))
+	assert.NoError(t, err)
+
+	msgs, err := wsutil.ReadServerMessage(conn01, nil)
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 1)
+	assert.Equal(t, ws.OpPong, msgs[0].OpCode)
+	assert.Equal(t, "ping", string(msgs[0].Payload))
+}
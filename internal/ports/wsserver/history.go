@@ -0,0 +1,32 @@
+package wsserver
+
+import (
+	"context"
+	"log"
+)
+
+// sendHistory sends to the client next page of the room history which is
+// older than everything already sent, returns false if there are no more
+// messages in the history
+func (s *wsServer) sendHistory(c *client, roomName string) bool {
+	msgs, err := s.app.GetHistory(context.Background(), roomName, c.historyCursors[roomName], s.cfg.HistorySize)
+	if err != nil {
+		log.Println("can't get history of the room", roomName, err.Error())
+		return false
+	}
+	if len(msgs) == 0 {
+		return false
+	}
+
+	for _, msg := range msgs {
+		s.sendToSession(c, messageFrame(msg))
+	}
+	s.markSent(c, roomName, msgs[len(msgs)-1].Seq)
+	c.historyCursors[roomName] = msgs[0].ID
+	return true
+}
+
+// resetHistory forgets which history of the room was sent to the client
+func (s *wsServer) resetHistory(c *client, roomName string) {
+	delete(c.historyCursors, roomName)
+}
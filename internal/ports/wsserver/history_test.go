@@ -0,0 +1,77 @@
+package wsserver
+
+import (
+	"console-chat/internal/app"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistory(t *testing.T) {
+	repo := newMemMessageRepo()
+	wsserver := New(testKeyring(), app.New(newMemUserRepo("user01", "user02"), repo, newMemTokenRepo(), nil, app.Config{}), Config{HistorySize: 2})
+	server := httptest.NewServer(http.HandlerFunc(wsserver.Chat))
+	defer server.Close()
+
+	url := "ws" + server.URL[4:]
+
+	// user01 joins the chat and writes three messages nobody reads
+	token, err := codeNicknameInToken("user01")
+	assert.NoError(t, err)
+	conn01, err := getChat(url, token)
+	assert.NoError(t, err)
+	defer conn01.Close()
+
+	for _, text := range []string{"one", "two", "three"} {
+		err = writeClientText(conn01, text)
+		assert.NoError(t, err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	repo.mu.Lock()
+	assert.Len(t, repo.messages, 3)
+	assert.Equal(t, "user01", repo.messages[0].Sender)
+	assert.Equal(t, "general", repo.messages[0].Room)
+	assert.False(t, repo.messages[0].CreatedAt.IsZero())
+	repo.mu.Unlock()
+
+	// user02 joins the chat and gets last two messages
+	token, err = codeNicknameInToken("user02")
+	assert.NoError(t, err)
+	conn02, err := getChat(url, token)
+	assert.NoError(t, err)
+	defer conn02.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	msg, err := readServerText(conn02)
+	assert.Equal(t, "[general] user01: two", msg)
+	assert.NoError(t, err)
+	msg, err = readServerText(conn02)
+	assert.Equal(t, "[general] user01: three", msg)
+	assert.NoError(t, err)
+
+	// user02 pages further back
+	err = writeClientText(conn02, "/history")
+	assert.NoError(t, err)
+
+	time.Sleep(100 * time.Millisecond)
+
+	msg, err = readServerText(conn02)
+	assert.Equal(t, "[general] user01: one", msg)
+	assert.NoError(t, err)
+
+	// there is nothing older
+	err = writeClientText(conn02, "/history")
+	assert.NoError(t, err)
+
+	time.Sleep(100 * time.Millisecond)
+
+	msg, err = readServerText(conn02)
+	assert.Equal(t, "[general] no more messages", msg)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,226 @@
+package wsserver
+
+import (
+	"console-chat/internal/app"
+	"console-chat/internal/model"
+	"context"
+	"sync"
+	"time"
+)
+
+// memUserRepo is an in-memory app.UserRepo for testing
+type memUserRepo struct {
+	mu    sync.Mutex
+	users map[string]model.User
+}
+
+func newMemUserRepo(nicknames ...string) *memUserRepo {
+	r := &memUserRepo{
+		users: make(map[string]model.User),
+	}
+	for _, nickname := range nicknames {
+		r.users[nickname] = model.User{
+			Nickname:       nickname,
+			HashedPassword: "hash of " + nickname,
+			CreatedAt:      time.Date(2023, 8, 1, 12, 0, 0, 0, time.UTC),
+		}
+	}
+	return r
+}
+
+func (r *memUserRepo) AddUser(_ context.Context, u model.User) (model.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.users[u.Nickname]; ok {
+		return model.User{}, model.UserAlreadyExists
+	}
+	r.users[u.Nickname] = u
+	return u, nil
+}
+
+func (r *memUserRepo) GetUser(_ context.Context, nickname string) (model.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if u, ok := r.users[nickname]; ok {
+		return u, nil
+	}
+	return model.User{}, model.UserNotFound
+}
+
+func (r *memUserRepo) UpdateUser(_ context.Context, u model.User) (model.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.users[u.Nickname]; !ok {
+		return model.User{}, model.UserNotFound
+	}
+	r.users[u.Nickname] = u
+	return u, nil
+}
+
+// chat doesn't sign users in, so sign in throttling is a no-op
+
+func (r *memUserRepo) AddFailedSignIn(_ context.Context, _ string, _ time.Duration) (int64, error) {
+	return 0, nil
+}
+
+func (r *memUserRepo) LockSignIn(_ context.Context, _ string, _ time.Duration) error {
+	return nil
+}
+
+func (r *memUserRepo) GetSignInLock(_ context.Context, _ ...string) (time.Duration, error) {
+	return 0, nil
+}
+
+func (r *memUserRepo) ResetFailedSignIns(_ context.Context, _ ...string) error {
+	return nil
+}
+
+// memMessageRepo is an in-memory app.MessageRepo for testing
+type memMessageRepo struct {
+	mu         sync.Mutex
+	messages   []model.Message
+	sequences  map[string]int64
+	deliveries []model.Delivery
+	delivered  map[int64]bool
+	broken     bool // messages can't be added
+}
+
+func newMemMessageRepo() *memMessageRepo {
+	return &memMessageRepo{
+		sequences: make(map[string]int64),
+		delivered: make(map[int64]bool),
+	}
+}
+
+func (r *memMessageRepo) AddMessage(_ context.Context, msg model.Message) (model.Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.broken {
+		return model.Message{}, model.MessageRepoError
+	}
+	msg.ID = int64(len(r.messages) + 1)
+	r.sequences[msg.Room]++
+	msg.Seq = r.sequences[msg.Room]
+	r.messages = append(r.messages, msg)
+	return msg, nil
+}
+
+func (r *memMessageRepo) GetMessages(_ context.Context, room string, beforeID int64, limit int) ([]model.Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	msgs := make([]model.Message, 0, limit)
+	for i := len(r.messages) - 1; i >= 0 && len(msgs) < limit; i-- {
+		msg := r.messages[i]
+		if msg.Room == room && (beforeID == 0 || msg.ID < beforeID) {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs, nil
+}
+
+func (r *memMessageRepo) GetMessagesAfter(_ context.Context, room string, afterSeq int64, limit int) ([]model.Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	msgs := make([]model.Message, 0, limit)
+	for i := len(r.messages) - 1; i >= 0 && len(msgs) < limit; i-- {
+		msg := r.messages[i]
+		if msg.Room == room && msg.Seq > afterSeq {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs, nil
+}
+
+func (r *memMessageRepo) AddDelivery(_ context.Context, d model.Delivery) (model.Delivery, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	d.ID = int64(len(r.deliveries) + 1)
+	r.deliveries = append(r.deliveries, d)
+	return d, nil
+}
+
+func (r *memMessageRepo) GetDeliveries(_ context.Context, recipient string) ([]model.Delivery, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	deliveries := make([]model.Delivery, 0)
+	for _, d := range r.deliveries {
+		if d.Recipient == recipient && !r.delivered[d.ID] {
+			deliveries = append(deliveries, d)
+		}
+	}
+	return deliveries, nil
+}
+
+func (r *memMessageRepo) SetDelivered(_ context.Context, recipient string, ids []int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, id := range ids {
+		if int(id) <= len(r.deliveries) && r.deliveries[id-1].Recipient == recipient {
+			r.delivered[id] = true
+		}
+	}
+	return nil
+}
+
+// memTokenRepo is an in-memory app.TokenRepo for testing, only revocation
+// list is used by the chat
+type memTokenRepo struct {
+	mu      sync.Mutex
+	revoked map[string]time.Time
+}
+
+func newMemTokenRepo() *memTokenRepo {
+	return &memTokenRepo{
+		revoked: make(map[string]time.Time),
+	}
+}
+
+func (r *memTokenRepo) AddRefreshToken(_ context.Context, t model.RefreshToken) (model.RefreshToken, error) {
+	return t, nil
+}
+
+func (r *memTokenRepo) GetRefreshToken(_ context.Context, _ string) (model.RefreshToken, error) {
+	return model.RefreshToken{}, model.RefreshTokenInvalid
+}
+
+func (r *memTokenRepo) UseRefreshToken(_ context.Context, _ string) error {
+	return model.RefreshTokenInvalid
+}
+
+func (r *memTokenRepo) RevokeTokenFamily(_ context.Context, _ string) error {
+	return nil
+}
+
+func (r *memTokenRepo) AddRevokedToken(_ context.Context, id string, ttl time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.revoked[id] = time.Now().Add(ttl)
+	return nil
+}
+
+func (r *memTokenRepo) IsTokenRevoked(_ context.Context, ids ...string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, id := range ids {
+		if expiresAt, ok := r.revoked[id]; ok && time.Now().Before(expiresAt) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// newTestApp creates app with in-memory repos and registered users
+// user01, user02 and user03
+func newTestApp() app.App {
+	return app.New(newMemUserRepo("user01", "user02", "user03"), newMemMessageRepo(), newMemTokenRepo(), nil, app.Config{})
+}
+
+// testMessage creates room message for testing
+func testMessage() model.Message {
+	return model.Message{
+		ID:     1,
+		Room:   defaultRoom,
+		Sender: "user03",
+		Text:   "Ping",
+	}
+}
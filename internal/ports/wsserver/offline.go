@@ -0,0 +1,88 @@
+package wsserver
+
+import (
+	"console-chat/internal/model"
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+)
+
+// mentionRegexp matches @nickname mentions in messages
+var mentionRegexp = regexp.MustCompile(`@([A-Za-z0-9_]{4,25})`)
+
+// mentions returns unique nicknames mentioned in the text
+func mentions(text string) []string {
+	seen := make(map[string]struct{})
+	nicknames := make([]string, 0)
+	for _, match := range mentionRegexp.FindAllStringSubmatch(text, -1) {
+		if _, ok := seen[match[1]]; ok {
+			continue
+		}
+		seen[match[1]] = struct{}{}
+		nicknames = append(nicknames, match[1])
+	}
+	return nicknames
+}
+
+// queueMentions queues room message for every mentioned user who exists
+// but is offline now
+func (s *wsServer) queueMentions(msg model.Message) {
+	ctx := context.Background()
+	for _, nickname := range mentions(msg.Text) {
+		if nickname == msg.Sender || s.isOnline(nickname) {
+			continue
+		}
+		if _, err := s.app.GetUser(ctx, nickname); err != nil {
+			continue
+		}
+		if _, err := s.app.QueueMessage(ctx, nickname, msg); err != nil {
+			log.Println("can't queue mention of", nickname, err.Error())
+		}
+	}
+}
+
+// deliverQueued sends to the client messages which were queued while the
+// user was offline. Messages are sent in batches which fit into free space
+// of the outbound queue, and only messages written to the connection are
+// marked as delivered, so dropped ones are sent again next time
+func (s *wsServer) deliverQueued(c *client) {
+	ctx := context.Background()
+	deliveries, err := s.app.GetUndelivered(ctx, c.nickname)
+	if err != nil {
+		log.Println("can't get undelivered messages of", c.nickname, err.Error())
+		return
+	} else if len(deliveries) == 0 {
+		return
+	}
+
+	s.info(c, "", fmt.Sprintf("you have %d messages received while you were offline", len(deliveries)))
+	for len(deliveries) != 0 {
+		size := cap(c.out) - len(c.out)
+		if size == 0 {
+			// waiting for the queue to be written
+			if !s.waitQueued(c, c.queued.Load()) {
+				return
+			}
+			continue
+		} else if size > len(deliveries) {
+			size = len(deliveries)
+		}
+
+		dropped := c.dropped.Load()
+		delivered := make([]int64, 0, size)
+		for _, d := range deliveries[:size] {
+			if !s.sendToSession(c, messageFrame(d.Message)) {
+				return
+			}
+			delivered = append(delivered, d.ID)
+		}
+		if !s.waitQueued(c, c.queued.Load()) || c.dropped.Load() != dropped {
+			return
+		}
+		if err := s.app.MarkDelivered(ctx, c.nickname, delivered); err != nil {
+			log.Println("can't mark messages of", c.nickname, "as delivered", err.Error())
+		}
+		deliveries = deliveries[size:]
+	}
+}
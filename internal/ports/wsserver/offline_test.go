@@ -0,0 +1,150 @@
+package wsserver
+
+import (
+	"console-chat/internal/model"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mentionsTest struct {
+	description    string
+	text           string
+	expectedResult []string
+}
+
+func TestMentions(t *testing.T) {
+	tests := []mentionsTest{
+		{
+			description:    "no mentions",
+			text:           "Hello everyone",
+			expectedResult: []string{},
+		},
+		{
+			description:    "several mentions",
+			text:           "@user01, @user02: ping",
+			expectedResult: []string{"user01", "user02"},
+		},
+		{
+			description:    "repeated mention",
+			text:           "@user01 @user01",
+			expectedResult: []string{"user01"},
+		},
+		{
+			description:    "too short nickname",
+			text:           "@abc",
+			expectedResult: []string{},
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expectedResult, mentions(test.text), test.description)
+	}
+}
+
+func TestOfflineDelivery(t *testing.T) {
+	wsserver := New(testKeyring(), newTestApp(), Config{})
+	server := httptest.NewServer(http.HandlerFunc(wsserver.Chat))
+	defer server.Close()
+
+	url := "ws" + server.URL[4:]
+
+	// user01 joins the chat while user02 is offline
+	token, err := codeNicknameInToken("user01")
+	assert.NoError(t, err)
+	conn01, err := getChat(url, token)
+	assert.NoError(t, err)
+	defer conn01.Close()
+
+	// user01 sends direct message and mention to user02
+	err = writeDirect(conn01, "user02", "Ping")
+	assert.NoError(t, err)
+
+	time.Sleep(100 * time.Millisecond)
+
+	msg, err := readServerText(conn01)
+	assert.Equal(t, "user user02 is offline, the message will be delivered when they come back", msg)
+	assert.NoError(t, err)
+
+	err = writeClientText(conn01, "@user02 are you here?")
+	assert.NoError(t, err)
+
+	time.Sleep(100 * time.Millisecond)
+
+	// user02 joins the chat and gets queued messages in order
+	token, err = codeNicknameInToken("user02")
+	assert.NoError(t, err)
+	conn02, err := getChat(url, token)
+	assert.NoError(t, err)
+
+	time.Sleep(100 * time.Millisecond)
+
+	for _, text := range []string{
+		"you have 2 messages received while you were offline",
+		"[dm] user01 -> user02: Ping",
+		"[general] user01: @user02 are you here?",
+	} {
+		msg, err = readServerText(conn02)
+		assert.Equal(t, text, msg)
+		assert.NoError(t, err)
+	}
+	_ = conn02.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	// user02 reconnects and doesn't get delivered messages again
+	conn02, err = getChat(url, token)
+	assert.NoError(t, err)
+	defer conn02.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	err = writeClientText(conn01, "Pong")
+	assert.NoError(t, err)
+
+	time.Sleep(100 * time.Millisecond)
+
+	msg, err = readServerText(conn02)
+	assert.Equal(t, "[general] user01: Pong", msg)
+	assert.NoError(t, err)
+}
+
+func TestDeliverQueuedInBatches(t *testing.T) {
+	a := newTestApp()
+	s := New(testKeyring(), a, Config{SendQueueSize: 2, OverflowPolicy: DropOldest}).(*wsServer)
+	c, peer := newTestSession(s, "user02")
+	defer peer.Close()
+	go s.writeLoop(c)
+
+	// there are more queued messages than the outbound queue can hold
+	ctx := context.Background()
+	for i := 1; i <= 5; i++ {
+		_, err := a.QueueMessage(ctx, "user02", model.Message{Sender: "user01", To: "user02", Text: fmt.Sprint(i)})
+		assert.NoError(t, err)
+	}
+
+	go s.deliverQueued(c)
+
+	// every message is sent and nothing is dropped
+	expected := []string{"you have 5 messages received while you were offline"}
+	for i := 1; i <= 5; i++ {
+		expected = append(expected, fmt.Sprintf("[dm] user01 -> user02: %d", i))
+	}
+	for _, text := range expected {
+		msg, err := readServerText(peer)
+		assert.Equal(t, text, msg)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, uint64(0), s.QueueStats().DroppedFrames)
+
+	// all of them are marked as delivered
+	assert.Eventually(t, func() bool {
+		deliveries, err := a.GetUndelivered(ctx, "user02")
+		return err == nil && len(deliveries) == 0
+	}, time.Second, 10*time.Millisecond)
+}
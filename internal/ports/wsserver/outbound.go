@@ -0,0 +1,186 @@
+package wsserver
+
+import (
+	"console-chat/internal/protocol"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/gobwas/ws"
+)
+
+// OverflowPolicy is what happens when outbound queue of the session is full
+type OverflowPolicy string
+
+const (
+	// DropOldest drops the oldest queued frame to make room for the new one
+	DropOldest OverflowPolicy = "drop_oldest"
+
+	// Disconnect closes the session which can't keep up with the chat
+	Disconnect OverflowPolicy = "disconnect"
+)
+
+const (
+	defaultSendQueueSize = 256
+	defaultWriteTimeout  = 10 * time.Second
+
+	// controlQueueSize is a capacity of control frames queue of every session
+	controlQueueSize = 8
+)
+
+// QueueStats are metrics of outbound queues of all sessions
+type QueueStats struct {
+	Sessions        int    `json:"sessions"`
+	QueuedFrames    int    `json:"queued_frames"`
+	MaxQueueDepth   int    `json:"max_queue_depth"`
+	QueueCapacity   int    `json:"queue_capacity"`
+	DroppedFrames   uint64 `json:"dropped_frames"`
+	SlowDisconnects uint64 `json:"slow_disconnects"`
+}
+
+// queueCounters are cumulative counters of outbound queue events
+type queueCounters struct {
+	droppedFrames   atomic.Uint64
+	slowDisconnects atomic.Uint64
+}
+
+// enqueue puts encoded frame to the outbound queue of the session without
+// blocking, returns false if the session is closed or was disconnected
+// because of the overflow
+func (s *wsServer) enqueue(c *client, data []byte) bool {
+	for {
+		select {
+		case <-c.done:
+			return false
+		case c.out <- data:
+			c.queued.Add(1)
+			return true
+		default:
+		}
+
+		// queue is full
+		switch s.cfg.OverflowPolicy {
+		case Disconnect:
+			log.Println("session", c.sessionID, "of", c.nickname, "is too slow and was disconnected")
+			s.counters.slowDisconnects.Add(1)
+			s.closeSession(c, protocol.CloseTooSlow, "session is too slow")
+			return false
+		default:
+			select {
+			case <-c.out:
+				c.dropped.Add(1)
+				c.signalProgress()
+				s.counters.droppedFrames.Add(1)
+			default:
+			}
+		}
+	}
+}
+
+// waitQueued blocks until the first n frames ever put to the outbound queue
+// of the session have left it, returns false if the session was closed before
+func (s *wsServer) waitQueued(c *client, n uint64) bool {
+	for c.written.Load()+c.dropped.Load() < n {
+		select {
+		case <-c.done:
+			return false
+		case <-c.progress:
+		}
+	}
+	return true
+}
+
+// enqueueControl puts raw control frame (pong or close) to the session,
+// control frames are written before queued data frames and are never dropped
+func (s *wsServer) enqueueControl(c *client, frame []byte) {
+	select {
+	case <-c.done:
+	case c.control <- frame:
+	default:
+		// peer floods with control frames and doesn't read responses
+		c.close()
+	}
+}
+
+// writeLoop writes queued frames of the session to its connection and pings
+// the peer until the session is closed. Every write has a deadline so a stuck
+// peer is closed instead of blocking forever. Connection is closed when the
+// loop stops, after pending control frames are flushed
+func (s *wsServer) writeLoop(c *client) {
+	pingTicker := time.NewTicker(s.cfg.PingInterval)
+	defer func() {
+		pingTicker.Stop()
+		s.flushControl(c)
+		_ = c.conn.Close()
+	}()
+
+	for {
+		var err error
+		select {
+		case <-c.done:
+			return
+		case frame := <-c.control:
+			err = s.writeRaw(c, frame)
+		case data := <-c.out:
+			if err = s.writeRaw(c, ws.MustCompileFrame(ws.NewTextFrame(data))); err == nil {
+				c.written.Add(1)
+				c.signalProgress()
+			}
+		case <-pingTicker.C:
+			err = s.writeRaw(c, pingFrame)
+		}
+		if err != nil {
+			log.Println("session", c.sessionID, "of", c.nickname, "was disconnected from the chat:", err.Error())
+			c.close()
+			return
+		}
+	}
+}
+
+// writeRaw writes compiled websocket frame to the connection with deadline
+func (s *wsServer) writeRaw(c *client, frame []byte) error {
+	if err := c.conn.SetWriteDeadline(time.Now().Add(s.cfg.WriteTimeout)); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// flushControl writes control frames left in the queue of the closed session,
+// e.g. response to the peer's close frame
+func (s *wsServer) flushControl(c *client) {
+	for {
+		select {
+		case frame := <-c.control:
+			if err := s.writeRaw(c, frame); err != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// QueueStats returns current metrics of outbound queues
+func (s *wsServer) QueueStats() QueueStats {
+	stats := QueueStats{
+		QueueCapacity:   s.cfg.SendQueueSize,
+		DroppedFrames:   s.counters.droppedFrames.Load(),
+		SlowDisconnects: s.counters.slowDisconnects.Load(),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, sessions := range s.connections {
+		for _, c := range sessions {
+			depth := len(c.out)
+			stats.Sessions++
+			stats.QueuedFrames += depth
+			if depth > stats.MaxQueueDepth {
+				stats.MaxQueueDepth = depth
+			}
+		}
+	}
+	return stats
+}
@@ -0,0 +1,122 @@
+package wsserver
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestSession creates session of the server over in-memory connection,
+// returns the session and the peer side of the connection
+func newTestSession(s *wsServer, nickname string) (*client, net.Conn) {
+	conn, peer := net.Pipe()
+	c := newClient(newID(), nickname, conn, s.cfg.SendQueueSize)
+	s.addSession(c)
+	return c, peer
+}
+
+func isClosed(c *client) bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestEnqueueDropOldest(t *testing.T) {
+	s := New(testKeyring(), newTestApp(), Config{SendQueueSize: 2, OverflowPolicy: DropOldest}).(*wsServer)
+	c, peer := newTestSession(s, "user01")
+	defer peer.Close()
+
+	for _, frame := range []string{"1", "2", "3"} {
+		assert.True(t, s.enqueue(c, []byte(frame)))
+	}
+
+	assert.Equal(t, "2", string(<-c.out))
+	assert.Equal(t, "3", string(<-c.out))
+	assert.False(t, isClosed(c))
+	assert.Equal(t, uint64(1), s.QueueStats().DroppedFrames)
+}
+
+func TestEnqueueDisconnect(t *testing.T) {
+	s := New(testKeyring(), newTestApp(), Config{SendQueueSize: 2, OverflowPolicy: Disconnect}).(*wsServer)
+	c, peer := newTestSession(s, "user01")
+	defer peer.Close()
+
+	assert.True(t, s.enqueue(c, []byte("1")))
+	assert.True(t, s.enqueue(c, []byte("2")))
+
+	stats := s.QueueStats()
+	assert.Equal(t, 1, stats.Sessions)
+	assert.Equal(t, 2, stats.QueuedFrames)
+	assert.Equal(t, 2, stats.MaxQueueDepth)
+
+	assert.False(t, s.enqueue(c, []byte("3")))
+	assert.True(t, isClosed(c))
+	assert.Equal(t, uint64(1), s.QueueStats().SlowDisconnects)
+
+	// closed session doesn't accept frames any more
+	assert.False(t, s.enqueue(c, []byte("4")))
+}
+
+func TestWriteLoopDeadline(t *testing.T) {
+	s := New(testKeyring(), newTestApp(), Config{WriteTimeout: 50 * time.Millisecond}).(*wsServer)
+	c, peer := newTestSession(s, "user01")
+	defer peer.Close()
+	go s.writeLoop(c)
+
+	// peer never reads, so the write can't finish before the deadline
+	assert.True(t, s.enqueue(c, []byte("Ping")))
+
+	time.Sleep(200 * time.Millisecond)
+
+	assert.True(t, isClosed(c))
+}
+
+func TestSlowSessionDoesNotBlockOthers(t *testing.T) {
+	s := New(testKeyring(), newTestApp(), Config{SendQueueSize: 4}).(*wsServer)
+	slow, slowPeer := newTestSession(s, "user01")
+	defer slowPeer.Close()
+	fast, fastPeer := newTestSession(s, "user02")
+	defer fastPeer.Close()
+	s.joinRoom("user01", defaultRoom)
+	s.joinRoom("user02", defaultRoom)
+	go s.writeLoop(slow)
+	go s.writeLoop(fast)
+
+	// reading everything the fast session gets
+	received := make(chan struct{}, 100)
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			if _, err := fastPeer.Read(buf); err != nil {
+				return
+			}
+			received <- struct{}{}
+		}
+	}()
+
+	// sending to the room doesn't block although slow peer never reads
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 20; i++ {
+			s.sendToRoom(defaultRoom, nil, messageFrame(testMessage()))
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		assert.Fail(t, "sending to the room is blocked by the slow session")
+	}
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		assert.Fail(t, "fast session got nothing")
+	}
+	assert.LessOrEqual(t, s.QueueStats().MaxQueueDepth, 4)
+}
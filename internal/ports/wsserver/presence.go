@@ -0,0 +1,125 @@
+package wsserver
+
+import (
+	"console-chat/internal/ports/broker"
+	"console-chat/internal/protocol"
+	"context"
+	"log"
+	"strings"
+)
+
+// OnlineUsers returns presence of users which are online in the cluster,
+// only members of the room if room isn't empty
+func (s *wsServer) OnlineUsers(ctx context.Context, room string) ([]protocol.Presence, error) {
+	var nicknames []string
+	var err error
+	if room == "" {
+		nicknames, err = s.broker.OnlineUsers(ctx)
+	} else {
+		nicknames, err = s.broker.RoomMembers(ctx, room)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	online := make([]protocol.Presence, 0, len(nicknames))
+	if len(nicknames) == 0 {
+		return online, nil
+	}
+	presence, err := s.broker.Presence(ctx, nicknames...)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range presence {
+		if p.Status != protocol.StatusOffline {
+			online = append(online, p)
+		}
+	}
+	return online, nil
+}
+
+// presenceOf returns presence of the user, it is offline if broker can't tell
+func (s *wsServer) presenceOf(nickname string) protocol.Presence {
+	presence, err := s.broker.Presence(context.Background(), nickname)
+	if err != nil || len(presence) != 1 {
+		if err != nil {
+			log.Println("can't get presence of", nickname, err.Error())
+		}
+		return protocol.Presence{Nickname: nickname, Status: protocol.StatusOffline}
+	}
+	return presence[0]
+}
+
+// setStatus changes presence status of the user of the session and notifies
+// watchers about it
+func (s *wsServer) setStatus(c *client, replyTo, status string) {
+	if err := s.broker.SetStatus(context.Background(), c.nickname, status); err != nil {
+		log.Println("can't set status of", c.nickname, err.Error())
+		s.reject(c, replyTo, protocol.ErrCodeInternal, "can't change status, please try again later")
+		return
+	}
+	s.notifyPresence(c.nickname)
+	s.info(c, "", "your status is "+status)
+}
+
+// notifyPresence sends current presence of the user to sessions watching
+// presence in the cluster
+func (s *wsServer) notifyPresence(nickname string) {
+	p := s.presenceOf(nickname)
+	f := protocol.NewSystem("", protocol.EventPresence, nickname+" is "+p.Status)
+	f.Presence = []protocol.Presence{p}
+	s.deliverPresence(f)
+	s.broadcast(broker.Event{Kind: broker.KindPresence, User: nickname, Frame: &f})
+}
+
+// deliverPresence sends presence frame to sessions watching presence on this
+// instance except sessions of the user whose presence has changed
+func (s *wsServer) deliverPresence(f protocol.Frame) {
+	data, err := protocol.Encode(f)
+	if err != nil {
+		log.Println("can't encode frame:", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for nickname, sessions := range s.connections {
+		if len(f.Presence) != 0 && f.Presence[0].Nickname == nickname {
+			continue
+		}
+		for _, c := range sessions {
+			if c.watchPresence {
+				s.deliver(c, f, data)
+			}
+		}
+	}
+}
+
+// watchPresence subscribes the session to presence changes or unsubscribes it
+func (s *wsServer) watchPresence(c *client, watch bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c.watchPresence = watch
+}
+
+// onlineFrame lists online users as a reply to the client frame
+func onlineFrame(replyTo, room string, online []protocol.Presence) protocol.Frame {
+	var b strings.Builder
+	b.WriteString("online users:")
+	if len(online) == 0 {
+		b.WriteString(" nobody")
+	}
+	for _, p := range online {
+		b.WriteString("\n" + p.Nickname)
+		if p.Status != protocol.StatusOnline {
+			b.WriteString(" (" + p.Status + ")")
+		}
+	}
+
+	f := protocol.NewSystem(room, protocol.EventOnline, b.String())
+	f.ReplyTo = replyTo
+	f.Presence = online
+	return f
+}
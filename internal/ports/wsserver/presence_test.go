@@ -0,0 +1,81 @@
+package wsserver
+
+import (
+	"console-chat/internal/protocol"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPresence(t *testing.T) {
+	wsserver := New(testKeyring(), newTestApp(), Config{})
+	server := httptest.NewServer(http.HandlerFunc(wsserver.Chat))
+	defer server.Close()
+	url := "ws" + server.URL[4:]
+
+	conn01 := connect(t, url, "user01")
+	defer conn01.Close()
+	assert.NoError(t, writeClientText(conn01, "/presence on"))
+	expectTexts(t, conn01, "you will get status changes of other users")
+
+	// watcher gets status changes of other users
+	conn02 := connect(t, url, "user02")
+	defer conn02.Close()
+	expectTexts(t, conn01, "[general] user02 joins the room", "user02 is online")
+	assert.NoError(t, writeClientText(conn02, "/away"))
+	expectTexts(t, conn02, "your status is away")
+	f, err := readFrame(conn01)
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.EventPresence, f.Event)
+	assert.Equal(t, "user02 is away", f.Body)
+	assert.Equal(t, "user02", f.Presence[0].Nickname)
+	assert.Equal(t, protocol.StatusAway, f.Presence[0].Status)
+
+	assert.NoError(t, writeClientText(conn02, "/join golang"))
+	expectTexts(t, conn02, "[golang] you are now writing to golang")
+	assert.NoError(t, writeClientText(conn01, "/online"))
+	f, err = readFrame(conn01)
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.EventOnline, f.Event)
+	assert.Equal(t, "online users:\nuser01\nuser02 (away)", f.Body)
+	assert.Len(t, f.Presence, 2)
+	assert.NoError(t, writeClientText(conn01, "/online golang"))
+	expectTexts(t, conn01, "[golang] online users:\nuser02 (away)")
+	assert.NoError(t, writeClientText(conn01, "/online nowhere"))
+	expectTexts(t, conn01, "[nowhere] online users: nobody")
+
+	// user whose status changed doesn't get its own event
+	assert.NoError(t, writeClientText(conn02, "/presence on"))
+	expectTexts(t, conn02, "you will get status changes of other users")
+	assert.NoError(t, writeClientText(conn02, "/back"))
+	expectTexts(t, conn02, "your status is online")
+	expectTexts(t, conn01, "user02 is online")
+
+	// offline user is shown with last seen time
+	assert.NoError(t, conn02.Close())
+	expectTexts(t, conn01, "[general] user02 leaves the room", "user02 is offline")
+	online, err := wsserver.OnlineUsers(context.Background(), "")
+	assert.NoError(t, err)
+	if assert.Len(t, online, 1) {
+		assert.Equal(t, "user01", online[0].Nickname)
+	}
+	assert.NoError(t, writeClientText(conn01, "/whois user02"))
+	f, err = readFrame(conn01)
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.StatusOffline, f.Presence[0].Status)
+	assert.WithinDuration(t, time.Now(), f.Presence[0].LastSeen, time.Second)
+	assert.Contains(t, f.Body, "user02, registered 2023-08-01, last seen ")
+
+	// unsubscribed session doesn't get events
+	assert.NoError(t, writeClientText(conn01, "/presence off"))
+	expectTexts(t, conn01, "you won't get status changes of other users")
+	conn03 := connect(t, url, "user03")
+	defer conn03.Close()
+	expectTexts(t, conn01, "[general] user03 joins the room")
+	assert.NoError(t, writeClientText(conn01, "/whois user03"))
+	expectTexts(t, conn01, "user03, registered 2023-08-01, online")
+}
@@ -0,0 +1,63 @@
+package wsserver
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+// defaultReplayLimit is how many missed messages of every room are sent to
+// the resumed session if limit isn't configured
+const defaultReplayLimit = 500
+
+// resumeRequest is sent by the client which reconnects after losing the
+// connection
+type resumeRequest struct {
+	lastSeqs map[string]int64 // room -> the last sequence number seen by the client
+}
+
+// lastSeq returns the last sequence number of the room seen by the client,
+// 0 means that client hasn't seen any message of the room
+func (r *resumeRequest) lastSeq(roomName string) int64 {
+	if r == nil {
+		return 0
+	}
+	return r.lastSeqs[roomName]
+}
+
+// restoreRooms returns rooms the user was a member of when the last session
+// was closed, or the default room if they weren't saved or have expired
+func (s *wsServer) restoreRooms(nickname string) []string {
+	rooms, err := s.broker.TakeRooms(context.Background(), nickname)
+	if err != nil {
+		log.Println("can't get saved rooms of", nickname, err.Error())
+	}
+	if len(rooms) == 0 {
+		return []string{defaultRoom}
+	}
+	return rooms
+}
+
+// replayMissed sends to the resumed session messages of the room which were
+// sent after the message with sequence number lastSeq. If more messages were
+// missed than ReplayLimit, only the latest ones are sent and the client is
+// told to load the rest with /history
+func (s *wsServer) replayMissed(c *client, roomName string, lastSeq int64) {
+	msgs, err := s.app.GetMissed(context.Background(), roomName, lastSeq, s.cfg.ReplayLimit)
+	if err != nil {
+		log.Println("can't get missed messages of the room", roomName, err.Error())
+		return
+	}
+	if len(msgs) == 0 {
+		return
+	}
+
+	if skipped := msgs[0].Seq - lastSeq - 1; skipped > 0 {
+		s.info(c, roomName, fmt.Sprintf("%d earlier missed messages weren't sent, use /history to load them", skipped))
+	}
+	for _, msg := range msgs {
+		s.sendToSession(c, messageFrame(msg))
+	}
+	s.markSent(c, roomName, msgs[len(msgs)-1].Seq)
+	c.historyCursors[roomName] = msgs[0].ID
+}
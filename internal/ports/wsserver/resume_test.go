@@ -0,0 +1,124 @@
+package wsserver
+
+import (
+	"console-chat/internal/app"
+	"console-chat/internal/protocol"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// resumeChat connects to the chat resuming the lost session of the user who
+// has seen messages of rooms up to lastSeqs
+func resumeChat(t *testing.T, url, nickname string, lastSeqs map[string]int64) net.Conn {
+	token, err := codeNicknameInToken(nickname)
+	assert.NoError(t, err)
+	conn, _, _, err := ws.DefaultDialer.Dial(context.Background(), url)
+	assert.NoError(t, err)
+	data, _ := protocol.Encode(protocol.Frame{
+		Type:     protocol.TypeAuth,
+		Body:     string(token),
+		Versions: protocol.SupportedVersions,
+		Event:    protocol.AuthResume,
+		Resume:   lastSeqs,
+	})
+	assert.NoError(t, wsutil.WriteClientMessage(conn, ws.OpText, data))
+	f, err := readFrame(conn)
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.TypeAck, f.Type)
+	time.Sleep(100 * time.Millisecond)
+	return conn
+}
+
+func TestResume(t *testing.T) {
+	repo := newMemMessageRepo()
+	wsserver := New(testKeyring(), app.New(newMemUserRepo("user01", "user02"), repo, newMemTokenRepo(), nil, app.Config{}), Config{HistorySize: 2, ReplayLimit: 3})
+	server := httptest.NewServer(http.HandlerFunc(wsserver.Chat))
+	defer server.Close()
+	url := "ws" + server.URL[4:]
+
+	conn01 := connect(t, url, "user01")
+	defer conn01.Close()
+	conn02 := connect(t, url, "user02")
+	expectTexts(t, conn01, "[general] user02 joins the room")
+	assert.NoError(t, writeClientText(conn01, "/join golang"))
+	expectTexts(t, conn01, "[golang] you are now writing to golang")
+	assert.NoError(t, writeClientText(conn02, "/join golang"))
+	expectTexts(t, conn02, "[golang] you are now writing to golang")
+	expectTexts(t, conn01, "[golang] user02 joins the room")
+
+	// messages of the room are numbered
+	assert.NoError(t, writeClientText(conn01, "one"))
+	f, err := readFrame(conn02)
+	assert.NoError(t, err)
+	assert.Equal(t, "one", f.Body)
+	assert.Equal(t, int64(1), f.Seq)
+
+	// user02 loses the connection and misses messages
+	assert.NoError(t, conn02.Close())
+	expectTexts(t, conn01, "[general] user02 leaves the room", "[golang] user02 leaves the room")
+	for _, text := range []string{"two", "three", "four", "five"} {
+		assert.NoError(t, writeClientText(conn01, text))
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// resumed session gets back to the rooms and gets the latest missed
+	// messages, earlier ones are left for history
+	conn02 = resumeChat(t, url, "user02", map[string]int64{"golang": 1})
+	defer conn02.Close()
+	expectTexts(t, conn01, "[general] user02 joins the room", "[golang] user02 joins the room")
+	expectTexts(t, conn02,
+		"[golang] 1 earlier missed messages weren't sent, use /history to load them",
+		"[golang] user01: three",
+		"[golang] user01: four",
+		"[golang] user01: five",
+	)
+	assert.NoError(t, writeClientText(conn02, "/history golang"))
+	expectTexts(t, conn02, "[golang] user01: one", "[golang] user01: two")
+
+	// the default room is current again
+	assert.NoError(t, writeClientText(conn02, "Back"))
+	expectTexts(t, conn01, "[general] user02: Back")
+
+	// another session of the user who is still in the chat gets history of
+	// rooms which aren't resumed
+	conn02B := resumeChat(t, url, "user02", map[string]int64{"golang": 4})
+	defer conn02B.Close()
+	expectTexts(t, conn02B, "[general] user02: Back")
+	f, err = readFrame(conn02B)
+	assert.NoError(t, err)
+	assert.Equal(t, "five", f.Body)
+	assert.Equal(t, int64(5), f.Seq)
+}
+
+func TestHeldFrames(t *testing.T) {
+	s := New(testKeyring(), newTestApp(), Config{}).(*wsServer)
+	c, peer := newTestSession(s, "user01")
+	defer peer.Close()
+	c.holding, c.sentSeqs = true, make(map[string]int64)
+	s.joinRoom("user01", "golang")
+
+	// messages sent while the session gets history are held
+	for seq := int64(1); seq <= 3; seq++ {
+		s.deliverToRoom("golang", "", protocol.Frame{Type: protocol.TypeMessage, Room: "golang", Seq: seq})
+	}
+	assert.Len(t, c.out, 0)
+
+	// messages which were sent from history aren't sent again
+	s.markSent(c, "golang", 2)
+	s.releaseHeld(c)
+	assert.Len(t, c.out, 1)
+	f, err := protocol.Decode(<-c.out)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), f.Seq)
+
+	s.deliverToRoom("golang", "", protocol.Frame{Type: protocol.TypeMessage, Room: "golang", Seq: 4})
+	assert.Len(t, c.out, 1)
+}
@@ -0,0 +1,183 @@
+package wsserver
+
+import (
+	"console-chat/internal/ports/broker"
+	"context"
+	"log"
+	"sort"
+)
+
+// defaultRoom is a room every user joins after connecting to the chat
+const defaultRoom = "general"
+
+const allowedRoomSymbols = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-"
+
+// room is a named group of users, messages are sent only to its members.
+// Rooms of the server keep only members having sessions on this instance
+type room struct {
+	name    string
+	members map[string]struct{}
+}
+
+// roomInfo is a short description of the room for listing
+type roomInfo struct {
+	name    string
+	members int
+}
+
+// isValidRoomName checks if room name has valid len and contains only
+// allowed symbols
+func isValidRoomName(name string) bool {
+	if !(len(name) >= 1 && len(name) <= 25) {
+		return false
+	}
+	for _, c := range name {
+		allowed := false
+		for _, s := range allowedRoomSymbols {
+			if c == s {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	return true
+}
+
+// joinRoom adds user to the room in the cluster, returns false if user is
+// already a member of the room and error if user couldn't be added
+func (s *wsServer) joinRoom(nickname, roomName string) (bool, error) {
+	joined, err := s.broker.JoinRoom(context.Background(), roomName, nickname)
+	if err != nil {
+		log.Println("can't add", nickname, "to the room", roomName, err.Error())
+		return false, err
+	}
+	s.cacheMembership(nickname, roomName, true)
+	if joined {
+		s.broadcast(broker.Event{Kind: broker.KindJoin, Room: roomName, User: nickname})
+	}
+	return joined, nil
+}
+
+// leaveRoom removes user from the room in the cluster, returns false if user
+// wasn't a member of the room
+func (s *wsServer) leaveRoom(nickname, roomName string) bool {
+	left, err := s.broker.LeaveRoom(context.Background(), roomName, nickname)
+	if err != nil {
+		log.Println("can't remove", nickname, "from the room", roomName, err.Error())
+		return false
+	}
+	s.cacheMembership(nickname, roomName, false)
+	if left {
+		s.broadcast(broker.Event{Kind: broker.KindLeave, Room: roomName, User: nickname})
+	}
+	return left
+}
+
+// cacheMembership updates local rooms which keep members having sessions on
+// this instance, rooms without such members are dropped
+func (s *wsServer) cacheMembership(nickname, roomName string, member bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.rooms[roomName]
+	if member {
+		if len(s.connections[nickname]) == 0 {
+			return
+		}
+		if !ok {
+			r = &room{
+				name:    roomName,
+				members: make(map[string]struct{}),
+			}
+			s.rooms[roomName] = r
+		}
+		r.members[nickname] = struct{}{}
+		return
+	}
+
+	if !ok {
+		return
+	}
+	delete(r.members, nickname)
+	if len(r.members) == 0 {
+		delete(s.rooms, roomName)
+		s.flood.forgetRoom(roomName)
+	}
+}
+
+// loadRooms caches rooms of the user who has sessions on other instances
+// and opened the first session on this one
+func (s *wsServer) loadRooms(nickname string) {
+	rooms, err := s.broker.UserRooms(context.Background(), nickname)
+	if err != nil {
+		log.Println("can't get rooms of", nickname, err.Error())
+		return
+	}
+	for _, roomName := range rooms {
+		s.cacheMembership(nickname, roomName, true)
+	}
+}
+
+// forgetRooms drops cached rooms of the user who closed the last session on
+// this instance but stays in the chat on other instances
+func (s *wsServer) forgetRooms(nickname string) {
+	for _, roomName := range s.userRooms(nickname) {
+		s.cacheMembership(nickname, roomName, false)
+	}
+}
+
+// isRoomMember checks if user is a member of the room
+func (s *wsServer) isRoomMember(nickname, roomName string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.rooms[roomName]
+	if !ok {
+		return false
+	}
+	_, ok = r.members[nickname]
+	return ok
+}
+
+// userRooms returns sorted names of the rooms user is a member of
+func (s *wsServer) userRooms(nickname string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := make([]string, 0)
+	for name, r := range s.rooms {
+		if _, ok := r.members[nickname]; ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// listRooms returns all rooms of the cluster with their member counts sorted
+// by name, the default room is listed even if it is empty
+func (s *wsServer) listRooms() []roomInfo {
+	counts, err := s.broker.Rooms(context.Background())
+	if err != nil {
+		log.Println("can't list rooms:", err.Error())
+		counts = make(map[string]int)
+	}
+	if _, ok := counts[defaultRoom]; !ok {
+		counts[defaultRoom] = 0
+	}
+
+	rooms := make([]roomInfo, 0, len(counts))
+	for name, members := range counts {
+		rooms = append(rooms, roomInfo{
+			name:    name,
+			members: members,
+		})
+	}
+	sort.Slice(rooms, func(i, j int) bool {
+		return rooms[i].name < rooms[j].name
+	})
+	return rooms
+}
@@ -0,0 +1,128 @@
+package wsserver
+
+import (
+	"console-chat/internal/model"
+	"console-chat/internal/protocol"
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Defaults of message limits
+const (
+	defaultMaxFrameSize     = 64 << 10
+	defaultMaxMessageLength = 4096
+)
+
+const (
+	esc = '\x1b'
+	bel = '\x07'
+)
+
+// sanitizeText removes terminal escape sequences, other control characters
+// and bidirectional overrides from the text so that it can't repaint or
+// reorder terminals of other users. Tabs and line breaks become spaces
+func sanitizeText(text string) string {
+	runes := []rune(text)
+	var b strings.Builder
+	b.Grow(len(text))
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == esc && i+1 < len(runes):
+			i = skipEscape(runes, i+1)
+		case r == '\u009b': // CSI
+			i = skipCSI(runes, i+1)
+		case r == '\u0090' || r == '\u0098' || r == '\u009d' || r == '\u009e' || r == '\u009f': // DCS, SOS, OSC, PM, APC
+			i = skipString(runes, i+1)
+		case r == '\t' || r == '\n' || r == '\r':
+			b.WriteByte(' ')
+		case unicode.IsControl(r) || isBidiControl(r):
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// skipEscape skips escape sequence which body starts at i, returns index of
+// its last rune
+func skipEscape(runes []rune, i int) int {
+	switch runes[i] {
+	case '[':
+		return skipCSI(runes, i+1)
+	case ']', 'P', 'X', '^', '_':
+		return skipString(runes, i+1)
+	}
+	// intermediate bytes and the final one
+	for i < len(runes) && runes[i] >= 0x20 && runes[i] <= 0x2f {
+		i++
+	}
+	if i < len(runes) && runes[i] >= 0x30 && runes[i] <= 0x7e {
+		return i
+	}
+	return i - 1
+}
+
+// skipCSI skips parameters of control sequence starting at i up to its final
+// byte, returns index of the final byte
+func skipCSI(runes []rune, i int) int {
+	for ; i < len(runes); i++ {
+		if runes[i] >= 0x40 && runes[i] <= 0x7e {
+			return i
+		}
+		if runes[i] < 0x20 || runes[i] > 0x3f {
+			return i - 1
+		}
+	}
+	return len(runes) - 1
+}
+
+// skipString skips control string starting at i up to BEL or string
+// terminator, returns index of the terminator
+func skipString(runes []rune, i int) int {
+	for ; i < len(runes); i++ {
+		switch {
+		case runes[i] == bel || runes[i] == '\u009c':
+			return i
+		case runes[i] == esc && i+1 < len(runes) && runes[i+1] == '\\':
+			return i + 1
+		}
+	}
+	return len(runes) - 1
+}
+
+// isBidiControl reports if r changes direction of the following text
+func isBidiControl(r rune) bool {
+	return (r >= '\u202a' && r <= '\u202e') || (r >= '\u2066' && r <= '\u2069')
+}
+
+// checkText sanitizes and moderates body of the message frame and rejects
+// empty and too long messages of the client, returns false if the frame is
+// rejected
+func (s *wsServer) checkText(c *client, f *protocol.Frame) bool {
+	body := sanitizeText(f.Body)
+	if strings.TrimSpace(f.Body) == "" {
+		s.reject(c, f.ID, protocol.ErrCodeInvalidText, "message is empty")
+		return false
+	} else if strings.TrimSpace(body) == "" {
+		s.reject(c, f.ID, protocol.ErrCodeInvalidText, "message contains only control characters")
+		return false
+	}
+	if n := utf8.RuneCountInString(body); n > s.cfg.MaxMessageLength {
+		s.reject(c, f.ID, protocol.ErrCodeTooLarge, fmt.Sprintf("message has %d characters, the limit is %d", n, s.cfg.MaxMessageLength))
+		return false
+	}
+	body, err := s.app.ModerateMessage(context.Background(), c.nickname, body)
+	if err == model.MessageRejected {
+		s.reject(c, f.ID, protocol.ErrCodeModerated, "message was rejected by moderation")
+		return false
+	} else if err != nil {
+		s.reject(c, f.ID, protocol.ErrCodeInternal, "can't check message, please try again later")
+		return false
+	}
+	f.Body = body
+	return true
+}
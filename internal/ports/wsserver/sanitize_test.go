@@ -0,0 +1,119 @@
+package wsserver
+
+import (
+	"console-chat/internal/protocol"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSanitizeText(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{"Hello!", "Hello!"},
+		{"Привет, мир", "Привет, мир"},
+		{"\x1b[31mred\x1b[0m", "red"},
+		{"\x1b[2J\x1b[Hclear", "clear"},
+		{"\x1b]0;title\x07text", "text"},
+		{"\x1b]8;;http://evil\x1b\\link\x1b]8;;\x1b\\", "link"},
+		{"\x1bcreset", "reset"},
+		{"\x1b(Bcharset", "charset"},
+		{"\u009b31mred", "red"},
+		{"line1\nline2\tend", "line1 line2 end"},
+		{"fake\rover", "fake over"},
+		{"bell\x07\x08\x00", "bell"},
+		{"abc‮dcba", "abcdcba"},
+		{"trailing\x1b", "trailing"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, sanitizeText(test.text), "%q", test.text)
+	}
+}
+
+func TestMessageLimits(t *testing.T) {
+	wsserver := New(testKeyring(), newTestApp(), Config{
+		MaxFrameSize:     1024,
+		MaxMessageLength: 10,
+		MessageBurst:     10,
+	})
+	server := httptest.NewServer(http.HandlerFunc(wsserver.Chat))
+	defer server.Close()
+
+	url := "ws" + server.URL[4:]
+	token01, err := codeNicknameInToken("user01")
+	assert.NoError(t, err)
+	conn01, err := getChat(url, token01)
+	assert.NoError(t, err)
+	defer conn01.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	token02, err := codeNicknameInToken("user02")
+	assert.NoError(t, err)
+	conn02, err := getChat(url, token02)
+	assert.NoError(t, err)
+	defer conn02.Close()
+	msg, err := readServerText(conn01)
+	assert.NoError(t, err)
+	assert.Equal(t, "[general] user02 joins the room", msg)
+
+	// too large frame is discarded and session keeps working
+	assert.NoError(t, writeClientText(conn02, strings.Repeat("a", 2048)))
+	f, err := readFrame(conn02)
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.ErrCodeTooLarge, f.Error.Code)
+
+	// too long message
+	assert.NoError(t, writeClientText(conn02, "Hello, world!"))
+	f, err = readFrame(conn02)
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.ErrCodeTooLarge, f.Error.Code)
+
+	// invalid UTF-8
+	assert.NoError(t, wsutil.WriteClientMessage(conn02, ws.OpText, []byte("{\"type\":\"message\",\"body\":\"\xff\xfe\"}")))
+	f, err = readFrame(conn02)
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.ErrCodeInvalidText, f.Error.Code)
+
+	// only control sequences
+	assert.NoError(t, writeClientText(conn02, "\x1b[2J"))
+	f, err = readFrame(conn02)
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.ErrCodeInvalidText, f.Error.Code)
+
+	// empty and whitespace-only messages
+	for _, text := range []string{"", " \t "} {
+		assert.NoError(t, writeClientText(conn02, text))
+		f, err = readFrame(conn02)
+		assert.NoError(t, err)
+		assert.Equal(t, protocol.ErrCodeInvalidText, f.Error.Code, text)
+	}
+
+	// escape sequences are stripped
+	assert.NoError(t, writeClientText(conn02, "\x1b[31mHello\x1b[0m"))
+	msg, err = readServerText(conn01)
+	assert.NoError(t, err)
+	assert.Equal(t, "[general] user02: Hello", msg)
+
+	// obscenities are masked by moderation
+	assert.NoError(t, writeClientText(conn02, "f.u.c.k"))
+	msg, err = readServerText(conn01)
+	assert.NoError(t, err)
+	assert.Equal(t, "[general] user02: *******", msg)
+}
+
+func TestLargeAuthFrame(t *testing.T) {
+	wsserver := New(testKeyring(), newTestApp(), Config{MaxFrameSize: 1024})
+	server := httptest.NewServer(http.HandlerFunc(wsserver.Chat))
+	defer server.Close()
+
+	_, err := getChat("ws"+server.URL[4:], []byte(strings.Repeat("a", 2048)))
+	assert.Equal(t, protocol.ErrCodeTooLarge, err.(*protocol.Error).Code)
+}
@@ -1,71 +1,228 @@
 package wsserver
 
 import (
+	"console-chat/internal/app"
+	"console-chat/internal/model"
+	"console-chat/internal/ports/broker"
+	"console-chat/internal/ports/token"
+	"console-chat/internal/protocol"
+	"context"
 	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/gobwas/ws"
 	"github.com/gobwas/ws/wsutil"
-	"github.com/golang-jwt/jwt"
 )
 
 type wsServer struct {
-	connections map[string]net.Conn
+	connections map[string]map[string]*client // nickname -> session ID -> session
+	rooms       map[string]*room
 	mu          *sync.Mutex
-	tokenKey    []byte
+	keys        *token.Keyring
+	app         app.App
+	cfg         Config
+	counters    queueCounters
+	flood       *floodControl
+	typing      *typingState
+
+	// broker connects this instance to others, instanceID tells its events
+	// from events of others
+	broker     broker.Broker
+	instanceID string
+	stopListen context.CancelFunc
+
+	// writers are running writeLoops of all sessions
+	writers sync.WaitGroup
+
+	// closing is set by Shutdown, new sessions are closed right away
+	closing bool
 }
 
-// AddConnection adds new client connection to the server
-func (s *wsServer) addConnection(conn net.Conn, nickname string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.connections[nickname] = conn
+var errTokenRevoked = errors.New("token was revoked")
+
+// credentials are what server knows about the session from its token
+type credentials struct {
+	nickname string
+	tokenID  string // jti claim
+	family   string // sid claim, ID of refresh token family of the sign in
 }
 
-// getToken reads client token
-func (s *wsServer) getToken(conn net.Conn) ([]byte, error) {
-	tokenData, _, err := wsutil.ReadClientData(conn)
+// auth checks if token is valid and wasn't revoked, returns credentials
+// coded in token
+func (s *wsServer) auth(tokenData []byte) (credentials, error) {
+	claims, err := s.keys.Parse(string(tokenData))
 	if err != nil {
-		return nil, err
+		return credentials{}, err
 	}
-	return tokenData, nil
+	nickname, ok := claims["nickname"].(string)
+	if !ok || nickname == "" {
+		return credentials{}, errors.New("auth failure")
+	}
+	creds := credentials{
+		nickname: nickname,
+	}
+	creds.tokenID, _ = claims["jti"].(string)
+	creds.family, _ = claims["sid"].(string)
+
+	if revoked, err := s.app.IsTokenRevoked(context.Background(), creds.tokenID, creds.family); err != nil {
+		return credentials{}, err
+	} else if revoked {
+		return credentials{}, errTokenRevoked
+	}
+	return creds, nil
 }
 
-// auth checks if token is valid and returns nickname coded in token
-func (s *wsServer) auth(tokenData []byte) (string, error) {
-	token, err := jwt.Parse(string(tokenData), func(token *jwt.Token) (any, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, jwt.ErrSignatureInvalid
-		}
-		return s.tokenKey, nil
-	})
-	if err != nil {
-		return "", err
+// handshake reads client's auth frame, negotiates protocol version and
+// checks the token, returns credentials coded in token and resume request
+// which is nil for new sessions. Frames are encoded once for all sessions,
+// so the server speaks only one version and the negotiation just checks that
+// the client supports it. Client is notified about the result with ack
+// containing its session ID or with error frame, reason of the failure is
+// returned as *handshakeError
+func (s *wsServer) handshake(conn net.Conn, sessionID string) (credentials, *resumeRequest, error) {
+	if err := conn.SetReadDeadline(time.Now().Add(s.cfg.AuthTimeout)); err != nil {
+		return credentials{}, nil, err
+	}
+	data, err := s.readAuthFrame(conn)
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return credentials{}, nil, &handshakeError{code: protocol.CloseAuthTimeout, reason: "auth frame wasn't received in time"}
+	} else if err == wsutil.ErrFrameTooLarge {
+		_ = writeFrame(conn, protocol.NewError("", protocol.ErrCodeTooLarge, fmt.Sprintf("auth frame is larger than %d bytes", s.cfg.MaxFrameSize)))
+		return credentials{}, nil, &handshakeError{code: protocol.CloseMessageTooBig, reason: "auth frame is too large"}
+	} else if err != nil {
+		return credentials{}, nil, err
 	}
+	_ = conn.SetReadDeadline(time.Time{})
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		return claims["nickname"].(string), nil
-	} else {
-		return "", errors.New("auth failure")
+	f, err := protocol.Decode(data)
+	if err != nil || f.Type != protocol.TypeAuth {
+		_ = writeFrame(conn, protocol.NewError(f.ID, protocol.ErrCodeProtocol, "first frame should be auth frame"))
+		return credentials{}, nil, &handshakeError{code: protocol.CloseProtocolError, reason: "first frame should be auth frame"}
 	}
+
+	version, ok := protocol.Negotiate(f.Versions)
+	if !ok {
+		_ = writeFrame(conn, protocol.NewError(f.ID, protocol.ErrCodeUnsupportedVersion, "server supports protocol versions "+versionsString(protocol.SupportedVersions)))
+		return credentials{}, nil, &handshakeError{code: protocol.CloseUnsupportedVersion, reason: "unsupported protocol versions"}
+	}
+
+	creds, err := s.auth([]byte(f.Body))
+	if err == token.ErrExpired {
+		_ = writeFrame(conn, protocol.NewError(f.ID, protocol.ErrCodeTokenExpired, "token has expired"))
+		return credentials{}, nil, &handshakeError{code: protocol.CloseTokenExpired, reason: "token has expired"}
+	} else if err == errTokenRevoked {
+		_ = writeFrame(conn, protocol.NewError(f.ID, protocol.ErrCodeUnauthorized, "token was revoked"))
+		return credentials{}, nil, &handshakeError{code: protocol.CloseTokenRevoked, reason: "token was revoked"}
+	} else if err == model.TokenRepoError {
+		_ = writeFrame(conn, protocol.NewError(f.ID, protocol.ErrCodeInternal, "can't check token"))
+		return credentials{}, nil, &handshakeError{code: protocol.CloseInternalError, reason: "can't check token"}
+	} else if err != nil {
+		_ = writeFrame(conn, protocol.NewError(f.ID, protocol.ErrCodeUnauthorized, "invalid token"))
+		return credentials{}, nil, &handshakeError{code: protocol.CloseBadToken, reason: "invalid token"}
+	}
+
+	var resume *resumeRequest
+	if f.Event == protocol.AuthResume {
+		resume = &resumeRequest{lastSeqs: f.Resume}
+	}
+	return creds, resume, writeFrame(conn, protocol.Frame{
+		Version:   version,
+		Type:      protocol.TypeAck,
+		ID:        sessionID,
+		ReplyTo:   f.ID,
+		Sender:    creds.nickname,
+		Timestamp: time.Now().UTC(),
+	})
 }
 
-// sendMessageToUsers sends message from publisher to all other clients
-func (s *wsServer) sendMessageToUsers(publisher string, message []byte) {
-	s.mu.Lock()
-	for key, connection := range s.connections {
-		if key == publisher {
+// readAuthFrame reads the first data frame of the connection which is not
+// larger than MaxFrameSize
+func (s *wsServer) readAuthFrame(conn net.Conn) ([]byte, error) {
+	rd := wsutil.Reader{
+		Source:         conn,
+		State:          ws.StateServerSide,
+		CheckUTF8:      true,
+		MaxFrameSize:   s.cfg.MaxFrameSize,
+		OnIntermediate: wsutil.ControlFrameHandler(conn, ws.StateServerSide),
+	}
+	for {
+		hdr, err := rd.NextFrame()
+		if err != nil {
+			return nil, err
+		}
+		if hdr.OpCode.IsControl() {
+			if err := rd.OnIntermediate(hdr, &rd); err != nil {
+				return nil, err
+			}
 			continue
-		} else if err := wsutil.WriteServerMessage(connection, ws.OpText, message); err != nil {
-			log.Println(publisher, "was disconnected from the chat")
-			delete(s.connections, key)
 		}
+		data, err := io.ReadAll(io.LimitReader(&rd, s.cfg.MaxFrameSize+1))
+		if err != nil {
+			return nil, err
+		} else if int64(len(data)) > s.cfg.MaxFrameSize {
+			return nil, wsutil.ErrFrameTooLarge
+		}
+		return data, nil
+	}
+}
+
+// versionsString formats list of protocol versions
+func versionsString(versions []int) string {
+	str := ""
+	for i, v := range versions {
+		if i != 0 {
+			str += ", "
+		}
+		str += strconv.Itoa(v)
+	}
+	return str
+}
+
+// writeFrame encodes frame and writes it to the connection
+func writeFrame(conn net.Conn, f protocol.Frame) error {
+	data, err := protocol.Encode(f)
+	if err != nil {
+		return err
 	}
+	return writeData(conn, data)
+}
+
+// writeData writes encoded frame to the connection
+func writeData(conn net.Conn, data []byte) error {
+	return wsutil.WriteServerMessage(conn, ws.OpText, data)
+}
+
+// deliverToRoom sends frame to all sessions of the room on this instance
+// except the session with given ID
+func (s *wsServer) deliverToRoom(roomName, exceptSession string, f protocol.Frame) {
+	data, err := protocol.Encode(f)
+	if err != nil {
+		log.Println("can't encode frame:", err.Error())
+		return
+	}
+
+	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	r, ok := s.rooms[roomName]
+	if !ok {
+		return
+	}
+	for member := range r.members {
+		for _, c := range s.connections[member] {
+			if c.sessionID == exceptSession {
+				continue
+			}
+			s.deliver(c, f, data)
+		}
+	}
 }
 
 // Chat adds new client to the chat
@@ -77,53 +234,187 @@ func (s *wsServer) Chat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// getting client's token and check if it is valid
-	tokenData, err := s.getToken(conn)
-	if err != nil {
-		log.Println("can't upgrade connection:", err.Error())
-		return
-	}
-	nickname, err := s.auth(tokenData)
+	// getting client's auth frame and check if it is valid
+	sessionID := newID()
+	creds, resume, err := s.handshake(conn, sessionID)
 	if err != nil {
 		log.Println("can't get and validate token:", err.Error())
+		var handshakeErr *handshakeError
+		if errors.As(err, &handshakeErr) {
+			s.rejectConn(conn, handshakeErr.code, handshakeErr.reason)
+		} else {
+			_ = conn.Close()
+		}
 		return
 	}
 
-	// creating connection for new user
-	s.addConnection(conn, nickname)
-	log.Println(nickname, "joins the chat")
-	s.sendMessageToUsers(nickname, []byte(nickname+" joins the chat"))
+	// creating session for the user, joining the default room only if user
+	// wasn't in the chat from another session. Resumed session gets back to
+	// the rooms of the lost one instead
+	nickname := creds.nickname
+	c := newClient(sessionID, nickname, conn, s.cfg.SendQueueSize)
+	c.tokenID, c.tokenFamily = creds.tokenID, creds.family
+	c.holding, c.sentSeqs = true, make(map[string]int64)
+	s.writers.Add(1)
+	go func() {
+		defer s.writers.Done()
+		s.writeLoop(c)
+	}()
+	localFirst := s.addSession(c)
+	first, err := s.broker.AddSession(context.Background(), nickname, sessionID)
+	if err != nil {
+		log.Println("can't register session of", nickname, "in the cluster:", err.Error())
+		first = localFirst
+	}
+	if first {
+		log.Println(nickname, "joins the chat")
+		rooms := []string{defaultRoom}
+		if resume != nil {
+			rooms = s.restoreRooms(nickname)
+		}
+		for _, roomName := range rooms {
+			if _, err := s.joinRoom(nickname, roomName); err != nil {
+				continue
+			}
+			s.sendToRoom(roomName, c, protocol.NewSystem(roomName, protocol.EventJoin, nickname+" joins the room"))
+		}
+		s.notifyPresence(nickname)
+	} else {
+		log.Println(nickname, "opens another session", sessionID)
+		if localFirst {
+			s.loadRooms(nickname)
+		}
+	}
+	c.currentRoom = ""
+	if rooms := s.userRooms(nickname); len(rooms) != 0 {
+		c.currentRoom = rooms[0]
+	}
+	if s.isRoomMember(nickname, defaultRoom) {
+		c.currentRoom = defaultRoom
+	}
+	for _, roomName := range s.userRooms(nickname) {
+		if lastSeq := resume.lastSeq(roomName); lastSeq > 0 {
+			s.replayMissed(c, roomName, lastSeq)
+		} else {
+			s.sendHistory(c, roomName)
+		}
+	}
+	s.deliverQueued(c)
+	s.releaseHeld(c)
 	ch := make(chan []byte)
 
-	// reading new messages
+	// reading new frames
 	go func() {
 		defer func() {
 			close(ch)
-			if err := conn.Close(); err != nil {
-				log.Fatal("connection closure error:", err.Error())
-			}
+			c.close()
 		}()
 
-		for {
-			msg, _, err := wsutil.ReadClientData(conn)
+		s.readLoop(c, ch)
+	}()
+
+	// handling frames of the client
+	go func() {
+		for data := range ch {
+			f, err := protocol.Decode(data)
 			if err != nil {
-				break
+				s.sendToSession(c, protocol.NewError("", protocol.ErrCodeProtocol, err.Error()))
+				continue
 			}
 
-			ch <- msg
+			switch f.Type {
+			case protocol.TypeMessage:
+				s.handleMessage(c, f)
+			case protocol.TypeCommand:
+				s.handleCommand(c, f)
+			case protocol.TypeTyping:
+				s.handleTyping(c, f)
+			default:
+				s.sendToSession(c, protocol.NewError(f.ID, protocol.ErrCodeBadRequest, "unexpected frame type "+string(f.Type)))
+			}
 		}
-	}()
 
-	// sending messages to the users
-	go func() {
-		for msg := range ch {
-			msg = append([]byte(nickname+": "), msg...)
-			s.sendMessageToUsers(nickname, msg)
+		// user leaves rooms only when the last session in the cluster is closed
+		localLast := s.removeSession(c)
+		last, err := s.broker.RemoveSession(context.Background(), nickname, sessionID)
+		if err != nil {
+			log.Println("can't unregister session of", nickname, "in the cluster:", err.Error())
+			last = localLast
+		}
+		if !last {
+			log.Println(nickname, "closes session", sessionID)
+			if localLast {
+				s.forgetRooms(nickname)
+			}
+			return
 		}
 		log.Println(nickname, "leaves the chat")
-		s.sendMessageToUsers(nickname, []byte(nickname+" leaves the chat"))
-		s.mu.Lock()
-		delete(s.connections, nickname)
-		s.mu.Unlock()
+		if err := s.broker.SaveRooms(context.Background(), nickname, s.userRooms(nickname)); err != nil {
+			log.Println("can't save rooms of", nickname, err.Error())
+		}
+		s.flood.forgetUser(nickname, time.Now())
+		for _, key := range s.typing.forgetUser(nickname, time.Now()) {
+			s.sendTyping(c, key, protocol.TypingStop)
+		}
+		for _, roomName := range s.userRooms(nickname) {
+			s.leaveRoom(nickname, roomName)
+			s.sendToRoom(roomName, c, protocol.NewSystem(roomName, protocol.EventLeave, nickname+" leaves the room"))
+		}
+		s.notifyPresence(nickname)
 	}()
 }
+
+// publish saves message of the client to the history of the room and sends
+// it to other members of the room, returns sent frame or false if the message
+// was rejected or couldn't be saved. Message is sent to the current room of
+// the client unless frame has another room of the client
+func (s *wsServer) publish(c *client, f protocol.Frame) (protocol.Frame, bool) {
+	roomName := c.currentRoom
+	if f.Room != "" {
+		roomName = f.Room
+	}
+	if roomName == "" {
+		s.reject(c, f.ID, protocol.ErrCodeBadRequest, "you are not in any room, use /join <room>")
+		return protocol.Frame{}, false
+	} else if !s.isRoomMember(c.nickname, roomName) {
+		s.reject(c, f.ID, protocol.ErrCodeBadRequest, "you are not a member of the room "+roomName)
+		return protocol.Frame{}, false
+	}
+
+	msg := model.Message{
+		Room:      roomName,
+		Sender:    c.nickname,
+		Text:      f.Body,
+		CreatedAt: time.Now().UTC(),
+	}
+	// message which isn't in history can't be replayed, so it isn't sent
+	saved, err := s.app.SaveMessage(context.Background(), msg)
+	if err != nil {
+		log.Println("can't save message of", c.nickname, err.Error())
+		s.reject(c, f.ID, protocol.ErrCodeInternal, "can't send message, please try again later")
+		return protocol.Frame{}, false
+	}
+	msg = saved
+	s.typing.reset(typingKey{nickname: c.nickname, room: msg.Room})
+	sent := messageFrame(msg)
+	s.sendToRoom(msg.Room, c, sent)
+	s.queueMentions(msg)
+	return sent, true
+}
+
+// messageFrame converts chat message to the frame
+func messageFrame(msg model.Message) protocol.Frame {
+	f := protocol.Frame{
+		Type:      protocol.TypeMessage,
+		Room:      msg.Room,
+		Sender:    msg.Sender,
+		To:        msg.To,
+		Timestamp: msg.CreatedAt,
+		Body:      msg.Text,
+		Seq:       msg.Seq,
+	}
+	if msg.ID != 0 {
+		f.ID = strconv.FormatInt(msg.ID, 10)
+	}
+	return f
+}
@@ -1,21 +1,171 @@
 package wsserver
 
 import (
-	"net"
+	"console-chat/internal/app"
+	"console-chat/internal/ports/broker"
+	"console-chat/internal/ports/token"
+	"console-chat/internal/protocol"
+	"context"
 	"net/http"
 	"sync"
+	"time"
 )
 
 type WsServer interface {
 	// Chat adds new client to the chat. First message
-	// should contain token with coded nickname of the connected user
+	// should contain token with coded nickname of the connected user.
+	// Client joins the default room and gets its history, then may join
+	// other rooms and request older history by commands
 	Chat(w http.ResponseWriter, r *http.Request)
+
+	// QueueStats returns metrics of outbound queues of all sessions
+	QueueStats() QueueStats
+
+	// OnlineUsers returns presence of users which are online on any instance
+	// of the chat, only members of the room if room isn't empty
+	OnlineUsers(ctx context.Context, room string) ([]protocol.Presence, error)
+
+	// RevokeTokens closes all sessions opened with tokens which have any of
+	// IDs as jti or sid claim
+	RevokeTokens(ids ...string)
+
+	// Shutdown closes all sessions with going away close frame and waits
+	// until the frames are written or ctx is done
+	Shutdown(ctx context.Context) error
+}
+
+type Config struct {
+	// HistorySize is how many messages of the room history are sent to the
+	// client after joining the room and on every history request
+	HistorySize int
+
+	// ReplayLimit is how many missed messages of every room are sent at most
+	// to the client which resumes the lost session
+	ReplayLimit int
+
+	// SendQueueSize is a capacity of outbound queue of every session
+	SendQueueSize int
+
+	// WriteTimeout is a deadline of writing one frame to the session
+	WriteTimeout time.Duration
+
+	// OverflowPolicy is what happens when outbound queue of the session is full
+	OverflowPolicy OverflowPolicy
+
+	// PingInterval is how often server pings every session
+	PingInterval time.Duration
+
+	// PongWait is how long server waits for pong or any other frame from the
+	// session before dropping it as unresponsive, should be greater than
+	// PingInterval
+	PongWait time.Duration
+
+	// AuthTimeout is how long server waits for the auth frame of new
+	// connection before closing it
+	AuthTimeout time.Duration
+
+	// MessageRate is how many messages per second every user may send on
+	// average, MessageBurst is how many of them may be sent at once
+	MessageRate  float64
+	MessageBurst int
+
+	// RoomMessageRate and RoomMessageBurst limit messages to every room from
+	// all its members
+	RoomMessageRate  float64
+	RoomMessageBurst int
+
+	// MuteAfter is how many throttled messages within a minute mute the user
+	// for MuteDuration
+	MuteAfter    int
+	MuteDuration time.Duration
+
+	// MaxFrameSize is the largest websocket message in bytes accepted from
+	// the client, larger messages are discarded without buffering
+	MaxFrameSize int64
+
+	// MaxMessageLength is the largest length of chat message in characters
+	MaxMessageLength int
+
+	// TypingInterval is how often typing starts of the user to the same room
+	// or user are relayed, more frequent ones are dropped. It should be less
+	// than protocol.TypingTimeout/2 which is how often clients repeat them
+	TypingInterval time.Duration
+
+	// Broker connects instances of the server into one chat, in-process
+	// broker is used if it is nil
+	Broker broker.Broker
 }
 
-func New(tokenKey []byte) WsServer {
-	return &wsServer{
-		connections: make(map[string]net.Conn),
+func New(keys *token.Keyring, a app.App, cfg Config) WsServer {
+	if cfg.ReplayLimit <= 0 {
+		cfg.ReplayLimit = defaultReplayLimit
+	}
+	if cfg.SendQueueSize <= 0 {
+		cfg.SendQueueSize = defaultSendQueueSize
+	}
+	if cfg.WriteTimeout <= 0 {
+		cfg.WriteTimeout = defaultWriteTimeout
+	}
+	if cfg.OverflowPolicy == "" {
+		cfg.OverflowPolicy = DropOldest
+	}
+	if cfg.PongWait <= 0 {
+		cfg.PongWait = defaultPongWait
+	}
+	if cfg.PingInterval <= 0 {
+		cfg.PingInterval = defaultPingInterval
+	}
+	if cfg.AuthTimeout <= 0 {
+		cfg.AuthTimeout = defaultAuthTimeout
+	}
+	if cfg.MessageRate <= 0 {
+		cfg.MessageRate = defaultMessageRate
+	}
+	if cfg.MessageBurst <= 0 {
+		cfg.MessageBurst = defaultMessageBurst
+	}
+	if cfg.RoomMessageRate <= 0 {
+		cfg.RoomMessageRate = defaultRoomMessageRate
+	}
+	if cfg.RoomMessageBurst <= 0 {
+		cfg.RoomMessageBurst = defaultRoomMessageBurst
+	}
+	if cfg.MuteAfter <= 0 {
+		cfg.MuteAfter = defaultMuteAfter
+	}
+	if cfg.MuteDuration <= 0 {
+		cfg.MuteDuration = defaultMuteDuration
+	}
+	if cfg.MaxFrameSize <= 0 {
+		cfg.MaxFrameSize = defaultMaxFrameSize
+	}
+	if cfg.MaxMessageLength <= 0 {
+		cfg.MaxMessageLength = defaultMaxMessageLength
+	}
+	if cfg.TypingInterval <= 0 {
+		cfg.TypingInterval = defaultTypingInterval
+	}
+	if cfg.PingInterval >= cfg.PongWait {
+		cfg.PingInterval = cfg.PongWait * 9 / 10
+	}
+	if cfg.Broker == nil {
+		cfg.Broker = broker.NewMemory()
+	}
+
+	ctx, stopListen := context.WithCancel(context.Background())
+	s := &wsServer{
+		connections: make(map[string]map[string]*client),
+		rooms:       make(map[string]*room),
 		mu:          new(sync.Mutex),
-		tokenKey:    tokenKey,
+		keys:        keys,
+		app:         a,
+		cfg:         cfg,
+		flood:       newFloodControl(cfg),
+		typing:      newTypingState(cfg.TypingInterval),
+		broker:      cfg.Broker,
+		instanceID:  newID(),
+		stopListen:  stopListen,
 	}
+	go s.listen(ctx)
+	return s
 }
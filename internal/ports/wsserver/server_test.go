@@ -1,10 +1,15 @@
 package wsserver
 
 import (
+	"console-chat/internal/ports/broker"
+	"console-chat/internal/ports/token"
+	"console-chat/internal/protocol"
 	"context"
+	"errors"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -14,34 +19,102 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+// testKeyring returns keyring with the single test key
+func testKeyring() *token.Keyring {
+	keys, _ := token.NewKeyring("test", token.NewHMACKey("test", []byte("abcd")))
+	return keys
+}
+
 // codeNicknameInToken codes user nickname into valid token []byte
 func codeNicknameInToken(nickname string) ([]byte, error) {
-	token := jwt.New(jwt.SigningMethodHS256)
-	claims := token.Claims.(jwt.MapClaims)
-	claims["nickname"] = nickname
-	claims["exp"] = time.Now().Add(24 * time.Hour).Unix()
-	tokenInStr, err := token.SignedString([]byte("abcd"))
+	tokenInStr, err := testKeyring().Sign(jwt.MapClaims{
+		"nickname": nickname,
+		"exp":      time.Now().Add(24 * time.Hour).Unix(),
+	})
 	if err != nil {
 		return nil, err
 	}
 	return []byte(tokenInStr), err
 }
 
-// getChat connects to the chat and sends user token to authorize
+// getChat connects to the chat, sends auth frame with user token and reads
+// server's ack
 func getChat(url string, token []byte) (net.Conn, error) {
 	conn, _, _, err := ws.DefaultDialer.Dial(context.Background(), url)
 	if err != nil {
 		return nil, err
 	}
-	if err = wsutil.WriteClientMessage(conn, ws.OpText, token); err != nil {
+	data, _ := protocol.Encode(protocol.Frame{
+		Type:     protocol.TypeAuth,
+		Body:     string(token),
+		Versions: protocol.SupportedVersions,
+	})
+	if err = wsutil.WriteClientMessage(conn, ws.OpText, data); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if f, err := readFrame(conn); err != nil {
 		_ = conn.Close()
 		return nil, err
+	} else if f.Type != protocol.TypeAck {
+		_ = conn.Close()
+		return nil, f.Error
 	}
 	return conn, nil
 }
 
+// readFrame reads and decodes frame from the server
+func readFrame(conn net.Conn) (protocol.Frame, error) {
+	data, _, err := wsutil.ReadServerData(conn)
+	if err != nil {
+		return protocol.Frame{}, err
+	}
+	return protocol.Decode(data)
+}
+
+// readServerText reads frame from the server and formats it as a line
+func readServerText(conn net.Conn) (string, error) {
+	f, err := readFrame(conn)
+	if err != nil {
+		return "", err
+	}
+	switch f.Type {
+	case protocol.TypeMessage:
+		if f.To != "" {
+			return "[dm] " + f.Sender + " -> " + f.To + ": " + f.Body, nil
+		}
+		return "[" + f.Room + "] " + f.Sender + ": " + f.Body, nil
+	case protocol.TypeSystem:
+		if f.Room == "" {
+			return f.Body, nil
+		}
+		return "[" + f.Room + "] " + f.Body, nil
+	case protocol.TypeError:
+		return "error: " + f.Error.Error(), nil
+	default:
+		return string(f.Type), nil
+	}
+}
+
+// writeClientText sends text as command frame if it starts with / or as
+// message frame otherwise
+func writeClientText(conn net.Conn, text string) error {
+	f := protocol.Frame{
+		Type: protocol.TypeMessage,
+		Body: text,
+	}
+	if strings.HasPrefix(text, "/") {
+		f.Type = protocol.TypeCommand
+	}
+	data, err := protocol.Encode(f)
+	if err != nil {
+		return err
+	}
+	return wsutil.WriteClientMessage(conn, ws.OpText, data)
+}
+
 func TestChat(t *testing.T) {
-	wsserver := New([]byte("abcd"))
+	wsserver := New(testKeyring(), newTestApp(), Config{})
 	server := httptest.NewServer(http.HandlerFunc(wsserver.Chat))
 	defer server.Close()
 
@@ -66,34 +139,34 @@ func TestChat(t *testing.T) {
 	time.Sleep(100 * time.Millisecond)
 
 	// user01 gets message that user02 joined the chat
-	msg, _, err := wsutil.ReadServerData(conn01)
-	assert.Equal(t, "user02 joins the chat", string(msg))
+	msg, err := readServerText(conn01)
+	assert.Equal(t, "[general] user02 joins the room", msg)
 	assert.NoError(t, err)
 
 	time.Sleep(100 * time.Millisecond)
 
 	// user01 sends message to the chat
-	err = wsutil.WriteClientMessage(conn01, ws.OpText, []byte("Ping"))
+	err = writeClientText(conn01, "Ping")
 	assert.NoError(t, err)
 
 	time.Sleep(100 * time.Millisecond)
 
 	// user02 gets message from user01
-	msg, _, err = wsutil.ReadServerData(conn02)
-	assert.Equal(t, "user01: Ping", string(msg))
+	msg, err = readServerText(conn02)
+	assert.Equal(t, "[general] user01: Ping", msg)
 	assert.NoError(t, err)
 
 	time.Sleep(100 * time.Millisecond)
 
 	// user02 sends message to user01
-	err = wsutil.WriteClientMessage(conn02, ws.OpText, []byte("Pong"))
+	err = writeClientText(conn02, "Pong")
 	assert.NoError(t, err)
 
 	time.Sleep(100 * time.Millisecond)
 
 	// user01 gets message from user02
-	msg, _, err = wsutil.ReadServerData(conn01)
-	assert.Equal(t, "user02: Pong", string(msg))
+	msg, err = readServerText(conn01)
+	assert.Equal(t, "[general] user02: Pong", msg)
 	assert.NoError(t, err)
 
 	time.Sleep(100 * time.Millisecond)
@@ -108,60 +181,296 @@ func TestChat(t *testing.T) {
 	time.Sleep(100 * time.Millisecond)
 
 	// user01 gets message that user03 joined the chat
-	msg, _, err = wsutil.ReadServerData(conn01)
-	assert.Equal(t, "user03 joins the chat", string(msg))
+	msg, err = readServerText(conn01)
+	assert.Equal(t, "[general] user03 joins the room", msg)
 	assert.NoError(t, err)
 
 	time.Sleep(100 * time.Millisecond)
 
 	// user02 gets message that user03 joined the chat
-	msg, _, err = wsutil.ReadServerData(conn02)
-	assert.Equal(t, "user03 joins the chat", string(msg))
+	msg, err = readServerText(conn02)
+	assert.Equal(t, "[general] user03 joins the room", msg)
 	assert.NoError(t, err)
 
 	time.Sleep(100 * time.Millisecond)
 
 	// user01 sends message to the chat
-	err = wsutil.WriteClientMessage(conn01, ws.OpText, []byte("Ping 2"))
+	err = writeClientText(conn01, "Ping 2")
 	assert.NoError(t, err)
 
 	time.Sleep(100 * time.Millisecond)
 
 	// user02 gets message from user01
-	msg, _, err = wsutil.ReadServerData(conn02)
-	assert.Equal(t, "user01: Ping 2", string(msg))
+	msg, err = readServerText(conn02)
+	assert.Equal(t, "[general] user01: Ping 2", msg)
 	assert.NoError(t, err)
 
 	time.Sleep(100 * time.Millisecond)
 
 	// user03 gets message from user01
-	msg, _, err = wsutil.ReadServerData(conn03)
-	assert.Equal(t, "user01: Ping 2", string(msg))
+	msg, err = readServerText(conn03)
+	assert.Equal(t, "[general] user01: Ping 2", msg)
 	assert.NoError(t, err)
 
 	time.Sleep(100 * time.Millisecond)
 
 	// user02 sends message to user01
-	err = wsutil.WriteClientMessage(conn02, ws.OpText, []byte("Pong 2"))
+	err = writeClientText(conn02, "Pong 2")
 	assert.NoError(t, err)
 
 	time.Sleep(100 * time.Millisecond)
 
 	// user01 gets message from user02
-	msg, _, err = wsutil.ReadServerData(conn01)
-	assert.Equal(t, "user02: Pong 2", string(msg))
+	msg, err = readServerText(conn01)
+	assert.Equal(t, "[general] user02: Pong 2", msg)
 	assert.NoError(t, err)
 
 	time.Sleep(100 * time.Millisecond)
 
 	// user03 sends message to user01
-	err = wsutil.WriteClientMessage(conn03, ws.OpText, []byte("Pong 2"))
+	err = writeClientText(conn03, "Pong 2")
 	assert.NoError(t, err)
 
 	time.Sleep(100 * time.Millisecond)
 
 	// user01 gets message from user03
-	msg, _, err = wsutil.ReadServerData(conn01)
-	assert.Equal(t, "user03: Pong 2", string(msg))
+	msg, err = readServerText(conn01)
+	assert.Equal(t, "[general] user03: Pong 2", msg)
+	assert.NoError(t, err)
+}
+
+func TestRooms(t *testing.T) {
+	wsserver := New(testKeyring(), newTestApp(), Config{})
+	server := httptest.NewServer(http.HandlerFunc(wsserver.Chat))
+	defer server.Close()
+
+	url := "ws" + server.URL[4:]
+
+	// user01 and user02 join the chat
+	token, err := codeNicknameInToken("user01")
+	assert.NoError(t, err)
+	conn01, err := getChat(url, token)
+	assert.NoError(t, err)
+	defer conn01.Close()
+
+	token, err = codeNicknameInToken("user02")
+	assert.NoError(t, err)
+	conn02, err := getChat(url, token)
+	assert.NoError(t, err)
+	defer conn02.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	msg, err := readServerText(conn01)
+	assert.Equal(t, "[general] user02 joins the room", msg)
+	assert.NoError(t, err)
+
+	// user01 joins room golang
+	err = writeClientText(conn01, "/join golang")
+	assert.NoError(t, err)
+
+	time.Sleep(100 * time.Millisecond)
+
+	msg, err = readServerText(conn01)
+	assert.Equal(t, "[golang] you are now writing to golang", msg)
+	assert.NoError(t, err)
+
+	// user01 lists rooms
+	err = writeClientText(conn01, "/rooms")
+	assert.NoError(t, err)
+
+	time.Sleep(100 * time.Millisecond)
+
+	msg, err = readServerText(conn01)
+	assert.Equal(t, "rooms:\ngeneral (2)\ngolang (1)", msg)
+	assert.NoError(t, err)
+
+	// user02 joins room golang and user01 gets notice
+	err = writeClientText(conn02, "/join golang")
+	assert.NoError(t, err)
+
+	time.Sleep(100 * time.Millisecond)
+
+	msg, err = readServerText(conn02)
+	assert.Equal(t, "[golang] you are now writing to golang", msg)
+	assert.NoError(t, err)
+	msg, err = readServerText(conn01)
+	assert.Equal(t, "[golang] user02 joins the room", msg)
+	assert.NoError(t, err)
+
+	// user02 writes to golang
+	err = writeClientText(conn02, "Ping")
+	assert.NoError(t, err)
+
+	time.Sleep(100 * time.Millisecond)
+
+	msg, err = readServerText(conn01)
+	assert.Equal(t, "[golang] user02: Ping", msg)
+	assert.NoError(t, err)
+
+	// user02 leaves golang and writes to general again
+	err = writeClientText(conn02, "/leave")
+	assert.NoError(t, err)
+
+	time.Sleep(100 * time.Millisecond)
+
+	msg, err = readServerText(conn02)
+	assert.Equal(t, "[general] you left golang and are now writing to general", msg)
+	assert.NoError(t, err)
+	msg, err = readServerText(conn01)
+	assert.Equal(t, "[golang] user02 leaves the room", msg)
+	assert.NoError(t, err)
+
+	// user01 writes to golang where nobody else is, then user02 writes to general
+	err = writeClientText(conn01, "Hello?")
+	assert.NoError(t, err)
+	err = writeClientText(conn02, "Pong")
+	assert.NoError(t, err)
+
+	time.Sleep(100 * time.Millisecond)
+
+	msg, err = readServerText(conn01)
+	assert.Equal(t, "[general] user02: Pong", msg)
+	assert.NoError(t, err)
+}
+
+// failingBroker can't add users to the room
+type failingBroker struct {
+	broker.Broker
+	room string
+}
+
+func (b failingBroker) JoinRoom(ctx context.Context, room, nickname string) (bool, error) {
+	if room == b.room {
+		return false, errors.New("broker is unavailable")
+	}
+	return b.Broker.JoinRoom(ctx, room, nickname)
+}
+
+func TestJoinFailure(t *testing.T) {
+	wsserver := New(testKeyring(), newTestApp(), Config{Broker: failingBroker{Broker: broker.NewMemory(), room: "golang"}})
+	server := httptest.NewServer(http.HandlerFunc(wsserver.Chat))
+	defer server.Close()
+	url := "ws" + server.URL[4:]
+
+	conn01 := connect(t, url, "user01")
+	defer conn01.Close()
+	conn02 := connect(t, url, "user02")
+	defer conn02.Close()
+	expectTexts(t, conn01, "[general] user02 joins the room")
+
+	// user02 isn't moved to the room which they couldn't join
+	assert.NoError(t, writeClientText(conn02, "/join golang"))
+	f, err := readFrame(conn02)
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.TypeError, f.Type)
+	assert.Equal(t, protocol.ErrCodeInternal, f.Error.Code)
+
+	assert.NoError(t, writeClientText(conn02, "Hello"))
+	expectTexts(t, conn01, "[general] user02: Hello")
+}
+
+type handshakeTest struct {
+	description  string
+	firstFrame   []byte
+	expectedType protocol.Type
+	expectedCode string
+}
+
+func TestHandshake(t *testing.T) {
+	wsserver := New(testKeyring(), newTestApp(), Config{})
+	server := httptest.NewServer(http.HandlerFunc(wsserver.Chat))
+	defer server.Close()
+
+	url := "ws" + server.URL[4:]
+
+	token, err := codeNicknameInToken("user01")
+	assert.NoError(t, err)
+	encode := func(f protocol.Frame) []byte {
+		data, _ := protocol.Encode(f)
+		return data
+	}
+
+	tests := []handshakeTest{
+		{
+			description:  "valid auth frame",
+			firstFrame:   encode(protocol.Frame{Type: protocol.TypeAuth, Body: string(token), Versions: []int{1, 2}}),
+			expectedType: protocol.TypeAck,
+		},
+		{
+			description:  "raw token instead of auth frame",
+			firstFrame:   token,
+			expectedType: protocol.TypeError,
+			expectedCode: protocol.ErrCodeProtocol,
+		},
+		{
+			description:  "message instead of auth frame",
+			firstFrame:   encode(protocol.Frame{Type: protocol.TypeMessage, Body: "Ping"}),
+			expectedType: protocol.TypeError,
+			expectedCode: protocol.ErrCodeProtocol,
+		},
+		{
+			description:  "unsupported protocol version",
+			firstFrame:   encode(protocol.Frame{Type: protocol.TypeAuth, Body: string(token), Versions: []int{2}}),
+			expectedType: protocol.TypeError,
+			expectedCode: protocol.ErrCodeUnsupportedVersion,
+		},
+		{
+			description:  "invalid token",
+			firstFrame:   encode(protocol.Frame{Type: protocol.TypeAuth, Body: "abcd", Versions: []int{1}}),
+			expectedType: protocol.TypeError,
+			expectedCode: protocol.ErrCodeUnauthorized,
+		},
+	}
+
+	for _, test := range tests {
+		conn, _, _, err := ws.DefaultDialer.Dial(context.Background(), url)
+		assert.NoError(t, err, test.description)
+
+		err = wsutil.WriteClientMessage(conn, ws.OpText, test.firstFrame)
+		assert.NoError(t, err, test.description)
+
+		f, err := readFrame(conn)
+		assert.NoError(t, err, test.description)
+		assert.Equal(t, test.expectedType, f.Type, test.description)
+		if test.expectedCode != "" {
+			assert.Equal(t, test.expectedCode, f.Error.Code, test.description)
+		} else {
+			assert.Equal(t, "user01", f.Sender, test.description)
+			assert.Equal(t, 1, f.Version, test.description)
+		}
+		_ = conn.Close()
+	}
+}
+
+func TestWhois(t *testing.T) {
+	wsserver := New(testKeyring(), newTestApp(), Config{})
+	server := httptest.NewServer(http.HandlerFunc(wsserver.Chat))
+	defer server.Close()
+
+	token, err := codeNicknameInToken("user01")
+	assert.NoError(t, err)
+	conn, err := getChat("ws"+server.URL[4:], token)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	assert.NoError(t, writeClientText(conn, "/whois user02"))
+	f, err := readFrame(conn)
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.EventUser, f.Event)
+	assert.Equal(t, "user02, registered 2023-08-01", f.Body)
+	assert.Equal(t, &protocol.User{
+		Nickname:  "user02",
+		CreatedAt: time.Date(2023, 8, 1, 12, 0, 0, 0, time.UTC),
+	}, f.User)
+
+	// frame is sent as is to the client, so it must not leak password hash
+	data, err := protocol.Encode(f)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "hash")
+
+	assert.NoError(t, writeClientText(conn, "/whois nobody"))
+	f, err = readFrame(conn)
 	assert.NoError(t, err)
+	assert.Equal(t, protocol.ErrCodeNotFound, f.Error.Code)
 }
@@ -0,0 +1,148 @@
+package wsserver
+
+import (
+	"console-chat/internal/protocol"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+)
+
+// newID generates random unique ID of the session, the server instance or
+// the direct message
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatal("can't generate id:", err.Error())
+	}
+	return hex.EncodeToString(b)
+}
+
+// addSession adds new session of the user to the server, returns true if it
+// is the first session of the user
+func (s *wsServer) addSession(c *client) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sessions, ok := s.connections[c.nickname]
+	if !ok {
+		sessions = make(map[string]*client)
+		s.connections[c.nickname] = sessions
+	}
+	sessions[c.sessionID] = c
+	if s.closing {
+		s.closeSession(c, protocol.CloseGoingAway, "server is shutting down")
+	}
+	return len(sessions) == 1
+}
+
+// removeSession removes the session from the server, returns true if it was
+// the last session of the user
+func (s *wsServer) removeSession(c *client) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sessions, ok := s.connections[c.nickname]
+	if !ok {
+		return false
+	}
+	if _, ok := sessions[c.sessionID]; !ok {
+		return false
+	}
+	delete(sessions, c.sessionID)
+	if len(sessions) == 0 {
+		delete(s.connections, c.nickname)
+		return true
+	}
+	return false
+}
+
+// isOnline checks if user has at least one session in the cluster
+func (s *wsServer) isOnline(nickname string) bool {
+	online, err := s.broker.IsOnline(context.Background(), nickname)
+	if err != nil {
+		log.Println("can't check if", nickname, "is online:", err.Error())
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return len(s.connections[nickname]) != 0
+	}
+	return online
+}
+
+// deliver queues encoded frame of other user to the session or holds it
+// until the session gets history. Should be called under s.mu
+func (s *wsServer) deliver(c *client, f protocol.Frame, data []byte) bool {
+	if c.holding {
+		c.held = append(c.held, heldFrame{room: f.Room, seq: f.Seq, data: data})
+		return true
+	}
+	return s.enqueue(c, data)
+}
+
+// markSent remembers the latest message of the room sent to the new session
+// from history
+func (s *wsServer) markSent(c *client, roomName string, seq int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if c.holding && seq > c.sentSeqs[roomName] {
+		c.sentSeqs[roomName] = seq
+	}
+}
+
+// releaseHeld queues frames held while the session was getting history,
+// room messages which were already sent from history are dropped
+func (s *wsServer) releaseHeld(c *client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, h := range c.held {
+		if h.seq != 0 && h.seq <= c.sentSeqs[h.room] {
+			continue
+		}
+		s.enqueue(c, h.data)
+	}
+	c.holding, c.held, c.sentSeqs = false, nil, nil
+}
+
+// sendToSession sends frame only to the given session of the user
+func (s *wsServer) sendToSession(c *client, f protocol.Frame) bool {
+	data, err := protocol.Encode(f)
+	if err != nil {
+		log.Println("can't encode frame:", err.Error())
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.connections[c.nickname][c.sessionID]; !ok {
+		return false
+	}
+	return s.enqueue(c, data)
+}
+
+// deliverToUser sends frame to all sessions of the user on this instance
+// except the session with given ID, returns false if frame wasn't sent to
+// any session
+func (s *wsServer) deliverToUser(nickname, exceptSession string, f protocol.Frame) bool {
+	data, err := protocol.Encode(f)
+	if err != nil {
+		log.Println("can't encode frame:", err.Error())
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sent := false
+	for _, c := range s.connections[nickname] {
+		if c.sessionID == exceptSession {
+			continue
+		}
+		if s.deliver(c, f, data) {
+			sent = true
+		}
+	}
+	return sent
+}
@@ -0,0 +1,94 @@
+package wsserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultipleSessions(t *testing.T) {
+	wsserver := New(testKeyring(), newTestApp(), Config{})
+	server := httptest.NewServer(http.HandlerFunc(wsserver.Chat))
+	defer server.Close()
+
+	url := "ws" + server.URL[4:]
+
+	// user02 joins the chat
+	token02, err := codeNicknameInToken("user02")
+	assert.NoError(t, err)
+	conn02, err := getChat(url, token02)
+	assert.NoError(t, err)
+	defer conn02.Close()
+
+	// user01 joins the chat from two terminals
+	token01, err := codeNicknameInToken("user01")
+	assert.NoError(t, err)
+	conn01a, err := getChat(url, token01)
+	assert.NoError(t, err)
+	defer conn01a.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	conn01b, err := getChat(url, token01)
+	assert.NoError(t, err)
+	defer conn01b.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	// user02 gets only one join notice
+	msg, err := readServerText(conn02)
+	assert.Equal(t, "[general] user01 joins the room", msg)
+	assert.NoError(t, err)
+
+	// message from the first session goes to user02 and to the second session
+	err = writeClientText(conn01a, "Ping")
+	assert.NoError(t, err)
+
+	time.Sleep(100 * time.Millisecond)
+
+	msg, err = readServerText(conn02)
+	assert.Equal(t, "[general] user01: Ping", msg)
+	assert.NoError(t, err)
+	msg, err = readServerText(conn01b)
+	assert.Equal(t, "[general] user01: Ping", msg)
+	assert.NoError(t, err)
+
+	// direct message to user01 goes to both sessions
+	err = writeDirect(conn02, "user01", "Pong")
+	assert.NoError(t, err)
+
+	time.Sleep(100 * time.Millisecond)
+
+	msg, err = readServerText(conn01a)
+	assert.Equal(t, "[dm] user02 -> user01: Pong", msg)
+	assert.NoError(t, err)
+	msg, err = readServerText(conn01b)
+	assert.Equal(t, "[dm] user02 -> user01: Pong", msg)
+	assert.NoError(t, err)
+
+	// closing the first session doesn't remove user01 from the chat
+	_ = conn01a.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	err = writeClientText(conn01b, "Ping 2")
+	assert.NoError(t, err)
+
+	time.Sleep(100 * time.Millisecond)
+
+	msg, err = readServerText(conn02)
+	assert.Equal(t, "[general] user01: Ping 2", msg)
+	assert.NoError(t, err)
+
+	// closing the last session does
+	_ = conn01b.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	msg, err = readServerText(conn02)
+	assert.Equal(t, "[general] user01 leaves the room", msg)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,136 @@
+package wsserver
+
+import (
+	"console-chat/internal/protocol"
+	"sync"
+	"time"
+)
+
+// defaultTypingInterval is how often typing start of the user to the same
+// room or user is relayed if interval isn't configured
+const defaultTypingInterval = 2 * time.Second
+
+// typingKey is the user typing a message to the room or to the user
+type typingKey struct {
+	nickname string
+	room     string
+	to       string
+}
+
+// typingState remembers when typing starts were relayed to throttle them
+// and to drop stops of users who aren't typing
+type typingState struct {
+	mu       sync.Mutex
+	interval time.Duration
+	started  map[typingKey]time.Time
+}
+
+func newTypingState(interval time.Duration) *typingState {
+	return &typingState{
+		interval: interval,
+		started:  make(map[typingKey]time.Time),
+	}
+}
+
+// check decides if typing event should be relayed: start is relayed if the
+// previous one was relayed at least interval ago, stop is relayed only if the
+// user is shown as typing
+func (ts *typingState) check(key typingKey, event string, now time.Time) bool {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	last, typing := ts.started[key]
+	if typing && now.Sub(last) >= protocol.TypingTimeout {
+		// receivers have already hidden the expired start
+		delete(ts.started, key)
+		typing = false
+	}
+
+	switch event {
+	case protocol.TypingStart:
+		if typing && now.Sub(last) < ts.interval {
+			return false
+		}
+		ts.started[key] = now
+		return true
+	case protocol.TypingStop:
+		delete(ts.started, key)
+		return typing
+	}
+	return false
+}
+
+// reset forgets typing of the user who has sent the message, receivers hide
+// typing of the sender when they get the message
+func (ts *typingState) reset(key typingKey) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	delete(ts.started, key)
+}
+
+// forgetUser drops typing state of the user who left the chat, returns rooms
+// and users the user was typing to
+func (ts *typingState) forgetUser(nickname string, now time.Time) []typingKey {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	var typing []typingKey
+	for key, last := range ts.started {
+		if key.nickname != nickname {
+			continue
+		}
+		if now.Sub(last) < protocol.TypingTimeout {
+			typing = append(typing, key)
+		}
+		delete(ts.started, key)
+	}
+	return typing
+}
+
+// handleTyping relays typing frame of the client to the room or to the user
+func (s *wsServer) handleTyping(c *client, f protocol.Frame) {
+	if f.Event != protocol.TypingStart && f.Event != protocol.TypingStop {
+		s.reject(c, f.ID, protocol.ErrCodeBadRequest, "typing event should be "+protocol.TypingStart+" or "+protocol.TypingStop)
+		return
+	}
+
+	key := typingKey{nickname: c.nickname}
+	if f.To != "" {
+		if f.To == c.nickname {
+			return
+		}
+		key.to = f.To
+	} else {
+		key.room = c.currentRoom
+		if f.Room != "" {
+			key.room = f.Room
+		}
+		if key.room == "" || !s.isRoomMember(c.nickname, key.room) {
+			s.reject(c, f.ID, protocol.ErrCodeBadRequest, "you are not a member of the room "+key.room)
+			return
+		}
+	}
+
+	if s.typing.check(key, f.Event, time.Now()) {
+		s.sendTyping(c, key, f.Event)
+	}
+}
+
+// sendTyping sends typing event of the client to the room or to the user in
+// the cluster
+func (s *wsServer) sendTyping(c *client, key typingKey, event string) {
+	f := protocol.Frame{
+		Type:      protocol.TypeTyping,
+		Event:     event,
+		Room:      key.room,
+		Sender:    key.nickname,
+		To:        key.to,
+		Timestamp: time.Now().UTC(),
+	}
+	if key.to != "" {
+		s.sendToUser(key.to, f)
+	} else {
+		s.sendToRoom(key.room, c, f)
+	}
+}
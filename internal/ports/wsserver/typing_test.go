@@ -0,0 +1,119 @@
+package wsserver
+
+import (
+	"console-chat/internal/protocol"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTyping sends typing event to the room or to the user
+func writeTyping(conn net.Conn, event, room, to string) error {
+	data, err := protocol.Encode(protocol.Frame{
+		Type:  protocol.TypeTyping,
+		Event: event,
+		Room:  room,
+		To:    to,
+	})
+	if err != nil {
+		return err
+	}
+	return wsutil.WriteClientMessage(conn, ws.OpText, data)
+}
+
+func TestTypingState(t *testing.T) {
+	ts := newTypingState(2 * time.Second)
+	key := typingKey{nickname: "user01", room: "general"}
+	now := time.Now()
+
+	// stop without start isn't relayed
+	assert.False(t, ts.check(key, protocol.TypingStop, now))
+
+	// repeated starts are relayed once per interval
+	assert.True(t, ts.check(key, protocol.TypingStart, now))
+	assert.False(t, ts.check(key, protocol.TypingStart, now.Add(time.Second)))
+	assert.True(t, ts.check(key, protocol.TypingStart, now.Add(2*time.Second)))
+	assert.True(t, ts.check(key, protocol.TypingStop, now.Add(3*time.Second)))
+	assert.False(t, ts.check(key, protocol.TypingStop, now.Add(3*time.Second)))
+
+	// sent message resets typing, so the next start is relayed at once
+	assert.True(t, ts.check(key, protocol.TypingStart, now))
+	ts.reset(key)
+	assert.True(t, ts.check(key, protocol.TypingStart, now.Add(time.Second)))
+
+	// expired start doesn't need stop
+	assert.False(t, ts.check(key, protocol.TypingStop, now.Add(time.Second+protocol.TypingTimeout)))
+
+	dm := typingKey{nickname: "user01", to: "user02"}
+	assert.True(t, ts.check(key, protocol.TypingStart, now))
+	assert.True(t, ts.check(dm, protocol.TypingStart, now))
+	assert.ElementsMatch(t, []typingKey{key, dm}, ts.forgetUser("user01", now.Add(time.Second)))
+	assert.Empty(t, ts.forgetUser("user01", now.Add(time.Second)))
+}
+
+func TestTyping(t *testing.T) {
+	wsserver := New(testKeyring(), newTestApp(), Config{})
+	server := httptest.NewServer(http.HandlerFunc(wsserver.Chat))
+	defer server.Close()
+	url := "ws" + server.URL[4:]
+
+	conn01 := connect(t, url, "user01")
+	defer conn01.Close()
+	conn02 := connect(t, url, "user02")
+	defer conn02.Close()
+	expectTexts(t, conn01, "[general] user02 joins the room")
+
+	// repeated start is throttled, typing isn't saved
+	assert.NoError(t, writeTyping(conn02, protocol.TypingStart, "", ""))
+	assert.NoError(t, writeTyping(conn02, protocol.TypingStart, "", ""))
+	f, err := readFrame(conn01)
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.TypeTyping, f.Type)
+	assert.Equal(t, protocol.TypingStart, f.Event)
+	assert.Equal(t, "general", f.Room)
+	assert.Equal(t, "user02", f.Sender)
+	assert.NoError(t, writeClientText(conn02, "Hi"))
+	expectTexts(t, conn01, "[general] user02: Hi")
+	assert.NoError(t, writeTyping(conn02, protocol.TypingStop, "", ""))
+	assert.NoError(t, writeClientText(conn02, "/history"))
+	expectTexts(t, conn02, "[general] no more messages")
+
+	// typing of direct message is sent only to the recipient
+	assert.NoError(t, writeTyping(conn02, protocol.TypingStart, "", "user01"))
+	f, err = readFrame(conn01)
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.TypeTyping, f.Type)
+	assert.Equal(t, "user01", f.To)
+	assert.Equal(t, "", f.Room)
+
+	assert.NoError(t, writeTyping(conn02, protocol.TypingStart, "golang", ""))
+	f, err = readFrame(conn02)
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.ErrCodeBadRequest, f.Error.Code)
+	assert.NoError(t, writeTyping(conn02, "paused", "", ""))
+	f, err = readFrame(conn02)
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.ErrCodeBadRequest, f.Error.Code)
+
+	// user stops typing on leaving the chat
+	assert.NoError(t, writeTyping(conn02, protocol.TypingStart, "", ""))
+	f, err = readFrame(conn01)
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.TypingStart, f.Event)
+	assert.NoError(t, conn02.Close())
+	var stopped []string
+	for i := 0; i < 2; i++ {
+		f, err = readFrame(conn01)
+		assert.NoError(t, err)
+		assert.Equal(t, protocol.TypingStop, f.Event)
+		stopped = append(stopped, f.Room+f.To)
+	}
+	assert.ElementsMatch(t, []string{"general", "user01"}, stopped)
+	expectTexts(t, conn01, "[general] user02 leaves the room")
+}
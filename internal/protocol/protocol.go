@@ -0,0 +1,233 @@
+// Package protocol describes frames which are sent between chat server and
+// its clients over websocket. Every frame is a JSON-encoded Frame.
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Version is the latest version of the protocol
+const Version = 1
+
+// SupportedVersions are all protocol versions this package can speak
+var SupportedVersions = []int{1}
+
+type Type string
+
+const (
+	// TypeAuth is the first frame sent by client, Body contains the token and
+	// Versions contain protocol versions supported by client. Client which
+	// reconnects after losing the connection sets Event to AuthResume
+	TypeAuth Type = "auth"
+
+	// TypeMessage is a chat message. Client sends only Body and optionally
+	// Room or To for direct messages and its own ID to get ack, server fills
+	// in ID, Room, Sender and Timestamp. Room messages also get Seq which
+	// increases by one with every message of the room
+	TypeMessage Type = "message"
+
+	// TypeCommand is a command from client to server, Body contains command
+	// line like "/join golang"
+	TypeCommand Type = "command"
+
+	// TypeSystem is an event or a notice from server, Event contains its kind
+	TypeSystem Type = "system"
+
+	// TypeAck confirms that client frame with ID ReplyTo was accepted. Ack
+	// of the message has ID, Seq and Timestamp assigned to it by server
+	TypeAck Type = "ack"
+
+	// TypeError reports that client frame with ID ReplyTo was rejected
+	TypeError Type = "error"
+
+	// TypeTyping tells that the user started or stopped typing a message to
+	// Room or to user To, Event is TypingStart or TypingStop. Client sends
+	// only Event and optionally Room or To, server fills in Room, Sender and
+	// Timestamp. Typing frames aren't saved to history
+	TypeTyping Type = "typing"
+)
+
+// Kinds of system events
+const (
+	EventJoin     = "join"
+	EventLeave    = "leave"
+	EventInfo     = "info"
+	EventUser     = "user"     // User contains public profile of the requested user
+	EventPresence = "presence" // Presence contains changed state of the user
+	EventOnline   = "online"   // Presence contains all requested users which are online
+)
+
+// Events of typing frames
+const (
+	TypingStart = "start"
+	TypingStop  = "stop"
+)
+
+// AuthResume is an event of auth frame which resumes the lost session:
+// server returns the user to the rooms of that session if it was the last
+// one and sends messages of rooms from Resume with Seq greater than the one
+// the client has seen, instead of the latest history
+const AuthResume = "resume"
+
+// TypingTimeout is how long the user is shown as typing after the last
+// typing start, client repeats typing start while the user keeps typing
+const TypingTimeout = 6 * time.Second
+
+// Presence statuses of users
+const (
+	StatusOnline  = "online"
+	StatusAway    = "away"
+	StatusOffline = "offline"
+)
+
+// Error codes
+const (
+	ErrCodeProtocol           = "protocol_error"
+	ErrCodeUnsupportedVersion = "unsupported_version"
+	ErrCodeUnauthorized       = "unauthorized"
+	ErrCodeTokenExpired       = "token_expired"
+	ErrCodeBadRequest         = "bad_request"
+	ErrCodeNotFound           = "not_found"
+	ErrCodeOffline            = "user_offline"
+	ErrCodeRateLimited        = "rate_limited"
+	ErrCodeMuted              = "muted"
+	ErrCodeTooLarge           = "too_large"
+	ErrCodeInvalidText        = "invalid_text"
+	ErrCodeModerated          = "moderated"
+	ErrCodeInternal           = "internal_error"
+)
+
+// Status codes of websocket close frames sent by server. Codes 4000-4999 are
+// reserved by RFC 6455 for applications
+const (
+	CloseNormal             = 1000
+	CloseGoingAway          = 1001 // server is shutting down
+	CloseProtocolError      = 1002
+	CloseMessageTooBig      = 1009
+	CloseInternalError      = 1011
+	CloseBadToken           = 4001
+	CloseTokenExpired       = 4002
+	CloseAuthTimeout        = 4003
+	CloseUnsupportedVersion = 4004
+	CloseTooSlow            = 4005
+	CloseTokenRevoked       = 4006
+)
+
+// Error is a description of rejected client frame
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// User is a public representation of the user. It is the only form in which
+// users are sent to clients both over websocket and http, so it must never
+// contain password hashes or other credentials
+type User struct {
+	Nickname  string    `json:"nickname"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// NewUser creates public representation of the user
+func NewUser(nickname string, createdAt time.Time) User {
+	return User{
+		Nickname:  nickname,
+		CreatedAt: createdAt.UTC().Truncate(time.Second),
+	}
+}
+
+// Presence is a presence state of the user, LastSeen is when the user
+// connected, disconnected or changed status last time
+type Presence struct {
+	Nickname string    `json:"nickname"`
+	Status   string    `json:"status"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// Frame is an envelope of everything sent over websocket
+type Frame struct {
+	Version   int        `json:"version"`
+	Type      Type       `json:"type"`
+	ID        string     `json:"id,omitempty"`
+	Seq       int64      `json:"seq,omitempty"`
+	ReplyTo   string     `json:"reply_to,omitempty"`
+	Room      string     `json:"room,omitempty"`
+	Sender    string     `json:"sender,omitempty"`
+	To        string     `json:"to,omitempty"`
+	Timestamp time.Time  `json:"timestamp"`
+	Event     string     `json:"event,omitempty"`
+	Body      string     `json:"body,omitempty"`
+	Versions  []int      `json:"versions,omitempty"`
+	Error     *Error     `json:"error,omitempty"`
+	User      *User      `json:"user,omitempty"`
+	Presence  []Presence `json:"presence,omitempty"`
+
+	// Resume maps rooms to the last Seq the client has seen in them
+	Resume map[string]int64 `json:"resume,omitempty"`
+}
+
+var ErrInvalidFrame = errors.New("frame is not a valid protocol frame")
+
+// Encode serializes frame, setting its version if it is empty
+func Encode(f Frame) ([]byte, error) {
+	if f.Version == 0 {
+		f.Version = Version
+	}
+	return json.Marshal(f)
+}
+
+// Decode parses frame and checks that it has a type
+func Decode(data []byte) (Frame, error) {
+	var f Frame
+	if err := json.Unmarshal(data, &f); err != nil {
+		return Frame{}, ErrInvalidFrame
+	}
+	if f.Type == "" {
+		return Frame{}, ErrInvalidFrame
+	}
+	return f, nil
+}
+
+// Negotiate chooses the highest protocol version supported by both sides,
+// returns false if there is no such version
+func Negotiate(clientVersions []int) (int, bool) {
+	best := 0
+	for _, v := range clientVersions {
+		for _, supported := range SupportedVersions {
+			if v == supported && v > best {
+				best = v
+			}
+		}
+	}
+	return best, best != 0
+}
+
+// NewError creates error frame replying to the client frame with given ID
+func NewError(replyTo, code, message string) Frame {
+	return Frame{
+		Type:      TypeError,
+		ReplyTo:   replyTo,
+		Timestamp: time.Now().UTC(),
+		Error: &Error{
+			Code:    code,
+			Message: message,
+		},
+	}
+}
+
+// NewSystem creates system event frame, room may be empty for events which
+// aren't related to any room
+func NewSystem(room, event, body string) Frame {
+	return Frame{
+		Type:      TypeSystem,
+		Room:      room,
+		Timestamp: time.Now().UTC(),
+		Event:     event,
+		Body:      body,
+	}
+}
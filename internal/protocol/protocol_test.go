@@ -0,0 +1,65 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type negotiateTest struct {
+	description     string
+	clientVersions  []int
+	expectedVersion int
+	expectedOk      bool
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []negotiateTest{
+		{
+			description:     "same version",
+			clientVersions:  []int{1},
+			expectedVersion: 1,
+			expectedOk:      true,
+		},
+		{
+			description:     "client supports newer versions too",
+			clientVersions:  []int{1, 2, 3},
+			expectedVersion: 1,
+			expectedOk:      true,
+		},
+		{
+			description:    "no common versions",
+			clientVersions: []int{2},
+			expectedOk:     false,
+		},
+		{
+			description: "no versions",
+			expectedOk:  false,
+		},
+	}
+
+	for _, test := range tests {
+		version, ok := Negotiate(test.clientVersions)
+		assert.Equal(t, test.expectedVersion, version, test.description)
+		assert.Equal(t, test.expectedOk, ok, test.description)
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	data, err := Encode(Frame{Type: TypeMessage, Room: "general", Sender: "papey08", Body: "Ping"})
+	assert.NoError(t, err)
+
+	f, err := Decode(data)
+	assert.NoError(t, err)
+	assert.Equal(t, Version, f.Version)
+	assert.Equal(t, TypeMessage, f.Type)
+	assert.Equal(t, "general", f.Room)
+	assert.Equal(t, "papey08", f.Sender)
+	assert.Equal(t, "Ping", f.Body)
+
+	_, err = Decode([]byte("eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9"))
+	assert.Equal(t, ErrInvalidFrame, err)
+
+	_, err = Decode([]byte(`{"body": "Ping"}`))
+	assert.Equal(t, ErrInvalidFrame, err)
+}
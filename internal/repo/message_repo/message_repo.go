@@ -0,0 +1,200 @@
+package messagerepo
+
+import (
+	"console-chat/internal/app"
+	"console-chat/internal/model"
+	"context"
+	"log"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// nextSeqQuery is a query to take the next sequence number of the room
+	nextSeqQuery = `
+		INSERT INTO room_sequences (room, seq)
+		VALUES ($1, 1)
+		ON CONFLICT (room) DO UPDATE SET seq = room_sequences.seq + 1
+		RETURNING seq;`
+
+	// addMessageQuery is a query to insert message into database
+	addMessageQuery = `
+		INSERT INTO messages (room, seq, sender, body, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id;`
+
+	// getLatestMessagesQuery is a query to select latest messages of the room
+	getLatestMessagesQuery = `
+		SELECT id, seq, room, sender, body, created_at FROM messages
+		WHERE room = $1
+		ORDER BY id DESC
+		LIMIT $2;`
+
+	// getMessagesBeforeQuery is a query to select messages of the room older
+	// than message with given id
+	getMessagesBeforeQuery = `
+		SELECT id, seq, room, sender, body, created_at FROM messages
+		WHERE room = $1 AND id < $2
+		ORDER BY id DESC
+		LIMIT $3;`
+
+	// getMessagesAfterQuery is a query to select latest messages of the room
+	// with sequence number greater than given one
+	getMessagesAfterQuery = `
+		SELECT id, seq, room, sender, body, created_at FROM messages
+		WHERE room = $1 AND seq > $2
+		ORDER BY seq DESC
+		LIMIT $3;`
+
+	// addDeliveryQuery is a query to queue message for the recipient
+	addDeliveryQuery = `
+		INSERT INTO deliveries (recipient, message_id, room, sender, to_user, body, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id;`
+
+	// getDeliveriesQuery is a query to select undelivered messages of the recipient
+	getDeliveriesQuery = `
+		SELECT id, recipient, message_id, room, sender, to_user, body, created_at FROM deliveries
+		WHERE recipient = $1 AND delivered_at IS NULL
+		ORDER BY id;`
+
+	// setDeliveredQuery is a query to mark messages of the recipient as delivered
+	setDeliveredQuery = `
+		UPDATE deliveries
+		SET delivered_at = now()
+		WHERE recipient = $1 AND id = ANY($2) AND delivered_at IS NULL;`
+)
+
+// Repo is a permanent storage of all chat messages. Messages are saved from
+// goroutines of all connected clients, so queries use the pool of connections
+// instead of the single one
+type Repo struct {
+	*pgxpool.Pool
+}
+
+func New(pool *pgxpool.Pool) app.MessageRepo {
+	return &Repo{
+		Pool: pool,
+	}
+}
+
+func (r *Repo) AddMessage(ctx context.Context, msg model.Message) (model.Message, error) {
+	// sequence number is taken in the same transaction, so numbers of
+	// messages which weren't saved are given again
+	tx, err := r.Begin(ctx)
+	if err != nil {
+		// debug info
+		log.Println(err.Error())
+		return model.Message{}, model.MessageRepoError
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	if err := tx.QueryRow(ctx, nextSeqQuery, msg.Room).Scan(&msg.Seq); err != nil {
+		// debug info
+		log.Println(err.Error())
+		return model.Message{}, model.MessageRepoError
+	}
+	row := tx.QueryRow(ctx, addMessageQuery, msg.Room, msg.Seq, msg.Sender, msg.Text, msg.CreatedAt)
+	if err := row.Scan(&msg.ID); err != nil {
+		// debug info
+		log.Println(err.Error())
+		return model.Message{}, model.MessageRepoError
+	}
+	if err := tx.Commit(ctx); err != nil {
+		// debug info
+		log.Println(err.Error())
+		return model.Message{}, model.MessageRepoError
+	}
+	return msg, nil
+}
+
+func (r *Repo) GetMessages(ctx context.Context, room string, beforeID int64, limit int) ([]model.Message, error) {
+	var rows pgx.Rows
+	var err error
+	if beforeID > 0 {
+		rows, err = r.Query(ctx, getMessagesBeforeQuery, room, beforeID, limit)
+	} else {
+		rows, err = r.Query(ctx, getLatestMessagesQuery, room, limit)
+	}
+	if err != nil {
+		// debug info
+		log.Println(err.Error())
+		return nil, model.MessageRepoError
+	}
+	return scanMessages(rows, limit)
+}
+
+func (r *Repo) GetMessagesAfter(ctx context.Context, room string, afterSeq int64, limit int) ([]model.Message, error) {
+	rows, err := r.Query(ctx, getMessagesAfterQuery, room, afterSeq, limit)
+	if err != nil {
+		// debug info
+		log.Println(err.Error())
+		return nil, model.MessageRepoError
+	}
+	return scanMessages(rows, limit)
+}
+
+// scanMessages reads messages selected by the query and closes rows
+func scanMessages(rows pgx.Rows, limit int) ([]model.Message, error) {
+	defer rows.Close()
+
+	msgs := make([]model.Message, 0, limit)
+	for rows.Next() {
+		var msg model.Message
+		if err := rows.Scan(&msg.ID, &msg.Seq, &msg.Room, &msg.Sender, &msg.Text, &msg.CreatedAt); err != nil {
+			return nil, model.MessageRepoError
+		}
+		msgs = append(msgs, msg)
+	}
+	if rows.Err() != nil {
+		return nil, model.MessageRepoError
+	}
+	return msgs, nil
+}
+
+func (r *Repo) AddDelivery(ctx context.Context, d model.Delivery) (model.Delivery, error) {
+	row := r.QueryRow(ctx, addDeliveryQuery,
+		d.Recipient, d.Message.ID, d.Message.Room, d.Message.Sender, d.Message.To, d.Message.Text, d.Message.CreatedAt)
+	if err := row.Scan(&d.ID); err != nil {
+		// debug info
+		log.Println(err.Error())
+		return model.Delivery{}, model.MessageRepoError
+	}
+	return d, nil
+}
+
+func (r *Repo) GetDeliveries(ctx context.Context, recipient string) ([]model.Delivery, error) {
+	rows, err := r.Query(ctx, getDeliveriesQuery, recipient)
+	if err != nil {
+		// debug info
+		log.Println(err.Error())
+		return nil, model.MessageRepoError
+	}
+	defer rows.Close()
+
+	deliveries := make([]model.Delivery, 0)
+	for rows.Next() {
+		var d model.Delivery
+		if err := rows.Scan(&d.ID, &d.Recipient, &d.Message.ID, &d.Message.Room, &d.Message.Sender,
+			&d.Message.To, &d.Message.Text, &d.Message.CreatedAt); err != nil {
+			return nil, model.MessageRepoError
+		}
+		deliveries = append(deliveries, d)
+	}
+	if rows.Err() != nil {
+		return nil, model.MessageRepoError
+	}
+	return deliveries, nil
+}
+
+func (r *Repo) SetDelivered(ctx context.Context, recipient string, ids []int64) error {
+	if _, err := r.Exec(ctx, setDeliveredQuery, recipient, ids); err != nil {
+		// debug info
+		log.Println(err.Error())
+		return model.MessageRepoError
+	}
+	return nil
+}
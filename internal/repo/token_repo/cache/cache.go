@@ -0,0 +1,126 @@
+package cache
+
+import (
+	"console-chat/internal/model"
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// expiration is the longest time refresh token stays in cache
+const expiration = time.Minute * 30
+
+// keyPrefix separates refresh tokens from users stored in the same redis
+const keyPrefix = "refresh_token:"
+
+// revokedKeyPrefix separates revoked token IDs from other keys
+const revokedKeyPrefix = "revoked_token:"
+
+type cachedRefreshToken struct {
+	Hash      string    `json:"hash"`
+	Family    string    `json:"family"`
+	Nickname  string    `json:"nickname"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	UsedAt    time.Time `json:"used_at"`
+	Revoked   bool      `json:"revoked"`
+}
+
+func tokenToCachedToken(t model.RefreshToken) cachedRefreshToken {
+	return cachedRefreshToken{
+		Hash:      t.Hash,
+		Family:    t.Family,
+		Nickname:  t.Nickname,
+		CreatedAt: t.CreatedAt,
+		ExpiresAt: t.ExpiresAt,
+		UsedAt:    t.UsedAt,
+		Revoked:   t.Revoked,
+	}
+}
+
+func cachedTokenToToken(t cachedRefreshToken) model.RefreshToken {
+	return model.RefreshToken{
+		Hash:      t.Hash,
+		Family:    t.Family,
+		Nickname:  t.Nickname,
+		CreatedAt: t.CreatedAt,
+		ExpiresAt: t.ExpiresAt,
+		UsedAt:    t.UsedAt,
+		Revoked:   t.Revoked,
+	}
+}
+
+// CacheRepo is a temporary storage of refresh tokens which makes their
+// exchange faster
+type CacheRepo struct {
+	redis.Client
+}
+
+func (c *CacheRepo) SetRefreshToken(ctx context.Context, t model.RefreshToken) (model.RefreshToken, error) {
+	ttl := time.Until(t.ExpiresAt)
+	if ttl <= 0 {
+		return t, nil
+	} else if ttl > expiration {
+		ttl = expiration
+	}
+	data, _ := json.Marshal(tokenToCachedToken(t))
+	if err := c.Set(ctx, keyPrefix+t.Hash, data, ttl).Err(); err != nil {
+		return model.RefreshToken{}, model.TokenRepoError
+	}
+	return t, nil
+}
+
+func (c *CacheRepo) GetRefreshToken(ctx context.Context, hash string) (model.RefreshToken, error) {
+	recievedData, err := c.Get(ctx, keyPrefix+hash).Result()
+	if err == redis.Nil {
+		return model.RefreshToken{}, model.RefreshTokenInvalid
+	} else if err != nil {
+		return model.RefreshToken{}, model.TokenRepoError
+	}
+
+	var recievedToken cachedRefreshToken
+	if err := json.Unmarshal([]byte(recievedData), &recievedToken); err != nil {
+		return model.RefreshToken{}, model.TokenRepoError
+	}
+	return cachedTokenToToken(recievedToken), nil
+}
+
+func (c *CacheRepo) DeleteRefreshTokens(ctx context.Context, hashes ...string) error {
+	if len(hashes) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(hashes))
+	for _, hash := range hashes {
+		keys = append(keys, keyPrefix+hash)
+	}
+	if err := c.Del(ctx, keys...).Err(); err != nil {
+		return model.TokenRepoError
+	}
+	return nil
+}
+
+func (c *CacheRepo) AddRevokedToken(ctx context.Context, id string, ttl time.Duration) error {
+	if err := c.Set(ctx, revokedKeyPrefix+id, 1, ttl).Err(); err != nil {
+		return model.TokenRepoError
+	}
+	return nil
+}
+
+func (c *CacheRepo) IsTokenRevoked(ctx context.Context, ids ...string) (bool, error) {
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if id != "" {
+			keys = append(keys, revokedKeyPrefix+id)
+		}
+	}
+	if len(keys) == 0 {
+		return false, nil
+	}
+	n, err := c.Exists(ctx, keys...).Result()
+	if err != nil {
+		return false, model.TokenRepoError
+	}
+	return n > 0, nil
+}
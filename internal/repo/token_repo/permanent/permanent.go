@@ -0,0 +1,116 @@
+package permanent
+
+import (
+	"console-chat/internal/model"
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	// addRefreshTokenQuery is a query to insert refresh token into database
+	addRefreshTokenQuery = `
+		INSERT INTO refresh_tokens (hash, family, nickname, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5);`
+
+	// getRefreshTokenQuery is a query to select refresh token by its hash
+	getRefreshTokenQuery = `
+		SELECT hash, family, nickname, created_at, expires_at, used_at, revoked
+		FROM refresh_tokens
+		WHERE hash = $1;`
+
+	// useRefreshTokenQuery is a query to mark refresh token as exchanged, it
+	// changes nothing if the token was already exchanged or revoked
+	useRefreshTokenQuery = `
+		UPDATE refresh_tokens
+		SET used_at = now()
+		WHERE hash = $1 AND used_at IS NULL AND NOT revoked;`
+
+	// revokeTokenFamilyQuery is a query to revoke all refresh tokens of the family
+	revokeTokenFamilyQuery = `
+		UPDATE refresh_tokens
+		SET revoked = true
+		WHERE family = $1
+		RETURNING hash;`
+)
+
+// PermanentRepo is a permanent storage of refresh tokens, pgx.Conn isn't safe
+// for concurrent use so queries are serialized
+type PermanentRepo struct {
+	pgx.Conn
+	mu sync.Mutex
+}
+
+func (r *PermanentRepo) InsertRefreshToken(ctx context.Context, t model.RefreshToken) (model.RefreshToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, err := r.Exec(ctx, addRefreshTokenQuery, t.Hash, t.Family, t.Nickname, t.CreatedAt, t.ExpiresAt); err != nil {
+		// debug info
+		log.Println(err.Error())
+		return model.RefreshToken{}, model.TokenRepoError
+	}
+	return t, nil
+}
+
+func (r *PermanentRepo) SelectRefreshToken(ctx context.Context, hash string) (model.RefreshToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var t model.RefreshToken
+	var usedAt *time.Time
+	row := r.QueryRow(ctx, getRefreshTokenQuery, hash)
+	if err := row.Scan(&t.Hash, &t.Family, &t.Nickname, &t.CreatedAt, &t.ExpiresAt, &usedAt, &t.Revoked); err == pgx.ErrNoRows {
+		return model.RefreshToken{}, model.RefreshTokenInvalid
+	} else if err != nil {
+		return model.RefreshToken{}, model.TokenRepoError
+	}
+	if usedAt != nil {
+		t.UsedAt = *usedAt
+	}
+	return t, nil
+}
+
+func (r *PermanentRepo) UpdateRefreshTokenUsed(ctx context.Context, hash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tag, err := r.Exec(ctx, useRefreshTokenQuery, hash)
+	if err != nil {
+		// debug info
+		log.Println(err.Error())
+		return model.TokenRepoError
+	} else if tag.RowsAffected() == 0 {
+		return model.RefreshTokenReused
+	}
+	return nil
+}
+
+func (r *PermanentRepo) UpdateTokenFamilyRevoked(ctx context.Context, family string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rows, err := r.Query(ctx, revokeTokenFamilyQuery, family)
+	if err != nil {
+		// debug info
+		log.Println(err.Error())
+		return nil, model.TokenRepoError
+	}
+	defer rows.Close()
+
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, model.TokenRepoError
+		}
+		hashes = append(hashes, hash)
+	}
+	if rows.Err() != nil {
+		return nil, model.TokenRepoError
+	}
+	return hashes, nil
+}
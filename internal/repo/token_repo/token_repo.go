@@ -0,0 +1,111 @@
+package tokenrepo
+
+import (
+	"console-chat/internal/app"
+	"console-chat/internal/model"
+	"console-chat/internal/repo/token_repo/cache"
+	"console-chat/internal/repo/token_repo/permanent"
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/jackc/pgx/v5"
+)
+
+type permanentRepo interface {
+	// InsertRefreshToken adds refresh token to the permanent storage
+	InsertRefreshToken(ctx context.Context, t model.RefreshToken) (model.RefreshToken, error)
+
+	// SelectRefreshToken gets refresh token from the permanent storage
+	SelectRefreshToken(ctx context.Context, hash string) (model.RefreshToken, error)
+
+	// UpdateRefreshTokenUsed marks refresh token as exchanged if it wasn't
+	UpdateRefreshTokenUsed(ctx context.Context, hash string) error
+
+	// UpdateTokenFamilyRevoked revokes all tokens of the family and returns
+	// their hashes
+	UpdateTokenFamilyRevoked(ctx context.Context, family string) ([]string, error)
+}
+
+type cacheRepo interface {
+	// SetRefreshToken adds refresh token to the temporary storage
+	SetRefreshToken(ctx context.Context, t model.RefreshToken) (model.RefreshToken, error)
+
+	// GetRefreshToken gets refresh token from the temporary storage
+	GetRefreshToken(ctx context.Context, hash string) (model.RefreshToken, error)
+
+	// DeleteRefreshTokens removes outdated refresh tokens from the temporary storage
+	DeleteRefreshTokens(ctx context.Context, hashes ...string) error
+
+	// AddRevokedToken adds token ID to the revocation list which expires
+	// together with the token
+	AddRevokedToken(ctx context.Context, id string, ttl time.Duration) error
+
+	// IsTokenRevoked checks if any of IDs is in the revocation list
+	IsTokenRevoked(ctx context.Context, ids ...string) (bool, error)
+}
+
+// Repo keeps refresh tokens in postgres and caches them in redis. Exchange
+// and revocation always go to postgres, so stale cache can't make used or
+// revoked token valid again. Revocation list of access tokens is kept only
+// in redis as its entries live no longer than access tokens
+type Repo struct {
+	permanentRepo
+	cacheRepo
+}
+
+func New(conn *pgx.Conn, rc *redis.Client) app.TokenRepo {
+	return &Repo{
+		permanentRepo: &permanent.PermanentRepo{
+			Conn: *conn,
+		},
+		cacheRepo: &cache.CacheRepo{
+			Client: *rc,
+		},
+	}
+}
+
+func (r *Repo) AddRefreshToken(ctx context.Context, t model.RefreshToken) (model.RefreshToken, error) {
+	token, err := r.InsertRefreshToken(ctx, t) // add token to permanent db
+	if err != nil {
+		return model.RefreshToken{}, err
+	}
+	_, err = r.SetRefreshToken(ctx, t) // add token to cache
+	if err != nil {
+		return model.RefreshToken{}, err
+	}
+	return token, nil
+}
+
+func (r *Repo) GetRefreshToken(ctx context.Context, hash string) (model.RefreshToken, error) {
+	if token, err := r.cacheRepo.GetRefreshToken(ctx, hash); err == model.TokenRepoError { // case when something wrong with cache
+		return model.RefreshToken{}, err
+	} else if err == nil { // case when token was found in cache
+		return token, nil
+	}
+
+	// case when token is not in cache
+	if token, err := r.SelectRefreshToken(ctx, hash); err != nil {
+		return model.RefreshToken{}, err
+	} else {
+		if _, err := r.SetRefreshToken(ctx, token); err != nil {
+			return model.RefreshToken{}, err
+		}
+		return token, nil
+	}
+}
+
+func (r *Repo) UseRefreshToken(ctx context.Context, hash string) error {
+	if err := r.UpdateRefreshTokenUsed(ctx, hash); err != nil {
+		return err
+	}
+	return r.DeleteRefreshTokens(ctx, hash) // used token is never read again
+}
+
+func (r *Repo) RevokeTokenFamily(ctx context.Context, family string) error {
+	hashes, err := r.UpdateTokenFamilyRevoked(ctx, family)
+	if err != nil {
+		return err
+	}
+	return r.DeleteRefreshTokens(ctx, hashes...)
+}
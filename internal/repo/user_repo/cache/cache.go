@@ -12,15 +12,24 @@ import (
 // expiration is how long new user would stay in cache after registration
 const expiration = time.Minute * 30
 
+// attemptsKeyPrefix and lockKeyPrefix separate sign in throttling from users
+// stored in the same redis
+const (
+	attemptsKeyPrefix = "sign_in_attempts:"
+	lockKeyPrefix     = "sign_in_lock:"
+)
+
 type cachedUser struct {
-	Nickname       string `json:"nickname"`
-	HashedPassword string `json:"hashed_password"`
+	Nickname       string    `json:"nickname"`
+	HashedPassword string    `json:"hashed_password"`
+	CreatedAt      time.Time `json:"created_at"`
 }
 
 func usrToCashedUsr(u model.User) cachedUser {
 	return cachedUser{
 		Nickname:       u.Nickname,
 		HashedPassword: u.HashedPassword,
+		CreatedAt:      u.CreatedAt,
 	}
 }
 
@@ -28,6 +37,7 @@ func cachedUsrToUsr(u cachedUser) model.User {
 	return model.User{
 		Nickname:       u.Nickname,
 		HashedPassword: u.HashedPassword,
+		CreatedAt:      u.CreatedAt,
 	}
 }
 
@@ -61,3 +71,49 @@ func (c *CacheRepo) GetUserByKey(ctx context.Context, key string) (model.User, e
 		return cachedUsrToUsr(recievedUser), nil
 	}
 }
+
+func (c *CacheRepo) AddFailedSignIn(ctx context.Context, key string, window time.Duration) (int64, error) {
+	failures, err := c.Incr(ctx, attemptsKeyPrefix+key).Result()
+	if err != nil {
+		return 0, model.UserRepoError
+	}
+	if failures == 1 {
+		if err := c.Expire(ctx, attemptsKeyPrefix+key, window).Err(); err != nil {
+			return 0, model.UserRepoError
+		}
+	}
+	return failures, nil
+}
+
+func (c *CacheRepo) LockSignIn(ctx context.Context, key string, ttl time.Duration) error {
+	if err := c.Set(ctx, lockKeyPrefix+key, 1, ttl).Err(); err != nil {
+		return model.UserRepoError
+	}
+	return nil
+}
+
+func (c *CacheRepo) GetSignInLock(ctx context.Context, keys ...string) (time.Duration, error) {
+	var lock time.Duration
+	for _, key := range keys {
+		// PTTL is negative if key doesn't exist
+		ttl, err := c.PTTL(ctx, lockKeyPrefix+key).Result()
+		if err != nil {
+			return 0, model.UserRepoError
+		}
+		if ttl > lock {
+			lock = ttl
+		}
+	}
+	return lock, nil
+}
+
+func (c *CacheRepo) ResetFailedSignIns(ctx context.Context, keys ...string) error {
+	redisKeys := make([]string, 0, 2*len(keys))
+	for _, key := range keys {
+		redisKeys = append(redisKeys, attemptsKeyPrefix+key, lockKeyPrefix+key)
+	}
+	if err := c.Del(ctx, redisKeys...).Err(); err != nil {
+		return model.UserRepoError
+	}
+	return nil
+}
@@ -12,12 +12,18 @@ import (
 const (
 	// addUserQuery is a query to insert user into database
 	addUserQuery = `
-		INSERT INTO users (nickname, hashed_password)
-		VALUES ($1, $2);`
+		INSERT INTO users (nickname, hashed_password, created_at)
+		VALUES ($1, $2, $3);`
 
 	// getUserQuery is a query to select user from the database
 	getUserQuery = `
-		SELECT * FROM users
+		SELECT nickname, hashed_password, created_at FROM users
+		WHERE nickname = $1;`
+
+	// updateUserQuery is a query to update hashed password of the user
+	updateUserQuery = `
+		UPDATE users
+		SET hashed_password = $2
 		WHERE nickname = $1;`
 )
 
@@ -30,7 +36,7 @@ type PermanentRepo struct {
 }
 
 func (r *PermanentRepo) InsertUser(ctx context.Context, u model.User) (model.User, error) {
-	_, err := r.Exec(ctx, addUserQuery, u.Nickname, u.HashedPassword)
+	_, err := r.Exec(ctx, addUserQuery, u.Nickname, u.HashedPassword, u.CreatedAt)
 	if err != nil {
 		if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == duplicateCode {
 			return model.User{}, model.UserAlreadyExists
@@ -46,7 +52,7 @@ func (r *PermanentRepo) InsertUser(ctx context.Context, u model.User) (model.Use
 func (r *PermanentRepo) SelectUser(ctx context.Context, nickname string) (model.User, error) {
 	var usr model.User
 	row := r.QueryRow(ctx, getUserQuery, nickname)
-	if err := row.Scan(&usr.Nickname, &usr.HashedPassword); err == pgx.ErrNoRows {
+	if err := row.Scan(&usr.Nickname, &usr.HashedPassword, &usr.CreatedAt); err == pgx.ErrNoRows {
 		return model.User{}, model.UserNotFound
 	} else if err != nil {
 		return model.User{}, model.UserRepoError
@@ -54,3 +60,15 @@ func (r *PermanentRepo) SelectUser(ctx context.Context, nickname string) (model.
 		return usr, nil
 	}
 }
+
+func (r *PermanentRepo) UpdateUser(ctx context.Context, u model.User) (model.User, error) {
+	tag, err := r.Exec(ctx, updateUserQuery, u.Nickname, u.HashedPassword)
+	if err != nil {
+		// debug info
+		log.Println(err.Error())
+		return model.User{}, model.UserRepoError
+	} else if tag.RowsAffected() == 0 {
+		return model.User{}, model.UserNotFound
+	}
+	return u, nil
+}
@@ -6,6 +6,7 @@ import (
 	"console-chat/internal/repo/user_repo/cache"
 	"console-chat/internal/repo/user_repo/permanent"
 	"context"
+	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/jackc/pgx/v5"
@@ -17,6 +18,9 @@ type permanentRepo interface {
 
 	// SelectUser gets user from the permanent storage
 	SelectUser(ctx context.Context, nickname string) (model.User, error)
+
+	// UpdateUser replaces user data in the permanent storage
+	UpdateUser(ctx context.Context, u model.User) (model.User, error)
 }
 
 type cacheRepo interface {
@@ -25,6 +29,19 @@ type cacheRepo interface {
 
 	// GetUserByKey gets user from the temporary storage
 	GetUserByKey(ctx context.Context, key string) (model.User, error)
+
+	// AddFailedSignIn increments counter of failed sign in attempts which
+	// expires after window since the first attempt
+	AddFailedSignIn(ctx context.Context, key string, window time.Duration) (int64, error)
+
+	// LockSignIn locks sign in with the key for ttl
+	LockSignIn(ctx context.Context, key string, ttl time.Duration) error
+
+	// GetSignInLock returns the longest remaining lockout of the keys
+	GetSignInLock(ctx context.Context, keys ...string) (time.Duration, error)
+
+	// ResetFailedSignIns deletes counters and lockouts of the keys
+	ResetFailedSignIns(ctx context.Context, keys ...string) error
 }
 
 type Repo struct {
@@ -73,3 +90,15 @@ func (r *Repo) GetUser(ctx context.Context, nickname string) (model.User, error)
 	}
 
 }
+
+func (r *Repo) UpdateUser(ctx context.Context, u model.User) (model.User, error) {
+	usr, err := r.permanentRepo.UpdateUser(ctx, u) // update user in permanent db
+	if err != nil {
+		return model.User{}, err
+	}
+	_, err = r.SetUserByKey(ctx, u.Nickname, u) // replace outdated user in cache
+	if err != nil {
+		return model.User{}, err
+	}
+	return usr, nil
+}
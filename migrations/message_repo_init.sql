@@ -0,0 +1,23 @@
+CREATE TABLE messages (
+    id BIGSERIAL PRIMARY KEY,
+    room VARCHAR(25) NOT NULL,
+    sender VARCHAR(25) NOT NULL,
+    body TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX messages_room_id_idx ON messages (room, id DESC);
+
+CREATE TABLE deliveries (
+    id BIGSERIAL PRIMARY KEY,
+    recipient VARCHAR(25) NOT NULL,
+    message_id BIGINT NOT NULL DEFAULT 0, -- 0 for direct messages which aren't stored in messages
+    room VARCHAR(25) NOT NULL DEFAULT '',
+    sender VARCHAR(25) NOT NULL,
+    to_user VARCHAR(25) NOT NULL DEFAULT '',
+    body TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    delivered_at TIMESTAMPTZ
+);
+
+CREATE INDEX deliveries_undelivered_idx ON deliveries (recipient, id) WHERE delivered_at IS NULL;
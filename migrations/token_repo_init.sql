@@ -0,0 +1,11 @@
+CREATE TABLE refresh_tokens (
+    hash CHAR(64) PRIMARY KEY, -- sha256 of the token, token itself isn't stored
+    family VARCHAR(32) NOT NULL,
+    nickname VARCHAR(25) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ,
+    revoked BOOLEAN NOT NULL DEFAULT false
+);
+
+CREATE INDEX refresh_tokens_family_idx ON refresh_tokens (family);
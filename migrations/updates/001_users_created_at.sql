@@ -0,0 +1,2 @@
+-- registration time of users, users registered before it get time of the migration
+ALTER TABLE users ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now();
@@ -0,0 +1,27 @@
+-- sequence numbers of messages in their rooms, existing messages are
+-- numbered in order they were saved
+BEGIN;
+
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS seq BIGINT;
+
+UPDATE messages SET seq = numbered.seq
+FROM (
+    SELECT id, ROW_NUMBER() OVER (PARTITION BY room ORDER BY id) AS seq FROM messages
+) AS numbered
+WHERE messages.id = numbered.id AND messages.seq IS NULL;
+
+ALTER TABLE messages ALTER COLUMN seq SET NOT NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS messages_room_seq_idx ON messages (room, seq);
+
+-- last sequence number given to a message of every room
+CREATE TABLE IF NOT EXISTS room_sequences (
+    room VARCHAR(25) PRIMARY KEY,
+    seq BIGINT NOT NULL
+);
+
+INSERT INTO room_sequences (room, seq)
+SELECT room, MAX(seq) FROM messages GROUP BY room
+ON CONFLICT (room) DO NOTHING;
+
+COMMIT;